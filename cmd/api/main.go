@@ -1,17 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"shopscraper/pkg/database"
+	"shopscraper/pkg/models"
+	"shopscraper/pkg/utils"
+	"strconv"
+	"syscall"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
 var db database.Database
 
+// shutdownTimeout is how long in-flight requests may take to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
 func apiKeyMiddleware(next http.Handler, expectedApiKey string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		enableCors(&w)
@@ -44,7 +55,7 @@ func getProducts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	products, err := db.GetAllProducts()
+	products, err := db.GetAllProducts(r.Context())
 	if err != nil {
 		log.Printf("Failed to retrieve products: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -56,32 +67,128 @@ func getProducts(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func getProductHistory(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	productID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	// Optionally limit the history to the last number of days, e.g. ?days=90
+	since := time.Time{}
+	if days := r.URL.Query().Get("days"); days != "" {
+		daysInt, err := strconv.Atoi(days)
+		if err != nil || daysInt <= 0 {
+			http.Error(w, "Invalid number of days", http.StatusBadRequest)
+			return
+		}
+		since = utils.GetPastTimeThreshold(time.Duration(daysInt) * 24 * time.Hour)
+	}
+
+	exists, err := db.ProductExists(r.Context(), productID)
+	if err != nil {
+		log.Printf("Failed to look up product: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	history, err := db.GetPriceHistory(r.Context(), productID, since)
+	if err != nil {
+		log.Printf("Failed to retrieve price history: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if history == nil {
+		history = []models.PricePoint{}
+	}
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		log.Printf("Failed to encode price history: %v", err)
+		http.Error(w, "Error encoding price history", http.StatusInternalServerError)
+	}
+}
+
+// newRouter registers all API endpoints behind the API key middleware
+func newRouter(expectedApiKey string) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "OPTIONS" {
+				preflightHandler(w, r)
+			} else {
+				apiKeyMiddleware(handler, expectedApiKey).ServeHTTP(w, r)
+			}
+		})
+	}
+
+	handle("/products", getProducts)
+	handle("/products/{id}/history", getProductHistory)
+
+	return mux
+}
+
 func main() {
-	connectionString := os.Getenv("SHOPSCRAPER_DB_CONNECTION_STRING")
-	if connectionString == "" {
-		log.Fatalf("SHOPSCRAPER_DB_CONNECTION_STRING not provided")
+	if err := run(); err != nil {
+		log.Fatalf("error: %v", err)
 	}
-	db = database.NewPostgresDB()
-	db.Initialize(connectionString, "products")
-	defer db.Close()
+}
 
-	db.EnsureProductTableExists()
+// run serves the API until SIGINT or SIGTERM. Errors are returned rather
+// than exiting, so the database is closed on every path.
+func run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
+	connectionString := os.Getenv("SHOPSCRAPER_DB_CONNECTION_STRING")
+	if connectionString == "" {
+		return errors.New("SHOPSCRAPER_DB_CONNECTION_STRING not provided")
+	}
 	expectedApiKey := os.Getenv("SHOPSCRAPER_API_KEY")
 	if expectedApiKey == "" {
-		log.Fatalf("No API key specified")
+		return errors.New("no API key specified")
 	}
 
-	http.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "OPTIONS" {
-			preflightHandler(w, r)
-		} else {
-			apiKeyMiddleware(http.HandlerFunc(getProducts), expectedApiKey).ServeHTTP(w, r)
-		}
-	})
+	db = database.NewDatabase(connectionString)
+	if err := db.Initialize(ctx, connectionString, "products"); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.EnsureProductTableExists(ctx); err != nil {
+		return err
+	}
+
+	server := &http.Server{Addr: ":8080", Handler: newRouter(expectedApiKey)}
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Println("Starting server on :8080")
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
 
-	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal(err)
+	// Stop accepting connections and wait for in-flight requests before the
+	// database is closed
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down gracefully: %v", err)
 	}
+	return nil
 }
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"shopscraper/pkg/config"
 	"shopscraper/pkg/database"
 	"shopscraper/pkg/mailer"
+	"shopscraper/pkg/notifier"
+	"shopscraper/pkg/rules"
+	"shopscraper/pkg/runner"
+	"shopscraper/pkg/utils"
+	"syscall"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -16,15 +24,38 @@ import (
 var db database.Database
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+}
+
+// run sends the notifications once or, in daemon mode, until SIGINT or
+// SIGTERM. Errors are returned rather than exiting, so the database is closed
+// on every path.
+func run() error {
+	// SIGINT and SIGTERM stop the daemon between runs, a run in progress
+	// finishes so sent emails are always marked as notified
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize the database connection pool
 	connectionString := os.Getenv("SHOPSCRAPER_DB_CONNECTION_STRING")
 	if connectionString == "" {
-		log.Fatalf("SHOPSCRAPER_DB_CONNECTION_STRING not provided")
+		return errors.New("SHOPSCRAPER_DB_CONNECTION_STRING not provided")
+	}
+	db = database.NewDatabase(connectionString)
+	err := db.Initialize(ctx, connectionString, "products")
+	if err != nil {
+		return err
 	}
-	db = database.NewPostgresDB()
-	db.Initialize(connectionString, "products")
 	defer db.Close()
 
+	// Migrate the schema, the mailer may be the first to start after an upgrade
+	err = db.EnsureProductTableExists(ctx)
+	if err != nil {
+		return err
+	}
+
 	var configPath string
 	var daemonMode bool
 	var interval time.Duration
@@ -35,37 +66,34 @@ func main() {
 
 	programConfig, err := config.ReadConfig(configPath)
 	if err != nil {
-		log.Fatalf("error: %v", err)
+		return err
+	}
+	// Fail on invalid notification rules at start rather than on every run
+	if _, err := rules.New(programConfig.Rules, programConfig.Email, programConfig.Channels); err != nil {
+		return err
+	}
+	// The notifiers of every channel the products are dispatched to
+	notifiers, err := notifier.New(*programConfig, &mailer.RealSmtpSender{})
+	if err != nil {
+		return err
 	}
 
 	if daemonMode {
-		for {
-			getAndNotify(*programConfig)
+		for ctx.Err() == nil {
+			getAndNotify(ctx, notifiers, *programConfig)
 			fmt.Printf("Mailer run finished, waiting %s before next run..\n", interval.String())
-			time.Sleep(interval)
+			utils.SleepContext(ctx, interval)
 		}
+		log.Println("Shutting down")
 	} else {
-		getAndNotify(*programConfig)
+		getAndNotify(ctx, notifiers, *programConfig)
 	}
+	return nil
 }
 
-func getAndNotify(programConfig config.ProgramConfig) {
-	nonNotifiedProducts, err := db.GetNonNotifiedProducts()
+func getAndNotify(ctx context.Context, notifiers map[string][]notifier.Notifier, programConfig config.ProgramConfig) {
+	err := runner.Notify(ctx, db, notifiers, programConfig)
 	if err != nil {
-		log.Fatalf("error: %v", err)
-	}
-
-	if len(nonNotifiedProducts) > 0 {
-		err = mailer.SendEmail(&mailer.RealSmtpSender{}, nonNotifiedProducts, programConfig)
-		if err != nil {
-			log.Printf("error: %v", err)
-		} else {
-			err = db.SetNotifiedProducts(nonNotifiedProducts)
-			if err != nil {
-				log.Fatalf("error: %v", err)
-			}
-		}
-	} else {
-		log.Println("No products found to notify")
+		log.Printf("error: %v", err)
 	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"shopscraper/pkg/database"
+)
+
+const usage = `Usage: migrate [flags] <command>
+
+Commands:
+  status  list the schema migrations and whether they have been applied
+  up      apply all pending migrations
+  down    revert the latest migrations, one unless -steps is given
+
+Flags:
+`
+
+// errUsage is returned for an unknown command, which prints the usage
+var errUsage = errors.New("unknown command")
+
+func main() {
+	var steps int
+	flag.IntVar(&steps, "steps", 1, "number of migrations to revert with down")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), steps); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(flag.CommandLine.Output(), "unknown command '%s'\n\n", flag.Arg(0))
+			flag.Usage()
+			os.Exit(2)
+		}
+		log.Fatalf("error: %v", err)
+	}
+}
+
+// run runs command against the database. Errors are returned rather than
+// exiting, so the database is closed on every path.
+func run(command string, steps int) error {
+	ctx := context.Background()
+
+	connectionString := os.Getenv("SHOPSCRAPER_DB_CONNECTION_STRING")
+	if connectionString == "" {
+		return errors.New("SHOPSCRAPER_DB_CONNECTION_STRING not provided")
+	}
+	db := database.NewDatabase(connectionString)
+	if err := db.Initialize(ctx, connectionString, "products"); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, ok := db.(database.Migrator)
+	if !ok {
+		return fmt.Errorf("database %T does not support migrations", db)
+	}
+
+	switch command {
+	case "status":
+		statuses, err := migrator.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-30s %s\n", status.Version, status.Name, state)
+		}
+	case "up":
+		applied, err := migrator.MigrateUp(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migration(s)\n", applied)
+	case "down":
+		reverted, err := migrator.MigrateDown(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d migration(s)\n", reverted)
+	default:
+		return errUsage
+	}
+	return nil
+}
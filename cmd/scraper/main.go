@@ -1,134 +1,111 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"fmt"
 	"log"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"shopscraper/pkg/config"
 	"shopscraper/pkg/database"
-	"shopscraper/pkg/models"
+	"shopscraper/pkg/runner"
+	"shopscraper/pkg/scheduler"
 	"shopscraper/pkg/scraper"
 
 	_ "github.com/lib/pq"
 )
 
-var debugMode bool
-var db database.Database
-
-type Scraper = scraper.Scraper
-
 func main() {
-	// Initialize the database connection pool
-	connectionString := os.Getenv("SHOPSCRAPER_DB_CONNECTION_STRING")
-	if connectionString == "" {
-		log.Fatalf("SHOPSCRAPER_DB_CONNECTION_STRING not provided")
+	if err := run(); err != nil {
+		log.Fatalf("error: %v", err)
 	}
-	db = database.NewPostgresDB()
-	db.Initialize(connectionString, "products")
-	defer db.Close()
+}
 
+// run scrapes once or, in daemon mode, until SIGINT or SIGTERM. Errors are
+// returned rather than exiting, so the database and browser are closed on
+// every path.
+func run() error {
 	var configPath string
 	var daemonMode bool
-	var interval time.Duration
-	var maxWorkers int
-	var keepDuration time.Duration
+	var dryRun bool
+	var jitter time.Duration
+	var opts runner.Options
 	flag.BoolVar(&daemonMode, "daemon", false, "enable daemon mode")
-	flag.DurationVar(&interval, "interval", 1*time.Hour, "interval between scrapes (e.g., 30m, 1h, 2h45m)")
-	flag.DurationVar(&keepDuration, "keep-duration", 72*time.Hour, "duration to keep products in the database")
-	flag.IntVar(&maxWorkers, "max-workers", 3, "maximum number of workers per scraper")
-	flag.BoolVar(&debugMode, "debug", false, "enable debug mode")
+	flag.DurationVar(&opts.Interval, "interval", 1*time.Hour, "interval between scrapes of scrapers without a schedule (e.g., 30m, 1h, 2h45m)")
+	flag.DurationVar(&jitter, "jitter", 1*time.Minute, "maximum random delay added to every scheduled scrape (only applicable in daemon mode)")
+	flag.DurationVar(&opts.KeepDuration, "keep-duration", 72*time.Hour, "duration to keep products in the database")
+	flag.IntVar(&opts.MaxWorkers, "max-workers", 3, "maximum number of workers per scraper")
+	flag.BoolVar(&opts.Debug, "debug", false, "enable debug mode")
 	flag.StringVar(&configPath, "config-path", "./config/config.yaml", "path to configuration yaml file")
+	flag.DurationVar(&opts.RunTimeout, "run-timeout", 0, "maximum duration of scraping in a run, products scraped until then are still saved (0 for no limit)")
+	flag.BoolVar(&dryRun, "dry-run", false, "scrape into an in-memory database and print the results instead of saving them")
 	flag.Parse()
 
-	err := db.EnsureProductTableExists()
+	// SIGINT and SIGTERM abort scraping, the products scraped so far are still
+	// saved before the database is closed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var db database.Database
+	if dryRun {
+		// Every product is new in an empty database, so print them all
+		db = database.NewMemoryDB()
+		opts.Debug = true
+	} else {
+		// Initialize the database connection pool
+		connectionString := os.Getenv("SHOPSCRAPER_DB_CONNECTION_STRING")
+		if connectionString == "" {
+			return errors.New("SHOPSCRAPER_DB_CONNECTION_STRING not provided")
+		}
+		db = database.NewDatabase(connectionString)
+		if err := db.Initialize(ctx, connectionString, "products"); err != nil {
+			return err
+		}
+	}
+	defer db.Close()
+
+	err := db.EnsureProductTableExists(ctx)
 	if err != nil {
-		log.Fatalf("error: %v", err)
+		return err
 	}
 
 	programConfig, err := config.ReadConfig(configPath)
 	if err != nil {
-		log.Fatalf("error: %v", err)
+		return err
 	}
 
-	// Create a list of scrapers
-	scrapers, err := scraper.CreateScrapers(programConfig.Scrapers)
-	if err != nil {
-		log.Fatalf("error: %v", err)
+	// The scrapers share rate limits and the browser
+	shared := scraper.NewShared(*programConfig)
+	defer shared.Close()
+	if err := runner.LoadDetails(ctx, db, *programConfig, shared); err != nil {
+		return err
 	}
 
 	if daemonMode {
-		for {
-			runScrapers(scrapers, maxWorkers, keepDuration)
-			fmt.Printf("\nRun finished, waiting %s before next run..\n", interval.String())
-			time.Sleep(interval)
+		// Every scraper runs on its own schedule
+		jobs, err := runner.Jobs(db, *programConfig, shared, opts, nil)
+		if err != nil {
+			return err
 		}
-	} else {
-		runScrapers(scrapers, maxWorkers, keepDuration)
-	}
-}
-
-// runScrapers runs the scrapers and processes the results
-func runScrapers(scrapers []scraper.Scraper, maxWorkers int, keepDuration time.Duration) {
-	// Create channels to receive scraped products from each scraper
-	productChans := make([]chan []models.Product, len(scrapers))
-
-	// Run each scraper concurrently
-	var wg sync.WaitGroup
-
-	for i, scraper := range scrapers {
-		productChans[i] = make(chan []models.Product)
-		wg.Add(1)
-		go func(i int, scraper Scraper) {
-			defer wg.Done()
-			products, err := scraper.Scrape(maxWorkers)
-			if err != nil {
-				log.Printf("Failed to scrape using scraper %d: %v", i, err)
-			}
-			productChans[i] <- products
-
-		}(i, scraper)
-	}
-
-	// Close the channels after all scrapers have finished
-	go func() {
-		wg.Wait()
-		for _, ch := range productChans {
-			close(ch)
+		s := scheduler.New(jitter, true)
+		for _, job := range jobs {
+			s.Add(job)
 		}
-	}()
-
-	// Aggregate the output from each scraper into a single list
-	var allProducts []models.Product
-	for _, ch := range productChans {
-		products := <-ch
-		allProducts = append(allProducts, products...)
-	}
-
-	if debugMode {
-		log.Println("All products:")
-		for _, p := range allProducts {
-			fmt.Printf("%s - %s, Price: %d, Link: %s\n", p.Shop, p.Name, p.Price, p.Link)
+		s.Run(ctx)
+		log.Println("Shutting down")
+	} else {
+		// Create a list of scrapers
+		scrapers, err := scraper.CreateScrapers(programConfig.Scrapers, shared)
+		if err != nil {
+			return err
 		}
-	}
-
-	newProducts, err := db.SaveProducts(allProducts)
-	if err != nil {
-		log.Fatalf("error: %v", err)
-	}
-
-	// Remove items from the database that haven't been seen in 3 days or more
-	db.RemoveOldProducts(keepDuration)
-
-	if debugMode {
-		log.Println("New products:")
-		// Print all new products
-		for _, p := range newProducts {
-			fmt.Printf("%s - %s, Price: %d, Link: %s\n", p.Shop, p.Name, p.Price, p.Link)
+		if _, err := runner.Scrape(ctx, db, scrapers, opts); err != nil {
+			return err
 		}
 	}
-
+	return nil
 }
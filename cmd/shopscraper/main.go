@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/database"
+	"shopscraper/pkg/mailer"
+	"shopscraper/pkg/notifier"
+	"shopscraper/pkg/rules"
+	"shopscraper/pkg/runner"
+	"shopscraper/pkg/scheduler"
+	"shopscraper/pkg/scraper"
+
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+}
+
+// run runs every scraper on its own schedule and notifies about new products
+// and price changes after the scrapes that found them, replacing the separate
+// scraper and mailer daemons, until SIGINT or SIGTERM. Errors are returned
+// rather than exiting, so the database and browser are closed on every path.
+func run() error {
+	var configPath string
+	var jitter time.Duration
+	var notifyInterval time.Duration
+	var opts runner.Options
+	flag.DurationVar(&opts.Interval, "interval", 1*time.Hour, "interval between scrapes of scrapers without a schedule (e.g., 30m, 1h, 2h45m)")
+	flag.DurationVar(&jitter, "jitter", 1*time.Minute, "maximum random delay added to every scheduled scrape")
+	flag.DurationVar(&notifyInterval, "notify-interval", 5*time.Minute, "minimum interval between emails")
+	flag.DurationVar(&opts.KeepDuration, "keep-duration", 72*time.Hour, "duration to keep products in the database")
+	flag.IntVar(&opts.MaxWorkers, "max-workers", 3, "maximum number of workers per scraper")
+	flag.DurationVar(&opts.RunTimeout, "run-timeout", 0, "maximum duration of scraping in a run, products scraped until then are still saved (0 for no limit)")
+	flag.BoolVar(&opts.Debug, "debug", false, "enable debug mode")
+	flag.StringVar(&configPath, "config-path", "./config/config.yaml", "path to configuration yaml file")
+	flag.Parse()
+
+	// SIGINT and SIGTERM stop scheduling, scrapes in progress save what they
+	// have scraped and an email being sent is marked as notified
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	connectionString := os.Getenv("SHOPSCRAPER_DB_CONNECTION_STRING")
+	if connectionString == "" {
+		return errors.New("SHOPSCRAPER_DB_CONNECTION_STRING not provided")
+	}
+	db := database.NewDatabase(connectionString)
+	err := db.Initialize(ctx, connectionString, "products")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.EnsureProductTableExists(ctx)
+	if err != nil {
+		return err
+	}
+
+	programConfig, err := config.ReadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	// Fail on invalid notification rules at start rather than on every run
+	if _, err := rules.New(programConfig.Rules, programConfig.Email, programConfig.Channels); err != nil {
+		return err
+	}
+	notifiers, err := notifier.New(*programConfig, &mailer.RealSmtpSender{})
+	if err != nil {
+		return err
+	}
+
+	notify := scheduler.NewTrigger(notifyInterval, func(ctx context.Context) {
+		err := runner.Notify(ctx, db, notifiers, *programConfig)
+		if err != nil {
+			log.Printf("error: %v", err)
+		}
+	})
+
+	// The scrapers share rate limits and the browser
+	shared := scraper.NewShared(*programConfig)
+	defer shared.Close()
+	if err := runner.LoadDetails(ctx, db, *programConfig, shared); err != nil {
+		return err
+	}
+
+	// Notify after every scrape that found new products, price changes or
+	// products back in stock
+	jobs, err := runner.Jobs(db, *programConfig, shared, opts, func(result database.SaveResult) {
+		if len(result.New) > 0 || len(result.PriceChanged) > 0 || len(result.Restocked) > 0 {
+			notify.Fire()
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	s := scheduler.New(jitter, true)
+	for _, job := range jobs {
+		s.Add(job)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		notify.Run(ctx)
+	}()
+	// Send what was left unnotified by the previous run
+	notify.Fire()
+
+	s.Run(ctx)
+	wg.Wait()
+	log.Println("Shutting down")
+	return nil
+}
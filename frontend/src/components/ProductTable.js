@@ -46,6 +46,16 @@ const ProductTable = ({
   const [initialState, setInitialState] = useState(null);
 
   const columns = [
+    {
+      field: 'image',
+      headerName: 'Image',
+      minWidth: 70,
+      sortable: false,
+      filterable: false,
+      renderCell: (cellValues) => cellValues.value && (
+        <img src={cellValues.value} alt="" loading="lazy" style={{ maxHeight: '100%', maxWidth: '100%', objectFit: 'contain' }} />
+      ),
+    },
     {
       field: 'name',
       headerName: 'Name',
@@ -63,10 +73,16 @@ const ProductTable = ({
       headerName: 'Previous Price',
       type: 'number',
       minWidth: 120,
-      valueGetter: (params) => params.Valid ? params.Int64 : null
+      // Prices are returned by the API in minor units (cents)
+      valueGetter: (params) => params.Valid ? params.Int64 / 100 : null
     },
-    { field: 'price', headerName: 'Price', type: 'number', minWidth: 120 },
+    { field: 'price', headerName: 'Price', type: 'number', minWidth: 120, valueGetter: (value) => value / 100 },
+    { field: 'currency', headerName: 'Currency', minWidth: 80 },
     { field: 'shop', headerName: 'Shop', minWidth: 150 },
+    { field: 'brand', headerName: 'Brand', minWidth: 120 },
+    { field: 'availability', headerName: 'Availability', minWidth: 120 },
+    { field: 'sku', headerName: 'SKU', minWidth: 120 },
+    { field: 'description', headerName: 'Description', minWidth: 200 },
     {
       field: 'lastSeen',
       headerName: 'Last Seen',
@@ -82,6 +98,7 @@ const ProductTable = ({
       renderCell: (cellValues) => (timeAgo(cellValues.value)),
     },
     { field: 'notified', headerName: 'Notified', minWidth: 50 },
+    { field: 'changeReason', headerName: 'Change', minWidth: 100 },
   ];
 
   const rows = (products || []).map((product, index) => ({
@@ -89,11 +106,18 @@ const ProductTable = ({
     name: product.name,
     previousPrice: product.previousPrice,
     price: product.price,
+    currency: product.currency,
     shop: product.shop,
+    brand: product.brand,
+    availability: product.availability,
+    sku: product.sku,
+    description: product.description,
+    image: product.image,
     lastSeen: product.lastSeen,
     firstSeen: product.firstSeen,
     link: product.link,
     notified: product.notified,
+    changeReason: product.changeReason,
   }));
 
   useEffect(() => {
@@ -105,7 +129,10 @@ const ProductTable = ({
         columns: {
           columnVisibilityModel: {
             previousPrice: false,
-            notified: false
+            sku: false,
+            description: false,
+            notified: false,
+            changeReason: false
           }
         }
       });
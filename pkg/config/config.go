@@ -1,24 +1,220 @@
 package config
 
 import (
+	"net/textproto"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type ScraperConfig struct {
-	Type             string   `yaml:"type"`
-	ShopName         string   `yaml:"shopName"`
-	URLs             []string `yaml:"urls"`
-	ItemSelector     string   `yaml:"itemSelector"`
-	NameSelector     string   `yaml:"nameSelector"`
-	PriceSelector    []string `yaml:"priceSelector"`
-	LinkSelector     string   `yaml:"linkSelector"`
-	NextPageSelector string   `yaml:"nextPageSelector"`
-	PriceFormat      string   `yaml:"priceFormat"`
-	RetryString      string   `yaml:"retryString"`
-	UniqueParameters []string `yaml:"uniqueParameters"`
-	RemoveFragment   bool     `yaml:"removeFragment"`
+	Type                  string          `yaml:"type"`
+	ShopName              string          `yaml:"shopName"`
+	Schedule              string          `yaml:"schedule"`
+	URLs                  []string        `yaml:"urls"`
+	ItemSelector          string          `yaml:"itemSelector"`
+	NameSelector          string          `yaml:"nameSelector"`
+	PriceSelector         []string        `yaml:"priceSelector"`
+	LinkSelector          string          `yaml:"linkSelector"`
+	SKUSelector           string          `yaml:"skuSelector"`
+	SKUAttribute          string          `yaml:"skuAttribute"`
+	ImageSelector         string          `yaml:"imageSelector"`
+	ImageAttribute        string          `yaml:"imageAttribute"`
+	AvailabilitySelector  string          `yaml:"availabilitySelector"`
+	AvailabilityAttribute string          `yaml:"availabilityAttribute"`
+	BrandSelector         string          `yaml:"brandSelector"`
+	BrandAttribute        string          `yaml:"brandAttribute"`
+	Identity              string          `yaml:"identity"`
+	NextPageSelector      string          `yaml:"nextPageSelector"`
+	PriceFormat           string          `yaml:"priceFormat"`
+	PriceLocale           string          `yaml:"priceLocale"`
+	PricePattern          string          `yaml:"pricePattern"`
+	Currency              string          `yaml:"currency"`
+	RetryString           string          `yaml:"retryString"`
+	WaitSelector          string          `yaml:"waitSelector"`
+	NetworkIdle           bool            `yaml:"networkIdle"`
+	MaxWait               time.Duration   `yaml:"maxWait"`
+	ScrollTimes           int             `yaml:"scrollTimes"`
+	LoadMoreSelector      string          `yaml:"loadMoreSelector"`
+	MaxItems              int             `yaml:"maxItems"`
+	UniqueParameters      []string        `yaml:"uniqueParameters"`
+	RemoveFragment        bool            `yaml:"removeFragment"`
+	ExtractionMode        string          `yaml:"extractionMode"`
+	ItemsPath             string          `yaml:"itemsPath"`
+	NamePath              string          `yaml:"namePath"`
+	PricePath             string          `yaml:"pricePath"`
+	CurrencyPath          string          `yaml:"currencyPath"`
+	LinkPath              string          `yaml:"linkPath"`
+	SKUPath               string          `yaml:"skuPath"`
+	ImagePath             string          `yaml:"imagePath"`
+	AvailabilityPath      string          `yaml:"availabilityPath"`
+	BrandPath             string          `yaml:"brandPath"`
+	NextPagePath          string          `yaml:"nextPagePath"`
+	CursorParameter       string          `yaml:"cursorParameter"`
+	RateLimit             RateLimitConfig `yaml:"rateLimit"`
+	HTTP                  HTTPConfig      `yaml:"http"`
+	Retry                 RetryConfig     `yaml:"retry"`
+	Detail                *DetailConfig   `yaml:"detail"`
+}
+
+// DetailConfig configures following the link of every product to its detail
+// page, to extract fields the list pages lack
+type DetailConfig struct {
+	// PriceSelector replaces the price of the list page when it matches
+	PriceSelector         []string `yaml:"priceSelector"`
+	SKUSelector           string   `yaml:"skuSelector"`
+	SKUAttribute          string   `yaml:"skuAttribute"`
+	AvailabilitySelector  string   `yaml:"availabilitySelector"`
+	AvailabilityAttribute string   `yaml:"availabilityAttribute"`
+	ImageSelector         string   `yaml:"imageSelector"`
+	ImageAttribute        string   `yaml:"imageAttribute"`
+	BrandSelector         string   `yaml:"brandSelector"`
+	BrandAttribute        string   `yaml:"brandAttribute"`
+	// DescriptionSelector finds the product description, whose whitespace is
+	// collapsed
+	DescriptionSelector  string `yaml:"descriptionSelector"`
+	DescriptionAttribute string `yaml:"descriptionAttribute"`
+	// ExtractionMode "structured" reads the schema.org Product data of the
+	// detail page before applying the selectors
+	ExtractionMode string `yaml:"extractionMode"`
+	// CacheDuration is how long the details of a product whose name and
+	// price on the list page haven't changed are reused
+	CacheDuration time.Duration `yaml:"cacheDuration"`
+	// WaitSelector, NetworkIdle and MaxWait are the wait conditions of
+	// JavaScript detail pages, which lack the elements of the list pages
+	WaitSelector string        `yaml:"waitSelector"`
+	NetworkIdle  bool          `yaml:"networkIdle"`
+	MaxWait      time.Duration `yaml:"maxWait"`
+}
+
+// RetryConfig configures how failed page fetches are retried. Unset fields
+// of a scraper's retry configuration are taken from the global one.
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts including the first one
+	MaxAttempts int `yaml:"maxAttempts"`
+	// InitialBackoff is the delay before the first retry, doubling with every
+	// further retry
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	// MaxBackoff limits the delay between two attempts
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// Merge returns the retry configuration with unset fields taken from defaults
+func (r RetryConfig) Merge(defaults RetryConfig) RetryConfig {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = defaults.MaxAttempts
+	}
+	if r.InitialBackoff == 0 {
+		r.InitialBackoff = defaults.InitialBackoff
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = defaults.MaxBackoff
+	}
+	return r
+}
+
+// HTTPConfig configures the HTTP requests of a scraper. Unset fields of a
+// scraper's HTTP configuration are taken from the global one.
+type HTTPConfig struct {
+	// Headers are added to every request, replacing global headers of the
+	// same name
+	Headers   map[string]string `yaml:"headers"`
+	UserAgent string            `yaml:"userAgent"`
+	// Timeout limits a whole request including reading the response
+	Timeout time.Duration `yaml:"timeout"`
+	// Cookies keeps the cookies set by the shop between requests. A scraper's
+	// explicit false overrides a global true.
+	Cookies *bool `yaml:"cookies"`
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy
+	Proxy string    `yaml:"proxy"`
+	TLS   TLSConfig `yaml:"tls"`
+	// AcceptedStatusCodes are the status codes whose response is parsed,
+	// only 200 when empty
+	AcceptedStatusCodes []int `yaml:"acceptedStatusCodes"`
+}
+
+type TLSConfig struct {
+	// InsecureSkipVerify doesn't verify the certificate of the shop. A
+	// scraper's explicit false overrides a global true.
+	InsecureSkipVerify *bool `yaml:"insecureSkipVerify"`
+	// CAFile is a PEM file of certificates trusted in addition to the system's
+	CAFile string `yaml:"caFile"`
+	// MinVersion is the minimum TLS version, "1.2" or "1.3"
+	MinVersion string `yaml:"minVersion"`
+}
+
+// Merge returns the HTTP configuration with unset fields taken from defaults
+func (h HTTPConfig) Merge(defaults HTTPConfig) HTTPConfig {
+	if len(defaults.Headers) > 0 {
+		headers := make(map[string]string, len(defaults.Headers)+len(h.Headers))
+		for name, value := range defaults.Headers {
+			headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+		}
+		for name, value := range h.Headers {
+			headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+		}
+		h.Headers = headers
+	}
+	if h.UserAgent == "" {
+		h.UserAgent = defaults.UserAgent
+	}
+	if h.Timeout == 0 {
+		h.Timeout = defaults.Timeout
+	}
+	if h.Cookies == nil {
+		h.Cookies = defaults.Cookies
+	}
+	if h.Proxy == "" {
+		h.Proxy = defaults.Proxy
+	}
+	if h.TLS.InsecureSkipVerify == nil {
+		h.TLS.InsecureSkipVerify = defaults.TLS.InsecureSkipVerify
+	}
+	if h.TLS.CAFile == "" {
+		h.TLS.CAFile = defaults.TLS.CAFile
+	}
+	if h.TLS.MinVersion == "" {
+		h.TLS.MinVersion = defaults.TLS.MinVersion
+	}
+	if len(h.AcceptedStatusCodes) == 0 {
+		h.AcceptedStatusCodes = defaults.AcceptedStatusCodes
+	}
+	return h
+}
+
+// Enabled reports whether an optional flag is set to true
+func Enabled(flag *bool) bool {
+	return flag != nil && *flag
+}
+
+// RateLimitConfig limits how often a host is requested. Unset fields of a
+// scraper's rate limit are taken from the global rate limit, a negative
+// RequestsPerSecond or MinDelay switches off the global one.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the sustained request rate, 0 or negative for no
+	// limit
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	// Burst is how many requests may be made at once before the rate applies
+	Burst int `yaml:"burst"`
+	// MinDelay is the minimum time between two requests to the host, 0 or
+	// negative for none
+	MinDelay time.Duration `yaml:"minDelay"`
+}
+
+// Merge returns the rate limit with unset fields taken from defaults. Negative
+// fields are kept, so they switch off the limit of defaults.
+func (r RateLimitConfig) Merge(defaults RateLimitConfig) RateLimitConfig {
+	if r.RequestsPerSecond == 0 {
+		r.RequestsPerSecond = defaults.RequestsPerSecond
+	}
+	if r.Burst == 0 {
+		r.Burst = defaults.Burst
+	}
+	if r.MinDelay == 0 {
+		r.MinDelay = defaults.MinDelay
+	}
+	return r
 }
 
 type EmailConfig struct {
@@ -27,11 +223,124 @@ type EmailConfig struct {
 	Subject   string `yaml:"subject"`
 	Server    string `yaml:"server"`
 	Port      string `yaml:"port"`
+	// Reasons limits the products sent to the channel to those changed for
+	// these reasons, one of new, price_drop, price_rise and restock. All are
+	// sent when empty, the others are suppressed by the rules engine.
+	Reasons []string `yaml:"reasons"`
+}
+
+// Merge returns the email configuration with unset fields taken from defaults
+func (e EmailConfig) Merge(defaults EmailConfig) EmailConfig {
+	if e.Sender == "" {
+		e.Sender = defaults.Sender
+	}
+	if e.Recipient == "" {
+		e.Recipient = defaults.Recipient
+	}
+	if e.Subject == "" {
+		e.Subject = defaults.Subject
+	}
+	if e.Server == "" {
+		e.Server = defaults.Server
+	}
+	if e.Port == "" {
+		e.Port = defaults.Port
+	}
+	if len(e.Reasons) == 0 {
+		e.Reasons = defaults.Reasons
+	}
+	return e
+}
+
+// RuleConfig decides what happens to the products to notify that match all of
+// its conditions. Conditions that are unset match every product.
+type RuleConfig struct {
+	// Name identifies the rule in logs
+	Name string `yaml:"name"`
+	// Shops matches products of any of these shops
+	Shops []string `yaml:"shops"`
+	// Keywords matches products whose name contains any of these keywords,
+	// ignoring case
+	Keywords []string `yaml:"keywords"`
+	// NamePattern matches products whose name matches this regular expression
+	NamePattern string `yaml:"namePattern"`
+	// MinPrice and MaxPrice match products priced within this range, in
+	// major units such as euros
+	MinPrice float64 `yaml:"minPrice"`
+	MaxPrice float64 `yaml:"maxPrice"`
+	// MinDrop and MinDropPercent match products whose price dropped by at
+	// least this much from their previous price, in major units or percent
+	MinDrop        float64 `yaml:"minDrop"`
+	MinDropPercent float64 `yaml:"minDropPercent"`
+	// Reasons matches products changed for any of these reasons, one of new,
+	// price_drop, price_rise and restock
+	Reasons []string `yaml:"reasons"`
+	// Availability matches products with any of these stock statuses, one of
+	// in_stock, out_of_stock and unknown
+	Availability []string `yaml:"availability"`
+	// Action is notify (default), suppress or route
+	Action string `yaml:"action"`
+	// Channel is the channel the products are routed to
+	Channel string `yaml:"channel"`
+}
+
+// WebhookConfig posts the products to notify as JSON to an HTTP endpoint
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Headers are added to every request
+	Headers map[string]string `yaml:"headers"`
+	// Template is a text/template rendering the JSON payload. The channel and
+	// products are sent as a JSON object when empty.
+	Template string `yaml:"template"`
+	// Secret signs the payload with HMAC-SHA256 when set
+	Secret string `yaml:"secret"`
+	// SignatureHeader is the header of the signature, X-Shopscraper-Signature
+	// when empty
+	SignatureHeader string `yaml:"signatureHeader"`
+	// Timeout limits a whole request including reading the response
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// ChannelConfig is a destination that rules route products to
+type ChannelConfig struct {
+	// Email is sent with unset fields taken from the global email
+	// configuration. A channel with a webhook is only emailed when its email
+	// sets a recipient.
+	Email   EmailConfig   `yaml:"email"`
+	Webhook WebhookConfig `yaml:"webhook"`
+}
+
+// BrowserConfig configures the headless browser shared by the JavaScript
+// scrapers
+type BrowserConfig struct {
+	// MaxTabs is the maximum number of pages loaded at once
+	MaxTabs int `yaml:"maxTabs"`
+	// IdleTimeout is how long the browser is kept running without pages
+	// being loaded
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+}
+
+// RobotsConfig enables honouring the robots.txt of the scraped hosts
+type RobotsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// UserAgent is matched against the user-agent lines of robots.txt
+	UserAgent string `yaml:"userAgent"`
 }
 
 type ProgramConfig struct {
-	Scrapers []ScraperConfig `yaml:"scrapers"`
-	Email    EmailConfig     `yaml:"email"`
+	Scrapers  []ScraperConfig `yaml:"scrapers"`
+	Email     EmailConfig     `yaml:"email"`
+	Webhook   WebhookConfig   `yaml:"webhook"`
+	RateLimit RateLimitConfig `yaml:"rateLimit"`
+	Robots    RobotsConfig    `yaml:"robots"`
+	HTTP      HTTPConfig      `yaml:"http"`
+	Retry     RetryConfig     `yaml:"retry"`
+	Browser   BrowserConfig   `yaml:"browser"`
+	// Rules are evaluated in order for every product to notify, the first
+	// matching rule decides what happens to it. Products matching no rule
+	// are notified.
+	Rules    []RuleConfig             `yaml:"rules"`
+	Channels map[string]ChannelConfig `yaml:"channels"`
 }
 
 // readConfig reads the YAML configuration file and returns the ScraperConfig struct
@@ -0,0 +1,789 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"shopscraper/pkg/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runConformanceTests runs the tests every Database implementation must pass.
+// newDB must return an empty database with its tables created, which is
+// cleaned up when the test finishes.
+func runConformanceTests(t *testing.T, newDB func(t *testing.T) Database) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, db Database)
+	}{
+		{"GetAllProducts", testGetAllProducts},
+		{"GetNonNotifiedProducts", testGetNonNotifiedProducts},
+		{"SaveProducts", testSaveProducts},
+		{"SaveProductsBatch", testSaveProductsBatch},
+		{"GetPriceHistory", testGetPriceHistory},
+		{"ProductIdentity", testProductIdentity},
+		{"ProductAttributes", testProductAttributes},
+		{"Restock", testRestock},
+		{"ChangeReason", testChangeReason},
+		{"SetNotifiedProducts", testSetNotifiedProducts},
+		{"RemoveOldProducts", testRemoveOldProducts},
+		{"CancelledContext", testCancelledContext},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, newDB(t))
+		})
+	}
+}
+
+// findProduct returns the stored product with the given name
+func findProduct(t *testing.T, db Database, name string) models.Product {
+	ctx := context.Background()
+	products, err := db.GetAllProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get all products: %v", err)
+	}
+	for _, product := range products {
+		if product.Name == name {
+			return product
+		}
+	}
+	t.Fatalf("Product %s not found", name)
+	return models.Product{}
+}
+
+func testGetAllProducts(t *testing.T, db Database) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	_, err := db.SaveProducts(ctx, []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 10, Link: "https://example.com/product1", LastSeen: now, Notified: true},
+		{Name: "Product 2", Shop: "Shop 2", Price: 19, Link: "https://example.com/product2", LastSeen: now, Notified: false},
+	})
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+
+	products, err := db.GetAllProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get all products: %v", err)
+	}
+
+	expectedCount := 2
+	if len(products) != expectedCount {
+		t.Fatalf("Expected %d products, but got %d", expectedCount, len(products))
+	}
+
+	expectedProducts := []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 10, Currency: "EUR", Link: "https://example.com/product1", Notified: true},
+		{Name: "Product 2", Shop: "Shop 2", Price: 19, Currency: "EUR", Link: "https://example.com/product2", Notified: false},
+	}
+	for i, expected := range expectedProducts {
+		assert.NotZero(t, products[i].ID, "Product %d ID missing", i+1)
+		assert.Equal(t, expected.Name, products[i].Name, "Product %d Name mismatch", i+1)
+		assert.Equal(t, expected.Shop, products[i].Shop, "Product %d Shop mismatch", i+1)
+		assert.Equal(t, expected.Price, products[i].Price, "Product %d Price mismatch", i+1)
+		assert.Equal(t, expected.Currency, products[i].Currency, "Product %d Currency mismatch", i+1)
+		assert.Equal(t, expected.Link, products[i].Link, "Product %d Link mismatch", i+1)
+		assert.Equal(t, expected.Notified, products[i].Notified, "Product %d Notified status mismatch", i+1)
+		assert.False(t, products[i].PreviousPrice.Valid, "Product %d should not have a previous price", i+1)
+		assert.Equal(t, now.Round(time.Millisecond), products[i].FirstSeen.UTC().Round(time.Millisecond), "Product %d first seen mismatch", i+1)
+		assert.Equal(t, now.Round(time.Millisecond), products[i].LastSeen.UTC().Round(time.Millisecond), "Product %d last seen mismatch", i+1)
+	}
+}
+
+func testGetNonNotifiedProducts(t *testing.T, db Database) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	_, err := db.SaveProducts(ctx, []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 10, Link: "https://example.com/product1", LastSeen: now, Notified: true},
+		{Name: "Product 2", Shop: "Shop 2", Price: 19, Link: "https://example.com/product2", LastSeen: now, Notified: false},
+		{Name: "Product 3", Shop: "Shop 3", Price: 5, Link: "https://example.com/product3", LastSeen: now, Notified: false},
+	})
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+
+	products, err := db.GetNonNotifiedProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get non-notified products: %v", err)
+	}
+
+	expectedCount := 2
+	if len(products) != expectedCount {
+		t.Fatalf("Expected %d non-notified products, but got %d", expectedCount, len(products))
+	}
+
+	expectedProducts := []models.Product{
+		{Name: "Product 2", Shop: "Shop 2", Price: 19, Link: "https://example.com/product2", Notified: false},
+		{Name: "Product 3", Shop: "Shop 3", Price: 5, Link: "https://example.com/product3", Notified: false},
+	}
+	for i, expected := range expectedProducts {
+		assert.Equal(t, expected.Name, products[i].Name, "Name mismatch for product %d", i+1)
+		assert.Equal(t, expected.Shop, products[i].Shop, "Shop mismatch for product %d", i+1)
+		assert.Equal(t, expected.Price, products[i].Price, "Price mismatch for product %d", i+1)
+		assert.Equal(t, expected.Link, products[i].Link, "Link mismatch for product %d", i+1)
+		assert.Equal(t, expected.Notified, products[i].Notified, "Notified status mismatch for product %d", i+1)
+	}
+}
+
+func testSaveProducts(t *testing.T, db Database) {
+	ctx := context.Background()
+	// Test Case 1, add new products
+
+	initalTime := time.Now().UTC()
+	products := []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 10, Link: "https://example.com/product1", LastSeen: initalTime, Notified: true},
+		{Name: "Product 2", Shop: "Shop 2", Price: 19, Link: "https://example.com/product2", LastSeen: initalTime, Notified: true},
+		{Name: "Product 3", Shop: "Shop 3", Price: 5, Link: "https://example.com/product3", LastSeen: initalTime, Notified: false},
+	}
+
+	result, err := db.SaveProducts(ctx, products)
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+
+	// Assert the expected number of new products
+	expectedNewCount := 3
+	if len(result.New) != expectedNewCount {
+		t.Fatalf("Expected %d new products, but got %d", expectedNewCount, len(result.New))
+	}
+
+	// Assert the expected new product data
+	for i, expected := range products {
+		assert.NotZero(t, result.New[i].ID, "Product ID missing")
+		assert.Equal(t, expected.Name, result.New[i].Name, "Product Name mismatch")
+		assert.Equal(t, expected.Shop, result.New[i].Shop, "Product Shop mismatch")
+		assert.Equal(t, expected.Price, result.New[i].Price, "Product Price mismatch")
+		assert.Equal(t, expected.Link, result.New[i].Link, "Product Link mismatch")
+		expectedRounded := expected.LastSeen.UTC().Round(time.Millisecond)
+		newRounded := result.New[i].LastSeen.UTC().Round(time.Millisecond)
+		assert.Equal(t, expectedRounded.String(), newRounded.String(), "Product last seen timestamps do not match")
+		assert.Equal(t, expected.Notified, result.New[i].Notified, "Product Notified status mismatch")
+	}
+
+	// Test Case 2, Product with a price update
+	newTime := initalTime.Add(time.Minute)
+
+	// Update an existing product price
+	updatedProduct := models.Product{Name: "Product 1", Shop: "Shop 1", Price: 12, Link: "https://example.com/product1", LastSeen: newTime, Notified: true}
+
+	result, err = db.SaveProducts(ctx, []models.Product{updatedProduct})
+	if err != nil {
+		t.Fatalf("Failed to save updated product: %v", err)
+	}
+
+	// Assert that no new products were returned
+	if len(result.New) != 0 {
+		t.Errorf("Expected to see no new products, but got %d", len(result.New))
+	}
+
+	// Assert that the price change was returned with the previous price
+	if assert.Equal(t, 1, len(result.PriceChanged), "Expected one price change") {
+		assert.Equal(t, updatedProduct.Name, result.PriceChanged[0].Name, "Name mismatch")
+		assert.Equal(t, 12, result.PriceChanged[0].Price, "Price mismatch")
+		assert.Equal(t, sql.NullInt64{Int64: 10, Valid: true}, result.PriceChanged[0].PreviousPrice, "Previous price mismatch")
+		assert.NotZero(t, result.PriceChanged[0].ID, "Product ID missing")
+	}
+
+	// Retrieve the updated product from the database
+	retrievedProduct := findProduct(t, db, updatedProduct.Name)
+
+	// Assert the updated product data
+	assert.Equal(t, updatedProduct.Name, retrievedProduct.Name, "Name mismatch")
+	assert.Equal(t, updatedProduct.Shop, retrievedProduct.Shop, "Shop mismatch")
+	assert.Equal(t, updatedProduct.Price, retrievedProduct.Price, "Price mismatch")
+	if assert.True(t, retrievedProduct.PreviousPrice.Valid, "Expected PreviousPrice to be valid") {
+		assert.Equal(t, int64(10), retrievedProduct.PreviousPrice.Int64, "Previous price mismatch")
+	}
+	assert.Equal(t, updatedProduct.Link, retrievedProduct.Link, "Link mismatch")
+
+	updatedRounded := updatedProduct.LastSeen.UTC().Round(time.Millisecond)
+	retrievedRounded := retrievedProduct.LastSeen.UTC().Round(time.Millisecond)
+	assert.Equal(t, updatedRounded.String(), retrievedRounded.String(), "Product last seen timestamps do not match")
+
+	// First seen should be from the first insert
+	retrievedRoundedFirst := retrievedProduct.FirstSeen.UTC().Round(time.Millisecond)
+	assert.Equal(t, initalTime.Round(time.Millisecond), retrievedRoundedFirst)
+
+	// Notified should be reset to false when product changes price
+	assert.Equal(t, false, retrievedProduct.Notified, "Notified status mismatch")
+
+	// Test Case 3, Previous Price stays if price doesn't change
+	result, err = db.SaveProducts(ctx, []models.Product{updatedProduct})
+	if err != nil {
+		t.Fatalf("Failed to save updated product: %v", err)
+	}
+	assert.Empty(t, result.PriceChanged, "Expected no price changes")
+
+	// Assert that no new products were returned
+	if len(result.New) != 0 {
+		t.Errorf("Expected to see no new products, but got %d", len(result.New))
+	}
+
+	retrievedProduct = findProduct(t, db, updatedProduct.Name)
+	assert.Equal(t, updatedProduct.Price, retrievedProduct.Price, "Price mismatch")
+	if assert.True(t, retrievedProduct.PreviousPrice.Valid, "Expected PreviousPrice to be valid") {
+		assert.Equal(t, int64(10), retrievedProduct.PreviousPrice.Int64, "Previous price mismatch")
+	}
+	assert.Equal(t, false, retrievedProduct.Notified, "Notified status mismatch")
+
+	// Test Case 4, Product with no actual changes - Notified should stay True
+	newTime = newTime.Add(time.Minute)
+	updatedProduct = models.Product{Name: "Product 2", Shop: "Shop 2", Price: 19, Link: "https://example.com/product2", LastSeen: newTime, Notified: false}
+
+	result, err = db.SaveProducts(ctx, []models.Product{updatedProduct})
+	if err != nil {
+		t.Fatalf("Failed to save updated product: %v", err)
+	}
+
+	// Assert that no new products were returned
+	if len(result.New) != 0 {
+		t.Errorf("Expected no new products, but got %d", len(result.New))
+	}
+
+	retrievedProduct = findProduct(t, db, updatedProduct.Name)
+	assert.Equal(t, updatedProduct.Price, retrievedProduct.Price, "Price mismatch")
+	assert.False(t, retrievedProduct.PreviousPrice.Valid, "Expected no previous price")
+	updatedRounded = updatedProduct.LastSeen.UTC().Round(time.Millisecond)
+	retrievedRounded = retrievedProduct.LastSeen.UTC().Round(time.Millisecond)
+	assert.Equal(t, updatedRounded.String(), retrievedRounded.String(), "Product last seen timestamps do not match")
+	// Notified should stay true when no "real" data changes
+	assert.Equal(t, true, retrievedProduct.Notified, "Notified status mismatch")
+
+	// Test Case 5, A price change of less than one unit is detected
+	updatedProduct = models.Product{Name: "Product 2", Shop: "Shop 2", Price: 1949, Currency: "SEK", Link: "https://example.com/product2", LastSeen: newTime, Notified: false}
+	_, err = db.SaveProducts(ctx, []models.Product{updatedProduct})
+	if err != nil {
+		t.Fatalf("Failed to save updated product: %v", err)
+	}
+
+	updatedProduct.Price = 1899
+	_, err = db.SaveProducts(ctx, []models.Product{updatedProduct})
+	if err != nil {
+		t.Fatalf("Failed to save updated product: %v", err)
+	}
+
+	retrievedProduct = findProduct(t, db, updatedProduct.Name)
+	assert.Equal(t, 1899, retrievedProduct.Price, "Price mismatch")
+	assert.Equal(t, "SEK", retrievedProduct.Currency, "Currency mismatch")
+	if assert.True(t, retrievedProduct.PreviousPrice.Valid, "Expected PreviousPrice to be valid") {
+		assert.Equal(t, int64(1949), retrievedProduct.PreviousPrice.Int64, "Previous price mismatch")
+	}
+	assert.Equal(t, false, retrievedProduct.Notified, "Notified status mismatch")
+}
+
+func testSaveProductsBatch(t *testing.T, db Database) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	_, err := db.SaveProducts(ctx, []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 100, Link: "https://example.com/product1", LastSeen: now},
+		{Name: "Product 2", Shop: "Shop 1", Price: 200, Link: "https://example.com/product2", LastSeen: now},
+	})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+
+	// New, changed, unchanged and duplicated products in one batch, the last
+	// duplicate wins
+	later := now.Add(time.Minute)
+	result, err := db.SaveProducts(ctx, []models.Product{
+		{Name: "Product 3", Shop: "Shop 1", Price: 300, Link: "https://example.com/product3", LastSeen: later},
+		{Name: "Product 1", Shop: "Shop 1", Price: 90, Link: "https://example.com/product1", LastSeen: later},
+		{Name: "Product 2", Shop: "Shop 1", Price: 200, Link: "https://example.com/product2", LastSeen: later},
+		{Name: "Product 3", Shop: "Shop 1", Price: 310, Link: "https://example.com/product3", LastSeen: later},
+		{Name: "Product 1", Shop: "Shop 1", Price: 80, Link: "https://example.com/product1", LastSeen: later},
+	})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+
+	if assert.Equal(t, 1, len(result.New), "Expected one new product") {
+		assert.Equal(t, "Product 3", result.New[0].Name, "Name mismatch")
+		assert.Equal(t, 310, result.New[0].Price, "Price mismatch")
+	}
+	if assert.Equal(t, 1, len(result.PriceChanged), "Expected one price change") {
+		assert.Equal(t, "Product 1", result.PriceChanged[0].Name, "Name mismatch")
+		assert.Equal(t, 80, result.PriceChanged[0].Price, "Price mismatch")
+		assert.Equal(t, int64(100), result.PriceChanged[0].PreviousPrice.Int64, "Previous price mismatch")
+	}
+
+	products, err := db.GetAllProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get all products: %v", err)
+	}
+	assert.Equal(t, 3, len(products), "Expected three products")
+
+	// Saving nothing is not an error
+	result, err = db.SaveProducts(ctx, nil)
+	assert.NoError(t, err, "Saving no products should not produce an error")
+	assert.Empty(t, result.New, "Expected no new products")
+}
+
+func testGetPriceHistory(t *testing.T, db Database) {
+	ctx := context.Background()
+	firstTime := time.Now().UTC().Add(-2 * time.Hour)
+	secondTime := firstTime.Add(time.Hour)
+	thirdTime := secondTime.Add(time.Hour)
+
+	product := models.Product{Name: "Product 1", Shop: "Shop 1", Price: 1000, Link: "https://example.com/product1", LastSeen: firstTime}
+	result, err := db.SaveProducts(ctx, []models.Product{product})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	if len(result.New) != 1 {
+		t.Fatalf("Expected 1 new product, but got %d", len(result.New))
+	}
+	productID := result.New[0].ID
+	assert.NotZero(t, productID, "Expected saved product to have an ID")
+
+	// Price changes are recorded, an unchanged price is not
+	product.LastSeen = secondTime
+	product.Price = 950
+	_, err = db.SaveProducts(ctx, []models.Product{product})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	product.LastSeen = thirdTime
+	_, err = db.SaveProducts(ctx, []models.Product{product})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+
+	history, err := db.GetPriceHistory(ctx, productID, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to get price history: %v", err)
+	}
+
+	if assert.Equal(t, 2, len(history), "History length mismatch") {
+		assert.Equal(t, 1000, history[0].Price, "First price mismatch")
+		assert.Equal(t, "EUR", history[0].Currency, "First currency mismatch")
+		assert.Equal(t, firstTime.Round(time.Millisecond), history[0].ObservedAt.UTC().Round(time.Millisecond), "First observation time mismatch")
+		assert.Equal(t, 950, history[1].Price, "Second price mismatch")
+		assert.Equal(t, secondTime.Round(time.Millisecond), history[1].ObservedAt.UTC().Round(time.Millisecond), "Second observation time mismatch")
+	}
+
+	// Only points observed after since are returned
+	history, err = db.GetPriceHistory(ctx, productID, secondTime.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to get price history: %v", err)
+	}
+	if assert.Equal(t, 1, len(history), "History length mismatch") {
+		assert.Equal(t, 950, history[0].Price, "Price mismatch")
+	}
+
+	// Unknown products have no history
+	history, err = db.GetPriceHistory(ctx, productID+1000, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to get price history: %v", err)
+	}
+	assert.Empty(t, history, "Expected no history")
+
+	// Only stored products exist
+	exists, err := db.ProductExists(ctx, productID)
+	if err != nil {
+		t.Fatalf("Failed to check product exists: %v", err)
+	}
+	assert.True(t, exists, "Expected product to exist")
+	exists, err = db.ProductExists(ctx, productID+1000)
+	if err != nil {
+		t.Fatalf("Failed to check product exists: %v", err)
+	}
+	assert.False(t, exists, "Expected unknown product not to exist")
+}
+
+func testProductIdentity(t *testing.T, db Database) {
+	ctx := context.Background()
+	// Test Case 1, products saved with the default identity are adopted when
+	// the scraper switches to identifying products by link
+	product := models.Product{Name: "Product 1", Shop: "Shop 1", Price: 1000, Link: "https://example.com/product1", LastSeen: time.Now().UTC()}
+	result, err := db.SaveProducts(ctx, []models.Product{product})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	if len(result.New) != 1 {
+		t.Fatalf("Expected 1 new product, but got %d", len(result.New))
+	}
+	productID := result.New[0].ID
+
+	product.IdentityKey = models.ProductKey(models.IdentityLink, product)
+	result, err = db.SaveProducts(ctx, []models.Product{product})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	assert.Empty(t, result.New, "Expected the existing product to be adopted")
+
+	// Test Case 2, a renamed product keeps its row
+	product.Name = "Product 1 (new name)"
+	product.IdentityKey = models.ProductKey(models.IdentityLink, product)
+	result, err = db.SaveProducts(ctx, []models.Product{product})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	assert.Empty(t, result.New, "Expected no new products after a rename")
+
+	products, err := db.GetAllProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get all products: %v", err)
+	}
+	if assert.Equal(t, 1, len(products), "Expected a single product") {
+		assert.Equal(t, productID, products[0].ID, "ID mismatch")
+		assert.Equal(t, product.Name, products[0].Name, "Name mismatch")
+		assert.Equal(t, product.IdentityKey, products[0].IdentityKey, "Identity key mismatch")
+	}
+
+	// Test Case 3, products identified by SKU may change their link
+	skuProduct := models.Product{Name: "Product 2", Shop: "Shop 1", SKU: "4006381333931", Price: 500, Link: "https://example.com/product2", LastSeen: time.Now().UTC()}
+	skuProduct.IdentityKey = models.ProductKey(models.IdentitySKU, skuProduct)
+	result, err = db.SaveProducts(ctx, []models.Product{skuProduct})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	assert.Equal(t, 1, len(result.New), "Expected 1 new product")
+
+	skuProduct.Link = "https://example.com/product2?variant=1"
+	skuProduct.IdentityKey = models.ProductKey(models.IdentitySKU, skuProduct)
+	result, err = db.SaveProducts(ctx, []models.Product{skuProduct})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	assert.Empty(t, result.New, "Expected no new products after a link change")
+
+	stored := findProduct(t, db, skuProduct.Name)
+	assert.Equal(t, skuProduct.Link, stored.Link, "Link mismatch")
+	assert.Equal(t, skuProduct.SKU, stored.SKU, "SKU mismatch")
+
+	// Test Case 4, the same key in another shop is a different product
+	otherShop := skuProduct
+	otherShop.Shop = "Shop 2"
+	result, err = db.SaveProducts(ctx, []models.Product{otherShop})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	assert.Equal(t, 1, len(result.New), "Expected 1 new product")
+}
+
+func testProductAttributes(t *testing.T, db Database) {
+	ctx := context.Background()
+	// Test Case 1, the optional attributes are stored with a new product
+	product := models.Product{
+		Name:           "Product 1",
+		Shop:           "Shop 1",
+		Price:          1000,
+		Link:           "https://example.com/product1",
+		SKU:            "SKU-1",
+		Availability:   "InStock",
+		Brand:          "Brand 1",
+		Image:          "https://example.com/product1.jpg",
+		Description:    "A product",
+		DetailsFetched: time.Now().UTC().Add(-time.Hour),
+		LastSeen:       time.Now().UTC(),
+	}
+	if _, err := db.SaveProducts(ctx, []models.Product{product}); err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	stored := findProduct(t, db, product.Name)
+	assert.Equal(t, product.SKU, stored.SKU, "SKU mismatch")
+	assert.Equal(t, product.Availability, stored.Availability, "Availability mismatch")
+	assert.Equal(t, product.Brand, stored.Brand, "Brand mismatch")
+	assert.Equal(t, product.Image, stored.Image, "Image mismatch")
+	assert.Equal(t, product.Description, stored.Description, "Description mismatch")
+	assert.Equal(t, product.DetailsFetched.Round(time.Millisecond), stored.DetailsFetched.UTC().Round(time.Millisecond), "Details fetched mismatch")
+
+	// Test Case 2, the attributes are updated when the product is seen again
+	product.Availability = "OutOfStock"
+	product.Image = "https://example.com/product1-new.jpg"
+	product.Description = "A better product"
+	product.DetailsFetched = time.Now().UTC()
+	if _, err := db.SaveProducts(ctx, []models.Product{product}); err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	stored = findProduct(t, db, product.Name)
+	assert.Equal(t, product.Availability, stored.Availability, "Availability mismatch")
+	assert.Equal(t, product.Brand, stored.Brand, "Brand mismatch")
+	assert.Equal(t, product.Image, stored.Image, "Image mismatch")
+	assert.Equal(t, product.Description, stored.Description, "Description mismatch")
+	assert.Equal(t, product.DetailsFetched.Round(time.Millisecond), stored.DetailsFetched.UTC().Round(time.Millisecond), "Details fetched mismatch")
+
+	// Test Case 3, a product saved without details has no details fetched time
+	product.DetailsFetched = time.Time{}
+	if _, err := db.SaveProducts(ctx, []models.Product{product}); err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	stored = findProduct(t, db, product.Name)
+	assert.True(t, stored.DetailsFetched.IsZero(), "Expected no details fetched time")
+
+	nonNotified, err := db.GetNonNotifiedProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get non-notified products: %v", err)
+	}
+	if assert.Equal(t, 1, len(nonNotified), "Expected a single product") {
+		assert.Equal(t, product.Image, nonNotified[0].Image, "Image mismatch")
+		assert.Equal(t, product.Description, nonNotified[0].Description, "Description mismatch")
+	}
+}
+
+func testRestock(t *testing.T, db Database) {
+	ctx := context.Background()
+	product := models.Product{Name: "Product 1", Shop: "Shop 1", Price: 1000, Link: "https://example.com/product1", Availability: "InStock", LastSeen: time.Now().UTC()}
+	save := func(availability string) SaveResult {
+		t.Helper()
+		product.Availability = availability
+		result, err := db.SaveProducts(ctx, []models.Product{product})
+		if err != nil {
+			t.Fatalf("Failed to save products: %v", err)
+		}
+		return result
+	}
+	nonNotified := func() []models.Product {
+		t.Helper()
+		products, err := db.GetNonNotifiedProducts(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get non-notified products: %v", err)
+		}
+		return products
+	}
+
+	// Test Case 1, a new product in stock is not restocked
+	result := save("InStock")
+	assert.Equal(t, 1, len(result.New), "Expected 1 new product")
+	assert.Empty(t, result.Restocked, "Expected no restocked products")
+	if err := db.SetNotifiedProducts(ctx, nonNotified()); err != nil {
+		t.Fatalf("Failed to set notified products: %v", err)
+	}
+
+	// Test Case 2, selling out or losing the availability doesn't notify
+	result = save("OutOfStock")
+	assert.Empty(t, result.Restocked, "Expected no restocked products")
+	result = save("")
+	assert.Empty(t, result.Restocked, "Expected no restocked products")
+	assert.Empty(t, nonNotified(), "Expected no products to notify")
+
+	// Test Case 3, coming back in stock resets notified and records the reason
+	result = save("In stock")
+	if assert.Equal(t, 1, len(result.Restocked), "Expected 1 restocked product") {
+		assert.Equal(t, models.ChangeRestock, result.Restocked[0].ChangeReason, "Change reason mismatch")
+	}
+	assert.Empty(t, result.PriceChanged, "Expected no price changes")
+	products := nonNotified()
+	if assert.Equal(t, 1, len(products), "Expected 1 product to notify") {
+		assert.Equal(t, models.ChangeRestock, products[0].ChangeReason, "Change reason mismatch")
+	}
+
+	// Test Case 4, seeing it in stock again doesn't restock it twice
+	result = save("InStock")
+	assert.Empty(t, result.Restocked, "Expected no restocked products")
+	assert.Equal(t, models.ChangeRestock, findProduct(t, db, product.Name).ChangeReason, "Expected the restock to stay pending")
+
+	// Test Case 5, notifying clears the reason
+	if err := db.SetNotifiedProducts(ctx, nonNotified()); err != nil {
+		t.Fatalf("Failed to set notified products: %v", err)
+	}
+	assert.Equal(t, models.ChangeReason(""), findProduct(t, db, product.Name).ChangeReason, "Expected the restock to be cleared")
+
+	// Test Case 6, a product with an unknown availability is never restocked
+	product = models.Product{Name: "Product 2", Shop: "Shop 1", Price: 500, Link: "https://example.com/product2", LastSeen: time.Now().UTC()}
+	save("")
+	result = save("InStock")
+	assert.Empty(t, result.Restocked, "Expected no restocked products")
+
+	// Test Case 7, a sold out product that can be preordered again is restocked
+	product = models.Product{Name: "Product 3", Shop: "Shop 1", Price: 500, Link: "https://example.com/product3", LastSeen: time.Now().UTC()}
+	save("OutOfStock")
+	result = save("PreOrder")
+	assert.Equal(t, 1, len(result.Restocked), "Expected 1 restocked product")
+}
+
+func testChangeReason(t *testing.T, db Database) {
+	ctx := context.Background()
+	product := models.Product{Name: "Product 1", Shop: "Shop 1", Price: 1000, Link: "https://example.com/product1", Availability: "InStock", LastSeen: time.Now().UTC()}
+	save := func() SaveResult {
+		t.Helper()
+		result, err := db.SaveProducts(ctx, []models.Product{product})
+		if err != nil {
+			t.Fatalf("Failed to save products: %v", err)
+		}
+		return result
+	}
+	notify := func() {
+		t.Helper()
+		products, err := db.GetNonNotifiedProducts(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get non-notified products: %v", err)
+		}
+		if err := db.SetNotifiedProducts(ctx, products); err != nil {
+			t.Fatalf("Failed to set notified products: %v", err)
+		}
+	}
+	reason := func() models.ChangeReason {
+		t.Helper()
+		return findProduct(t, db, product.Name).ChangeReason
+	}
+
+	// Test Case 1, a new product stays new until notified, even when its price changes
+	result := save()
+	if assert.Equal(t, 1, len(result.New), "Expected 1 new product") {
+		assert.Equal(t, models.ChangeNew, result.New[0].ChangeReason, "Change reason mismatch")
+	}
+	product.Price = 900
+	save()
+	assert.Equal(t, models.ChangeNew, reason(), "Change reason mismatch")
+	notify()
+	assert.Equal(t, models.ChangeReason(""), reason(), "Expected no change reason once notified")
+
+	// Test Case 2, price drops and rises
+	product.Price = 800
+	result = save()
+	if assert.Equal(t, 1, len(result.PriceChanged), "Expected 1 price change") {
+		assert.Equal(t, models.ChangePriceDrop, result.PriceChanged[0].ChangeReason, "Change reason mismatch")
+	}
+	product.Price = 1200
+	result = save()
+	if assert.Equal(t, 1, len(result.PriceChanged), "Expected 1 price change") {
+		assert.Equal(t, models.ChangePriceRise, result.PriceChanged[0].ChangeReason, "Change reason mismatch")
+	}
+	notify()
+
+	// Test Case 3, a restock outweighs a price change
+	product.Availability = "OutOfStock"
+	save()
+	product.Availability = "InStock"
+	product.Price = 1100
+	save()
+	assert.Equal(t, models.ChangeRestock, reason(), "Change reason mismatch")
+
+	// Test Case 4, a restock selling out again before it is notified is dropped
+	product.Availability = "SoldOut"
+	save()
+	assert.Equal(t, models.ChangeReason(""), reason(), "Expected the restock to be dropped")
+	products, err := db.GetNonNotifiedProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get non-notified products: %v", err)
+	}
+	assert.Empty(t, products, "Expected no products to notify")
+}
+
+func testSetNotifiedProducts(t *testing.T, db Database) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	_, err := db.SaveProducts(ctx, []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 10, Link: "https://example.com/product1", LastSeen: now},
+		{Name: "Product 2", Shop: "Shop 1", Price: 20, Link: "https://example.com/product2", LastSeen: now},
+		{Name: "Product 3", Shop: "Shop 1", Price: 30, Link: "https://example.com/product3", LastSeen: now},
+	})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+
+	// Products read from the database are marked by ID
+	products, err := db.GetNonNotifiedProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get non-notified products: %v", err)
+	}
+	err = db.SetNotifiedProducts(ctx, products[:1])
+	if err != nil {
+		t.Fatalf("Failed to set notified products: %v", err)
+	}
+
+	// Scraped products without an ID are marked by identity key
+	err = db.SetNotifiedProducts(ctx, []models.Product{{Name: "Product 2", Shop: "Shop 1", Link: "https://example.com/product2"}})
+	if err != nil {
+		t.Fatalf("Failed to set notified products: %v", err)
+	}
+
+	products, err = db.GetNonNotifiedProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get non-notified products: %v", err)
+	}
+	if assert.Equal(t, 1, len(products), "Expected one non-notified product") {
+		assert.Equal(t, "Product 3", products[0].Name, "Name mismatch")
+	}
+}
+
+func testRemoveOldProducts(t *testing.T, db Database) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	result, err := db.SaveProducts(ctx, []models.Product{
+		{Name: "Old Product", Shop: "Shop 1", Price: 10, Link: "https://example.com/old", LastSeen: now.Add(-48 * time.Hour)},
+		{Name: "Recent Product", Shop: "Shop 1", Price: 20, Link: "https://example.com/recent", LastSeen: now},
+	})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	if len(result.New) != 2 {
+		t.Fatalf("Expected 2 new products, but got %d", len(result.New))
+	}
+
+	err = db.RemoveOldProducts(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to remove old products: %v", err)
+	}
+
+	products, err := db.GetAllProducts(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get all products: %v", err)
+	}
+	if assert.Equal(t, 1, len(products), "Expected one product to remain") {
+		assert.Equal(t, "Recent Product", products[0].Name, "Name mismatch")
+	}
+
+	// The history of removed products is removed with them
+	history, err := db.GetPriceHistory(ctx, result.New[0].ID, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to get price history: %v", err)
+	}
+	assert.Empty(t, history, "Expected no history for the removed product")
+
+	// A removed product that shows up again is new
+	result, err = db.SaveProducts(ctx, []models.Product{{Name: "Old Product", Shop: "Shop 1", Price: 10, Link: "https://example.com/old", LastSeen: now}})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	assert.Equal(t, 1, len(result.New), "Expected the removed product to be new again")
+}
+
+func testCancelledContext(t *testing.T, db Database) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Test Case 1, saving with a cancelled context fails without saving anything
+	_, err := db.SaveProducts(ctx, []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 10, Link: "https://example.com/product1", LastSeen: time.Now().UTC()},
+	})
+	assert.ErrorIs(t, err, context.Canceled, "Saving with a cancelled context should fail")
+
+	products, err := db.GetAllProducts(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get all products: %v", err)
+	}
+	assert.Empty(t, products, "Expected no products to be saved")
+
+	// Test Case 2, marking products as notified with a cancelled context fails
+	_, err = db.SaveProducts(context.Background(), []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 10, Link: "https://example.com/product1", LastSeen: time.Now().UTC()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to save products: %v", err)
+	}
+	err = db.SetNotifiedProducts(ctx, []models.Product{{Name: "Product 1", Shop: "Shop 1", Link: "https://example.com/product1"}})
+	assert.ErrorIs(t, err, context.Canceled, "Setting notified with a cancelled context should fail")
+
+	products, err = db.GetNonNotifiedProducts(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get non-notified products: %v", err)
+	}
+	assert.Equal(t, 1, len(products), "Expected the product to stay non-notified")
+
+	// Test Case 3, removing old products with a cancelled context fails
+	// without removing anything
+	err = db.RemoveOldProducts(ctx, -time.Hour)
+	assert.ErrorIs(t, err, context.Canceled, "Removing old products with a cancelled context should fail")
+
+	products, err = db.GetAllProducts(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get all products: %v", err)
+	}
+	assert.Equal(t, 1, len(products), "Expected the product to be kept")
+}
@@ -1,18 +1,135 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"shopscraper/pkg/models"
+	"strings"
 	"time"
 )
 
 type Database interface {
-	Initialize(connStr string, tableName string) error
+	Initialize(ctx context.Context, connStr string, tableName string) error
 	Close() error
-	EnsureProductTableExists() error
-	GetNonNotifiedProducts() ([]models.Product, error)
-	GetAllProducts() ([]models.Product, error)
-	SaveProducts(products []models.Product) ([]models.Product, error)
-	SetNotifiedProducts(products []models.Product) error
-	RemoveOldProducts(timeBack time.Duration) error
-	DropProductTable() error
+	EnsureProductTableExists(ctx context.Context) error
+	GetNonNotifiedProducts(ctx context.Context) ([]models.Product, error)
+	GetAllProducts(ctx context.Context) ([]models.Product, error)
+	ProductExists(ctx context.Context, productID int64) (bool, error)
+	GetPriceHistory(ctx context.Context, productID int64, since time.Time) ([]models.PricePoint, error)
+	SaveProducts(ctx context.Context, products []models.Product) (SaveResult, error)
+	SetNotifiedProducts(ctx context.Context, products []models.Product) error
+	RemoveOldProducts(ctx context.Context, timeBack time.Duration) error
+	DropProductTable(ctx context.Context) error
+}
+
+// SaveResult lists the saved products that are new, those whose price
+// changed and those that came back in stock, with their ID, identity key,
+// previous price and change reason filled in
+type SaveResult struct {
+	New          []models.Product
+	PriceChanged []models.Product
+	Restocked    []models.Product
+}
+
+// productChange is what changed about a product that was saved again
+type productChange struct {
+	priceChanged bool
+	priceDropped bool
+	// restocked is set when the product came back in stock
+	restocked bool
+	// soldOut is set when the product is known to be out of stock
+	soldOut bool
+}
+
+// reason returns why the product needs notifying after the change, given the
+// reason still pending from earlier saves. A product stays new until it is
+// notified and a restock outweighs price changes, while a restock that sells
+// out again before it is notified is dropped.
+func (c productChange) reason(pending models.ChangeReason) models.ChangeReason {
+	switch {
+	case c.restocked:
+		if pending == models.ChangeNew {
+			return pending
+		}
+		return models.ChangeRestock
+	case c.priceChanged:
+		if pending == models.ChangeNew || pending == models.ChangeRestock {
+			return pending
+		}
+		if c.priceDropped {
+			return models.ChangePriceDrop
+		}
+		return models.ChangePriceRise
+	case c.soldOut && pending == models.ChangeRestock:
+		return ""
+	}
+	return pending
+}
+
+// notified returns whether the product is notified after the change
+func (c productChange) notified(pending models.ChangeReason, notified bool) bool {
+	switch {
+	case c.priceChanged || c.restocked:
+		return false
+	case c.soldOut && pending == models.ChangeRestock:
+		return true
+	}
+	return notified
+}
+
+// inStock returns whether the product is in stock, NULL when its availability
+// doesn't tell
+func inStock(product models.Product) sql.NullBool {
+	inStock, known := product.InStock()
+	return sql.NullBool{Bool: inStock, Valid: known}
+}
+
+// nullTime returns t in UTC, NULL when it is zero
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// uniqueProducts removes products with the same shop and identity key, as a
+// batch can only update a row once. The last product wins but keeps the
+// position of the first, so results follow the order products were scraped in.
+func uniqueProducts(products []models.Product) []models.Product {
+	type key struct{ shop, identityKey string }
+	index := make(map[key]int, len(products))
+
+	unique := make([]models.Product, 0, len(products))
+	for _, product := range products {
+		product.IdentityKey = product.Key()
+		k := key{product.Shop, product.IdentityKey}
+		if i, ok := index[k]; ok {
+			unique[i] = product
+			continue
+		}
+		index[k] = len(unique)
+		unique = append(unique, product)
+	}
+	return unique
+}
+
+// NewDatabase returns the Database implementation for the scheme of the
+// connection string: "sqlite://" or "file:" selects SQLite, "memory://" an
+// in-memory database, anything else, such as "postgresql://" or a key=value
+// string, selects PostgreSQL
+func NewDatabase(connStr string) Database {
+	if strings.HasPrefix(connStr, "memory:") {
+		return NewMemoryDB()
+	}
+	if strings.HasPrefix(connStr, "sqlite:") || strings.HasPrefix(connStr, "file:") {
+		return NewSQLiteDB()
+	}
+	return NewPostgresDB()
+}
+
+// relatedTableName derives the name of a table that belongs to the product
+// table, e.g. "price_history" for "products" and "test_price_history_x" for
+// "test_products_x", so that separate product tables don't share data
+func relatedTableName(productTableName, name string) string {
+	if strings.Contains(productTableName, "products") {
+		return strings.Replace(productTableName, "products", name, 1)
+	}
+	return productTableName + "_" + name
 }
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"shopscraper/pkg/models"
+	"shopscraper/pkg/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryDB keeps products in memory with the same semantics as PostgresDB,
+// for tests and dry runs. Nothing is persisted when the process exits. Writes
+// fail without changes when their context is already done.
+type MemoryDB struct {
+	mu       sync.RWMutex
+	nextID   int64
+	products map[int64]*models.Product
+	keys     map[memoryKey]int64
+	history  map[int64][]models.PricePoint
+	// inStock is the last known stock status of each product, like the
+	// in_stock column
+	inStock map[int64]sql.NullBool
+}
+
+// memoryKey is the unique identity of a product, like UNIQUE (shop, identity_key)
+type memoryKey struct {
+	shop        string
+	identityKey string
+}
+
+func NewMemoryDB() *MemoryDB {
+	m := &MemoryDB{}
+	m.reset()
+	return m
+}
+
+func (m *MemoryDB) reset() {
+	m.products = make(map[int64]*models.Product)
+	m.keys = make(map[memoryKey]int64)
+	m.history = make(map[int64][]models.PricePoint)
+	m.inStock = make(map[int64]sql.NullBool)
+}
+
+func (m *MemoryDB) Initialize(ctx context.Context, connStr string, tableName string) error {
+	return nil
+}
+
+func (m *MemoryDB) Close() error {
+	return nil
+}
+
+func (m *MemoryDB) EnsureProductTableExists(ctx context.Context) error {
+	return nil
+}
+
+// sortedProducts returns copies of the products matching filter in insertion order
+func (m *MemoryDB) sortedProducts(filter func(p *models.Product) bool) []models.Product {
+	var products []models.Product
+	for _, p := range m.products {
+		if filter(p) {
+			products = append(products, *p)
+		}
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].ID < products[j].ID })
+	return products
+}
+
+func (m *MemoryDB) GetNonNotifiedProducts(ctx context.Context) ([]models.Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedProducts(func(p *models.Product) bool { return !p.Notified }), nil
+}
+
+func (m *MemoryDB) GetAllProducts(ctx context.Context) ([]models.Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedProducts(func(p *models.Product) bool { return true }), nil
+}
+
+func (m *MemoryDB) SaveProducts(ctx context.Context, products []models.Product) (SaveResult, error) {
+	if err := ctx.Err(); err != nil {
+		return SaveResult{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result SaveResult
+	for _, product := range uniqueProducts(products) {
+		key := memoryKey{shop: product.Shop, identityKey: product.IdentityKey}
+		product.Currency = product.CurrencyCode()
+		product.LastSeen = product.LastSeen.UTC()
+
+		// Products saved before their scraper switched identity strategy still have
+		// the default key, adopt those rows instead of inserting duplicates
+		defaultKey := memoryKey{shop: product.Shop, identityKey: models.ProductKey(models.IdentityNameLink, product)}
+		if _, exists := m.keys[key]; !exists && defaultKey != key {
+			if id, ok := m.keys[defaultKey]; ok {
+				delete(m.keys, defaultKey)
+				m.keys[key] = id
+				m.products[id].IdentityKey = key.identityKey
+			}
+		}
+
+		if id, ok := m.keys[key]; ok {
+			existing := m.products[id]
+			// A change of price or currency, or coming back in stock, resets
+			// notified so the change is picked up by the mailer. A product whose
+			// availability is unknown keeps its stock status.
+			wasInStock, isInStock := m.inStock[id], inStock(product)
+			if !isInStock.Valid {
+				isInStock = wasInStock
+			}
+			change := productChange{
+				priceChanged: existing.Price != product.Price || existing.Currency != product.Currency,
+				priceDropped: product.Price < existing.Price,
+				restocked:    wasInStock.Valid && !wasInStock.Bool && isInStock.Valid && isInStock.Bool,
+				soldOut:      isInStock.Valid && !isInStock.Bool,
+			}
+			if change.priceChanged {
+				existing.PreviousPrice = sql.NullInt64{Int64: int64(existing.Price), Valid: true}
+			}
+			existing.Notified = change.notified(existing.ChangeReason, existing.Notified)
+			existing.ChangeReason = change.reason(existing.ChangeReason)
+			m.inStock[id] = isInStock
+			existing.Name = product.Name
+			existing.SKU = product.SKU
+			existing.Availability = product.Availability
+			existing.Brand = product.Brand
+			existing.Image = product.Image
+			existing.Description = product.Description
+			existing.DetailsFetched = product.DetailsFetched
+			existing.Link = product.Link
+			existing.Price = product.Price
+			existing.Currency = product.Currency
+			existing.LastSeen = product.LastSeen
+			product.ID = id
+			product.PreviousPrice = existing.PreviousPrice
+			product.FirstSeen = existing.FirstSeen
+			product.ChangeReason = existing.ChangeReason
+			if change.priceChanged {
+				result.PriceChanged = append(result.PriceChanged, product)
+			}
+			if change.restocked {
+				result.Restocked = append(result.Restocked, product)
+			}
+		} else {
+			m.nextID++
+			product.ID = m.nextID
+			product.PreviousPrice = sql.NullInt64{}
+			product.FirstSeen = product.LastSeen
+			product.ChangeReason = models.ChangeNew
+			m.inStock[product.ID] = inStock(product)
+			stored := product
+			m.products[product.ID] = &stored
+			m.keys[key] = product.ID
+			result.New = append(result.New, product)
+		}
+
+		// Record the price in the history unless it matches the latest recorded price
+		history := m.history[product.ID]
+		if len(history) == 0 || history[len(history)-1].Price != product.Price || history[len(history)-1].Currency != product.Currency {
+			m.history[product.ID] = append(history, models.PricePoint{Price: product.Price, Currency: product.Currency, ObservedAt: product.LastSeen})
+		}
+	}
+
+	return result, nil
+}
+
+func (m *MemoryDB) ProductExists(ctx context.Context, productID int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.products[productID]
+	return ok, nil
+}
+
+func (m *MemoryDB) GetPriceHistory(ctx context.Context, productID int64, since time.Time) ([]models.PricePoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var history []models.PricePoint
+	for _, point := range m.history[productID] {
+		if !point.ObservedAt.Before(since) {
+			history = append(history, point)
+		}
+	}
+	return history, nil
+}
+
+func (m *MemoryDB) SetNotifiedProducts(ctx context.Context, products []models.Product) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Update notified status by id when the product was read from the database
+	// and by identity key otherwise
+	for _, product := range products {
+		id := product.ID
+		if id == 0 {
+			id = m.keys[memoryKey{shop: product.Shop, identityKey: product.Key()}]
+		}
+		if stored, ok := m.products[id]; ok {
+			stored.Notified = true
+			stored.ChangeReason = ""
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDB) RemoveOldProducts(ctx context.Context, timeBack time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	threshold := utils.GetPastTimeThreshold(timeBack)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, product := range m.products {
+		if product.LastSeen.Before(threshold) {
+			delete(m.products, id)
+			delete(m.keys, memoryKey{shop: product.Shop, identityKey: product.IdentityKey})
+			delete(m.history, id)
+			delete(m.inStock, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDB) DropProductTable(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reset()
+	return nil
+}
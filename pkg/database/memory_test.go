@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"shopscraper/pkg/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryDB(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) Database {
+		return NewMemoryDB()
+	})
+}
+
+func TestMemoryDBConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				product := models.Product{Name: fmt.Sprintf("Product %d", j), Shop: fmt.Sprintf("Shop %d", i), Price: j, Link: fmt.Sprintf("https://example.com/%d", j), LastSeen: time.Now().UTC()}
+				_, err := db.SaveProducts(ctx, []models.Product{product})
+				assert.NoError(t, err, "Saving products should not produce an error")
+
+				products, err := db.GetNonNotifiedProducts(ctx)
+				assert.NoError(t, err, "Getting products should not produce an error")
+				assert.NoError(t, db.SetNotifiedProducts(ctx, products), "Setting notified should not produce an error")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	products, err := db.GetAllProducts(ctx)
+	assert.NoError(t, err, "Getting products should not produce an error")
+	assert.Equal(t, 100, len(products), "Expected every product to be saved once")
+
+	// Returned products are copies that don't change the stored products
+	products[0].Price = 12345
+	stored, err := db.GetAllProducts(ctx)
+	assert.NoError(t, err, "Getting products should not produce an error")
+	assert.NotEqual(t, 12345, stored[0].Price, "Stored product should not change")
+}
@@ -0,0 +1,272 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is a numbered schema change with the SQL to apply and revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator is implemented by databases whose schema is managed by versioned migrations
+type Migrator interface {
+	// MigrationStatus lists every known migration and whether it has been applied
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	// MigrateUp applies all pending migrations and returns how many were applied
+	MigrateUp(ctx context.Context) (int, error)
+	// MigrateDown reverts the latest steps migrations and returns how many were reverted
+	MigrateDown(ctx context.Context, steps int) (int, error)
+}
+
+// loadMigrations reads the migrations of a dialect from files named
+// "<version>_<name>.up.sql" and "<version>_<name>.down.sql". The placeholders
+// {{products}} and {{price_history}} are replaced with the table names.
+func loadMigrations(dialect string, tables *strings.Replacer) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		versionText, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionText)
+		if !ok || !found || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name '%s'", entry.Name())
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names '%s' and '%s'", version, migration.Name, name)
+		}
+		if direction == "up" {
+			migration.Up = tables.Replace(string(content))
+		} else {
+			migration.Down = tables.Replace(string(content))
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrator applies migrations and records them in the schema migrations table
+type migrator struct {
+	db              *sql.DB
+	dialect         string
+	migrationsTable string
+	tables          *strings.Replacer
+	// advisoryLock serializes migrations between processes with a PostgreSQL
+	// advisory lock, SQLite relies on its immediate transactions instead
+	advisoryLock bool
+}
+
+func newMigrator(db *sql.DB, dialect, productTableName, historyTableName string) *migrator {
+	return &migrator{
+		db:              db,
+		dialect:         dialect,
+		migrationsTable: relatedTableName(productTableName, "schema_migrations"),
+		tables:          strings.NewReplacer("{{products}}", productTableName, "{{price_history}}", historyTableName),
+		advisoryLock:    dialect == "postgres",
+	}
+}
+
+// withLock runs fn on a single connection while holding the migration lock,
+// so the scraper, mailer and api starting at the same time don't race
+func (m *migrator) withLock(ctx context.Context, fn func(ctx context.Context, conn *sql.Conn, migrations []Migration) error) error {
+	migrations, err := loadMigrations(m.dialect, m.tables)
+	if err != nil {
+		return err
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.advisoryLock {
+		// Advisory locks belong to the session, so lock and unlock on the same connection
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", m.migrationsTable)
+		if err != nil {
+			return err
+		}
+		// Unlock even when ctx is cancelled, the connection returns to the pool
+		defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(hashtext($1))", m.migrationsTable)
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+m.migrationsTable+` (
+            version BIGINT PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMP NOT NULL
+        )`)
+	if err != nil {
+		return err
+	}
+
+	return fn(ctx, conn, migrations)
+}
+
+func (m *migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(ctx context.Context, conn *sql.Conn, migrations []Migration) error {
+		rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM "+m.migrationsTable)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		applied := make(map[int]time.Time)
+		for rows.Next() {
+			var version int
+			var appliedAt time.Time
+			if err := rows.Scan(&version, &appliedAt); err != nil {
+				return err
+			}
+			applied[version] = appliedAt
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			appliedAt, ok := applied[migration.Version]
+			statuses = append(statuses, MigrationStatus{Version: migration.Version, Name: migration.Name, Applied: ok, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(ctx context.Context, conn *sql.Conn, migrations []Migration) error {
+		for _, migration := range migrations {
+			applied, err := m.apply(ctx, conn, migration)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+			if applied {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// apply runs a migration in a transaction unless it has already been applied
+func (m *migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration) (bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var applied bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+m.migrationsTable+" WHERE version = $1)", migration.Version).Scan(&applied)
+	if err != nil || applied {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, migration.Up)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+m.migrationsTable+" (version, name, applied_at) VALUES ($1, $2, $3)", migration.Version, migration.Name, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (m *migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(ctx context.Context, conn *sql.Conn, migrations []Migration) error {
+		for count < steps {
+			reverted, err := m.revertLatest(ctx, conn, migrations)
+			if err != nil || !reverted {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// revertLatest reverts the latest applied migration in a transaction and
+// reports false when no migration is applied
+func (m *migrator) revertLatest(ctx context.Context, conn *sql.Conn, migrations []Migration) (bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var latest sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT MAX(version) FROM "+m.migrationsTable).Scan(&latest)
+	if err != nil || !latest.Valid {
+		return false, err
+	}
+
+	var migration *Migration
+	for i := range migrations {
+		if int64(migrations[i].Version) == latest.Int64 {
+			migration = &migrations[i]
+		}
+	}
+	if migration == nil {
+		return false, fmt.Errorf("applied migration %d is unknown to this version", latest.Int64)
+	}
+
+	_, err = tx.ExecContext(ctx, migration.Down)
+	if err != nil {
+		return false, fmt.Errorf("reverting migration %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM "+m.migrationsTable+" WHERE version = $1", migration.Version)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS {{products}} (
+    name TEXT,
+    shop TEXT,
+    previous_price INT,
+    price INT,
+    link TEXT,
+    first_seen TIMESTAMP,
+    last_seen TIMESTAMP,
+    notified BOOLEAN,
+    UNIQUE (name, shop, link)
+);
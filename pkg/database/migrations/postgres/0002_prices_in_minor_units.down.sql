@@ -0,0 +1,5 @@
+UPDATE {{products}} SET price = price / 100, previous_price = previous_price / 100;
+ALTER TABLE {{products}}
+    DROP COLUMN currency,
+    ALTER COLUMN price TYPE INT,
+    ALTER COLUMN previous_price TYPE INT;
@@ -0,0 +1,16 @@
+-- Store prices in minor units (cents) together with their currency. Tables
+-- upgraded before migrations were versioned already have the currency column.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_attribute
+        WHERE attrelid = '{{products}}'::regclass AND attname = 'currency' AND NOT attisdropped
+    ) THEN
+        ALTER TABLE {{products}}
+            ADD COLUMN currency TEXT NOT NULL DEFAULT 'EUR',
+            ALTER COLUMN price TYPE BIGINT,
+            ALTER COLUMN previous_price TYPE BIGINT;
+        UPDATE {{products}} SET price = price * 100, previous_price = previous_price * 100;
+    END IF;
+END
+$$;
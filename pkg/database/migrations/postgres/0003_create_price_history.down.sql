@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS {{price_history}};
+ALTER TABLE {{products}} DROP COLUMN id;
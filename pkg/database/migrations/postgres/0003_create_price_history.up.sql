@@ -0,0 +1,18 @@
+ALTER TABLE {{products}} ADD COLUMN IF NOT EXISTS id BIGSERIAL PRIMARY KEY;
+
+CREATE TABLE IF NOT EXISTS {{price_history}} (
+    product_id BIGINT NOT NULL REFERENCES {{products}} (id) ON DELETE CASCADE,
+    price BIGINT NOT NULL,
+    currency TEXT NOT NULL,
+    observed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS {{price_history}}_product_id_idx ON {{price_history}} (product_id, observed_at);
+
+-- Seed an empty history with the prices already known for existing products
+INSERT INTO {{price_history}} (product_id, price, currency, observed_at)
+SELECT id, previous_price, currency, first_seen FROM {{products}}
+WHERE previous_price IS NOT NULL AND NOT EXISTS (SELECT 1 FROM {{price_history}})
+UNION ALL
+SELECT id, price, currency, last_seen FROM {{products}}
+WHERE NOT EXISTS (SELECT 1 FROM {{price_history}});
@@ -0,0 +1,5 @@
+-- Fails if products with the same name and link were saved under different identity keys
+ALTER TABLE {{products}}
+    DROP COLUMN identity_key,
+    DROP COLUMN sku,
+    ADD UNIQUE (name, shop, link);
@@ -0,0 +1,29 @@
+-- Identify products by shop and identity key instead of name, shop and link.
+-- Existing rows get the key of the default identity strategy, which must match
+-- models.ProductKey for models.IdentityNameLink.
+DO $$
+DECLARE
+    unique_constraint TEXT;
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_attribute
+        WHERE attrelid = '{{products}}'::regclass AND attname = 'identity_key' AND NOT attisdropped
+    ) THEN
+        ALTER TABLE {{products}}
+            ADD COLUMN identity_key TEXT,
+            ADD COLUMN sku TEXT NOT NULL DEFAULT '';
+        UPDATE {{products}} SET identity_key = 'name_link:' || COALESCE(name, '') || E'\n' || COALESCE(link, '');
+
+        -- The generated name of the old constraint depends on the table name
+        FOR unique_constraint IN
+            SELECT conname FROM pg_constraint WHERE conrelid = '{{products}}'::regclass AND contype = 'u'
+        LOOP
+            EXECUTE format('ALTER TABLE {{products}} DROP CONSTRAINT %I', unique_constraint);
+        END LOOP;
+
+        ALTER TABLE {{products}}
+            ALTER COLUMN identity_key SET NOT NULL,
+            ADD UNIQUE (shop, identity_key);
+    END IF;
+END
+$$;
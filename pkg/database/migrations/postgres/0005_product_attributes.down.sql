@@ -0,0 +1,4 @@
+ALTER TABLE {{products}}
+    DROP COLUMN availability,
+    DROP COLUMN brand,
+    DROP COLUMN image;
@@ -0,0 +1,5 @@
+-- Optional product attributes found by the scrapers, empty when unknown
+ALTER TABLE {{products}}
+    ADD COLUMN IF NOT EXISTS availability TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS brand TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS image TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE {{products}}
+    DROP COLUMN in_stock,
+    DROP COLUMN restocked;
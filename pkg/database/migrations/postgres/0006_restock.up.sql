@@ -0,0 +1,6 @@
+-- in_stock is derived from availability when saving, NULL when it is unknown.
+-- restocked records that the product came back in stock since it was last
+-- notified.
+ALTER TABLE {{products}}
+    ADD COLUMN IF NOT EXISTS in_stock BOOLEAN,
+    ADD COLUMN IF NOT EXISTS restocked BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,5 @@
+ALTER TABLE {{products}} ADD COLUMN restocked BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE {{products}} SET restocked = true WHERE change_reason = 'restock';
+
+ALTER TABLE {{products}} DROP COLUMN change_reason;
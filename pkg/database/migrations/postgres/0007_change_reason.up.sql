@@ -0,0 +1,14 @@
+-- Why a product needs notifying, empty once it is notified. Products that
+-- aren't notified yet get the reason their prices and restocked tell, which
+-- the reason replaces.
+ALTER TABLE {{products}} ADD COLUMN IF NOT EXISTS change_reason TEXT NOT NULL DEFAULT '';
+
+UPDATE {{products}} SET change_reason = CASE
+    WHEN restocked THEN 'restock'
+    WHEN previous_price IS NULL THEN 'new'
+    WHEN price < previous_price THEN 'price_drop'
+    ELSE 'price_rise'
+END
+WHERE notified = false;
+
+ALTER TABLE {{products}} DROP COLUMN restocked;
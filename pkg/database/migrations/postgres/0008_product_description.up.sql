@@ -0,0 +1,3 @@
+-- Description found on the detail page, empty when unknown
+ALTER TABLE {{products}}
+    ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- When the details of the product were fetched from its detail page, NULL
+-- when it has none
+ALTER TABLE {{products}}
+    ADD COLUMN IF NOT EXISTS details_fetched TIMESTAMP;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS {{products}} (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT,
+    shop TEXT,
+    identity_key TEXT NOT NULL,
+    sku TEXT NOT NULL DEFAULT '',
+    previous_price INTEGER,
+    price INTEGER,
+    currency TEXT NOT NULL DEFAULT 'EUR',
+    link TEXT,
+    first_seen TIMESTAMP,
+    last_seen TIMESTAMP,
+    notified BOOLEAN,
+    UNIQUE (shop, identity_key)
+);
+
+CREATE TABLE IF NOT EXISTS {{price_history}} (
+    product_id INTEGER NOT NULL REFERENCES {{products}} (id) ON DELETE CASCADE,
+    price INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    observed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS {{price_history}}_product_id_idx ON {{price_history}} (product_id, observed_at);
@@ -0,0 +1,3 @@
+ALTER TABLE {{products}} DROP COLUMN availability;
+ALTER TABLE {{products}} DROP COLUMN brand;
+ALTER TABLE {{products}} DROP COLUMN image;
@@ -0,0 +1,4 @@
+-- Optional product attributes found by the scrapers, empty when unknown
+ALTER TABLE {{products}} ADD COLUMN availability TEXT NOT NULL DEFAULT '';
+ALTER TABLE {{products}} ADD COLUMN brand TEXT NOT NULL DEFAULT '';
+ALTER TABLE {{products}} ADD COLUMN image TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE {{products}} DROP COLUMN in_stock;
+ALTER TABLE {{products}} DROP COLUMN restocked;
@@ -0,0 +1,5 @@
+-- in_stock is derived from availability when saving, NULL when it is unknown.
+-- restocked records that the product came back in stock since it was last
+-- notified.
+ALTER TABLE {{products}} ADD COLUMN in_stock BOOLEAN;
+ALTER TABLE {{products}} ADD COLUMN restocked BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- Description found on the detail page, empty when unknown
+ALTER TABLE {{products}} ADD COLUMN description TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- When the details of the product were fetched from its detail page, NULL
+-- when it has none
+ALTER TABLE {{products}} ADD COLUMN details_fetched TIMESTAMP;
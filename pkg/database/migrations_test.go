@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shopscraper/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tables := strings.NewReplacer("{{products}}", "test_products", "{{price_history}}", "test_price_history")
+
+	for _, dialect := range []string{"postgres", "sqlite"} {
+		migrations, err := loadMigrations(dialect, tables)
+		if err != nil {
+			t.Fatalf("Failed to load %s migrations: %v", dialect, err)
+		}
+		if !assert.NotEmpty(t, migrations, "Expected %s migrations", dialect) {
+			continue
+		}
+
+		for i, migration := range migrations {
+			assert.Equal(t, i+1, migration.Version, "%s migrations should be numbered without gaps", dialect)
+			assert.NotEmpty(t, migration.Name, "%s migration %d has no name", dialect, migration.Version)
+			assert.NotContains(t, migration.Up, "{{", "%s migration %d has unreplaced placeholders", dialect, migration.Version)
+			assert.NotContains(t, migration.Down, "{{", "%s migration %d has unreplaced placeholders", dialect, migration.Version)
+		}
+		assert.Contains(t, migrations[0].Up, "test_products", "Expected the table name to be replaced")
+	}
+
+	_, err := loadMigrations("unknown", tables)
+	assert.Error(t, err, "Expected an error for an unknown dialect")
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := NewSQLiteDB()
+	err := db.Initialize(ctx, "sqlite://"+filepath.Join(t.TempDir(), "shopscraper.db"), "products")
+	if err != nil {
+		t.Fatalf("failed to initialize database %v", err)
+	}
+	defer db.Close()
+
+	// Test case 1: Nothing is applied to a new database
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	if !assert.NotEmpty(t, statuses, "Expected migrations") {
+		return
+	}
+	for _, status := range statuses {
+		assert.False(t, status.Applied, "Migration %d should not be applied", status.Version)
+	}
+
+	// Test case 2: Up applies every migration once
+	applied, err := db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	assert.Equal(t, len(statuses), applied, "Expected every migration to be applied")
+
+	applied, err = db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	assert.Equal(t, 0, applied, "Expected no pending migrations")
+
+	statuses, err = db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	for _, status := range statuses {
+		assert.True(t, status.Applied, "Migration %d should be applied", status.Version)
+		assert.False(t, status.AppliedAt.IsZero(), "Migration %d should have an applied time", status.Version)
+	}
+
+	_, err = db.SaveProducts(ctx, []models.Product{{Name: "Product 1", Shop: "Shop 1", Price: 100, Link: "https://example.com/product1", LastSeen: time.Now().UTC()}})
+	assert.NoError(t, err, "Saving products should work after migrating up")
+
+	// Test case 3: Down reverts the requested number of migrations
+	reverted, err := db.MigrateDown(ctx, len(statuses)+1)
+	if err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	assert.Equal(t, len(statuses), reverted, "Expected every migration to be reverted")
+
+	_, err = db.GetAllProducts(ctx)
+	assert.Error(t, err, "Expected the product table to be dropped")
+
+	// Test case 4: Migrating up again recreates the schema
+	applied, err = db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	assert.Equal(t, len(statuses), applied, "Expected every migration to be applied again")
+	products, err := db.GetAllProducts(ctx)
+	assert.NoError(t, err, "Getting products should work after migrating up")
+	assert.Empty(t, products, "Expected no products after recreating the schema")
+}
+
+func TestSQLiteConcurrentMigrations(t *testing.T) {
+	ctx := context.Background()
+	connStr := "sqlite://" + filepath.Join(t.TempDir(), "shopscraper.db")
+
+	// Like the scraper, mailer and api starting at the same time
+	var wg sync.WaitGroup
+	applied := make([]int, 3)
+	for i := range applied {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			db := NewSQLiteDB()
+			if err := db.Initialize(ctx, connStr, "products"); err != nil {
+				t.Errorf("failed to initialize database %v", err)
+				return
+			}
+			defer db.Close()
+
+			var err error
+			applied[i], err = db.MigrateUp(ctx)
+			assert.NoError(t, err, "Concurrent migrations should not fail")
+		}(i)
+	}
+	wg.Wait()
+
+	migrations, err := loadMigrations("sqlite", strings.NewReplacer())
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	assert.Equal(t, len(migrations), applied[0]+applied[1]+applied[2], "Expected each migration to be applied exactly once")
+}
@@ -1,35 +1,37 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"log"
 	"shopscraper/pkg/models"
 	"shopscraper/pkg/utils"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresDB struct {
 	db               *sql.DB
 	productTableName string
+	historyTableName string
 }
 
 func NewPostgresDB() *PostgresDB {
 	return &PostgresDB{}
 }
 
-func (p *PostgresDB) Initialize(connStr string, tableName string) error {
+func (p *PostgresDB) Initialize(ctx context.Context, connStr string, tableName string) error {
 	var err error
 	p.db, err = sql.Open("postgres", connStr)
 	if err != nil {
 		return err
 	}
 	p.productTableName = tableName
+	p.historyTableName = relatedTableName(tableName, "price_history")
 	p.db.SetMaxOpenConns(25)
 	p.db.SetMaxIdleConns(10)
 	p.db.SetConnMaxLifetime(5 * time.Minute)
-	return p.db.Ping()
+	return p.db.PingContext(ctx)
 }
 
 func (p *PostgresDB) Close() error {
@@ -39,25 +41,30 @@ func (p *PostgresDB) Close() error {
 	return nil
 }
 
-func (p *PostgresDB) EnsureProductTableExists() error {
-	_, err := p.db.Exec(`
-        CREATE TABLE IF NOT EXISTS ` + p.productTableName + ` (
-            name TEXT,
-            shop TEXT,
-			previous_price INT,
-            price INT,
-            link TEXT,
-            first_seen TIMESTAMP,
-            last_seen TIMESTAMP,
-            notified BOOLEAN,
-            UNIQUE (name, shop, link)
-        )
-    `)
+// EnsureProductTableExists applies all pending schema migrations
+func (p *PostgresDB) EnsureProductTableExists(ctx context.Context) error {
+	_, err := p.MigrateUp(ctx)
 	return err
 }
 
-func (p *PostgresDB) GetNonNotifiedProducts() ([]models.Product, error) {
-	rows, err := p.db.Query("SELECT name, shop, previous_price, price, link, first_seen, last_seen, notified FROM " + p.productTableName + " WHERE notified = false")
+func (p *PostgresDB) migrator() *migrator {
+	return newMigrator(p.db, "postgres", p.productTableName, p.historyTableName)
+}
+
+func (p *PostgresDB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return p.migrator().Status(ctx)
+}
+
+func (p *PostgresDB) MigrateUp(ctx context.Context) (int, error) {
+	return p.migrator().Up(ctx)
+}
+
+func (p *PostgresDB) MigrateDown(ctx context.Context, steps int) (int, error) {
+	return p.migrator().Down(ctx, steps)
+}
+
+func (p *PostgresDB) GetNonNotifiedProducts(ctx context.Context) ([]models.Product, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT id, name, shop, identity_key, sku, availability, brand, image, description, details_fetched, previous_price, price, currency, link, first_seen, last_seen, notified, change_reason FROM "+p.productTableName+" WHERE notified = false")
 	if err != nil {
 		return nil, err
 	}
@@ -66,10 +73,12 @@ func (p *PostgresDB) GetNonNotifiedProducts() ([]models.Product, error) {
 	var products []models.Product
 	for rows.Next() {
 		var product models.Product
-		err := rows.Scan(&product.Name, &product.Shop, &product.PreviousPrice, &product.Price, &product.Link, &product.FirstSeen, &product.LastSeen, &product.Notified)
+		var detailsFetched sql.NullTime
+		err := rows.Scan(&product.ID, &product.Name, &product.Shop, &product.IdentityKey, &product.SKU, &product.Availability, &product.Brand, &product.Image, &product.Description, &detailsFetched, &product.PreviousPrice, &product.Price, &product.Currency, &product.Link, &product.FirstSeen, &product.LastSeen, &product.Notified, &product.ChangeReason)
 		if err != nil {
 			return nil, err
 		}
+		product.DetailsFetched = detailsFetched.Time
 		products = append(products, product)
 	}
 	if err := rows.Err(); err != nil {
@@ -78,8 +87,8 @@ func (p *PostgresDB) GetNonNotifiedProducts() ([]models.Product, error) {
 	return products, nil
 }
 
-func (p *PostgresDB) GetAllProducts() ([]models.Product, error) {
-	rows, err := p.db.Query("SELECT name, shop, previous_price, price, link, first_seen, last_seen, notified FROM " + p.productTableName)
+func (p *PostgresDB) GetAllProducts(ctx context.Context) ([]models.Product, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT id, name, shop, identity_key, sku, availability, brand, image, description, details_fetched, previous_price, price, currency, link, first_seen, last_seen, notified, change_reason FROM "+p.productTableName)
 	if err != nil {
 		return nil, err
 	}
@@ -88,10 +97,12 @@ func (p *PostgresDB) GetAllProducts() ([]models.Product, error) {
 	var products []models.Product
 	for rows.Next() {
 		var product models.Product
-		err := rows.Scan(&product.Name, &product.Shop, &product.PreviousPrice, &product.Price, &product.Link, &product.FirstSeen, &product.LastSeen, &product.Notified)
+		var detailsFetched sql.NullTime
+		err := rows.Scan(&product.ID, &product.Name, &product.Shop, &product.IdentityKey, &product.SKU, &product.Availability, &product.Brand, &product.Image, &product.Description, &detailsFetched, &product.PreviousPrice, &product.Price, &product.Currency, &product.Link, &product.FirstSeen, &product.LastSeen, &product.Notified, &product.ChangeReason)
 		if err != nil {
 			return nil, err
 		}
+		product.DetailsFetched = detailsFetched.Time
 		products = append(products, product)
 	}
 	if err := rows.Err(); err != nil {
@@ -100,64 +111,269 @@ func (p *PostgresDB) GetAllProducts() ([]models.Product, error) {
 	return products, nil
 }
 
-func (p *PostgresDB) SaveProducts(products []models.Product) ([]models.Product, error) {
-	var newProducts []models.Product
+// postgresTimestamp formats a time for a TIMESTAMP array parameter, as lib/pq
+// only converts single time values
+func postgresTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.999999")
+}
 
-	// Prepare the upsert statement outside the loop to avoid re-preparing it for every product
-	stmt, err := p.db.Prepare(`INSERT INTO ` + p.productTableName + ` (name, shop, price, link, first_seen, last_seen, notified)
-        VALUES ($1, $2, $3, $4, $5, $6, $7)
-        ON CONFLICT (name, shop, link) DO UPDATE 
-        SET price = EXCLUDED.price,
-            previous_price = CASE WHEN ` + p.productTableName + `.price != EXCLUDED.price THEN ` + p.productTableName + `.price ELSE ` + p.productTableName + `.previous_price END,
-            last_seen = EXCLUDED.last_seen,
-            notified = (CASE WHEN ` + p.productTableName + `.price != EXCLUDED.price THEN false ELSE ` + p.productTableName + `.notified END)
-        RETURNING (xmax = 0) AS is_inserted;`) // xmax = 0 will return true if it was an insert operation
+// SaveProducts upserts all products and records their prices in a single
+// transaction, with one statement for each step rather than one per product
+func (p *PostgresDB) SaveProducts(ctx context.Context, products []models.Product) (SaveResult, error) {
+	var result SaveResult
+	products = uniqueProducts(products)
+	if len(products) == 0 {
+		return result, nil
+	}
 
+	n := len(products)
+	names, shops, keys, skus := make([]string, n), make([]string, n), make([]string, n), make([]string, n)
+	availabilities, brands, images, descriptions := make([]string, n), make([]string, n), make([]string, n), make([]string, n)
+	currencies, links, lastSeen := make([]string, n), make([]string, n), make([]string, n)
+	detailsFetched := make([]sql.NullString, n)
+	prices := make([]int64, n)
+	notified := make([]bool, n)
+	inStocks := make([]sql.NullBool, n)
+	var rekeyShops, rekeyFrom, rekeyTo []string
+	for i, product := range products {
+		names[i], shops[i], keys[i], skus[i] = product.Name, product.Shop, product.IdentityKey, product.SKU
+		availabilities[i], brands[i], images[i], descriptions[i] = product.Availability, product.Brand, product.Image, product.Description
+		currencies[i], links[i], lastSeen[i] = product.CurrencyCode(), product.Link, postgresTimestamp(product.LastSeen)
+		if !product.DetailsFetched.IsZero() {
+			detailsFetched[i] = sql.NullString{String: postgresTimestamp(product.DetailsFetched), Valid: true}
+		}
+		prices[i] = int64(product.Price)
+		notified[i] = product.Notified
+		inStocks[i] = inStock(product)
+
+		if defaultKey := models.ProductKey(models.IdentityNameLink, product); product.IdentityKey != defaultKey {
+			rekeyShops = append(rekeyShops, product.Shop)
+			rekeyFrom = append(rekeyFrom, defaultKey)
+			rekeyTo = append(rekeyTo, product.IdentityKey)
+		}
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return result, err
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	for _, product := range products {
-		var isInserted bool
-		err := stmt.QueryRow(product.Name, product.Shop, product.Price, product.Link, product.LastSeen, product.LastSeen, product.Notified).Scan(&isInserted)
+	// Products saved before their scraper switched identity strategy still have
+	// the default key, adopt those rows instead of inserting duplicates
+	if len(rekeyTo) > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE `+p.productTableName+` AS product SET identity_key = rekey.identity_key
+            FROM unnest($1::TEXT[], $2::TEXT[], $3::TEXT[]) AS rekey(shop, default_key, identity_key)
+            WHERE product.shop = rekey.shop AND product.identity_key = rekey.default_key
+            AND NOT EXISTS (
+                SELECT 1 FROM `+p.productTableName+` AS existing
+                WHERE existing.shop = rekey.shop AND existing.identity_key = rekey.identity_key
+            )`, pq.Array(rekeyShops), pq.Array(rekeyFrom), pq.Array(rekeyTo))
 		if err != nil {
-			return nil, err
+			return result, err
 		}
+	}
+
+	// A change of price or currency, or coming back in stock, resets notified
+	// so the change is picked up by the mailer. A product whose availability
+	// is unknown keeps its stock status. The change reasons follow
+	// productChange, with the values of models.ChangeReason.
+	priceChanged := p.productTableName + ".price != EXCLUDED.price OR " + p.productTableName + ".currency != EXCLUDED.currency"
+	backInStock := p.productTableName + ".in_stock IS FALSE AND EXCLUDED.in_stock IS TRUE"
+	restockSoldOut := p.productTableName + ".change_reason = 'restock' AND EXCLUDED.in_stock IS FALSE"
 
-		// If it was an insert, add to newProducts
-		if isInserted {
-			newProducts = append(newProducts, product)
+	// The existing rows are read from the snapshot taken before the upsert, so
+	// comparing them with the upserted rows tells which prices changed
+	rows, err := tx.QueryContext(ctx, `WITH input AS (
+            SELECT * FROM unnest($1::TEXT[], $2::TEXT[], $3::TEXT[], $4::TEXT[], $5::TEXT[], $6::TEXT[], $7::TEXT[], $8::BIGINT[], $9::TEXT[], $10::TEXT[], $11::TIMESTAMP[], $12::BOOLEAN[], $13::BOOLEAN[], $14::TEXT[], $15::TIMESTAMP[])
+                AS input(name, shop, identity_key, sku, availability, brand, image, price, currency, link, last_seen, notified, in_stock, description, details_fetched)
+        ), existing AS (
+            SELECT product.id, product.price, product.currency, product.in_stock FROM `+p.productTableName+` AS product
+            JOIN input ON product.shop = input.shop AND product.identity_key = input.identity_key
+        ), upserted AS (
+            INSERT INTO `+p.productTableName+` (name, shop, identity_key, sku, availability, brand, image, description, details_fetched, price, currency, link, first_seen, last_seen, notified, in_stock, change_reason)
+            SELECT name, shop, identity_key, sku, availability, brand, image, description, details_fetched, price, currency, link, last_seen, last_seen, notified, in_stock, 'new' FROM input
+            ON CONFLICT (shop, identity_key) DO UPDATE
+            SET name = EXCLUDED.name,
+                sku = EXCLUDED.sku,
+                availability = EXCLUDED.availability,
+                brand = EXCLUDED.brand,
+                image = EXCLUDED.image,
+                description = EXCLUDED.description,
+                details_fetched = EXCLUDED.details_fetched,
+                link = EXCLUDED.link,
+                price = EXCLUDED.price,
+                currency = EXCLUDED.currency,
+                previous_price = CASE WHEN `+priceChanged+` THEN `+p.productTableName+`.price ELSE `+p.productTableName+`.previous_price END,
+                last_seen = EXCLUDED.last_seen,
+                notified = (CASE WHEN `+priceChanged+` OR `+backInStock+` THEN false
+                    WHEN `+restockSoldOut+` THEN true
+                    ELSE `+p.productTableName+`.notified END),
+                change_reason = (CASE
+                    WHEN `+backInStock+` THEN
+                        CASE WHEN `+p.productTableName+`.change_reason = 'new' THEN 'new' ELSE 'restock' END
+                    WHEN `+priceChanged+` THEN
+                        CASE WHEN `+p.productTableName+`.change_reason IN ('new', 'restock') THEN `+p.productTableName+`.change_reason
+                            WHEN EXCLUDED.price < `+p.productTableName+`.price THEN 'price_drop'
+                            ELSE 'price_rise' END
+                    WHEN `+restockSoldOut+` THEN ''
+                    ELSE `+p.productTableName+`.change_reason END),
+                in_stock = COALESCE(EXCLUDED.in_stock, `+p.productTableName+`.in_stock)
+            RETURNING id, shop, identity_key, price, currency, in_stock, change_reason, previous_price, first_seen, (xmax = 0) AS is_inserted
+        )
+        SELECT upserted.id, upserted.shop, upserted.identity_key, upserted.change_reason, upserted.previous_price, upserted.first_seen, upserted.is_inserted,
+            (existing.id IS NOT NULL AND (existing.price != upserted.price OR existing.currency != upserted.currency)) AS price_changed,
+            (existing.in_stock IS FALSE AND upserted.in_stock IS TRUE) AS restocked
+        FROM upserted LEFT JOIN existing ON existing.id = upserted.id`,
+		pq.Array(names), pq.Array(shops), pq.Array(keys), pq.Array(skus),
+		pq.Array(availabilities), pq.Array(brands), pq.Array(images), pq.Array(prices),
+		pq.Array(currencies), pq.Array(links), pq.Array(lastSeen), pq.Array(notified), pq.Array(inStocks), pq.Array(descriptions), pq.Array(detailsFetched))
+	if err != nil {
+		return result, err
+	}
+
+	index := make(map[[2]string]int, n)
+	for i, product := range products {
+		index[[2]string{product.Shop, product.IdentityKey}] = i
+	}
+	isInserted := make([]bool, n)
+	isChanged := make([]bool, n)
+	isRestocked := make([]bool, n)
+	ids := make([]int64, n)
+	for rows.Next() {
+		var shop, key string
+		var id int64
+		var reason models.ChangeReason
+		var previousPrice sql.NullInt64
+		var firstSeen time.Time
+		var inserted, changed, restocked bool
+		err := rows.Scan(&id, &shop, &key, &reason, &previousPrice, &firstSeen, &inserted, &changed, &restocked)
+		if err != nil {
+			rows.Close()
+			return result, err
 		}
+
+		i := index[[2]string{shop, key}]
+		products[i].ID = id
+		products[i].PreviousPrice = previousPrice
+		products[i].FirstSeen = firstSeen
+		ids[i] = id
+		isInserted[i] = inserted
+		isChanged[i] = changed
+		isRestocked[i] = restocked
+		products[i].ChangeReason = reason
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	// Record the prices in the history unless they match the latest recorded price
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+p.historyTableName+` (product_id, price, currency, observed_at)
+        SELECT input.product_id, input.price, input.currency, input.observed_at
+        FROM unnest($1::BIGINT[], $2::BIGINT[], $3::TEXT[], $4::TIMESTAMP[]) AS input(product_id, price, currency, observed_at)
+        WHERE NOT EXISTS (
+            SELECT 1 FROM (
+                SELECT price, currency FROM `+p.historyTableName+`
+                WHERE product_id = input.product_id ORDER BY observed_at DESC LIMIT 1
+            ) latest
+            WHERE latest.price = input.price AND latest.currency = input.currency
+        )`, pq.Array(ids), pq.Array(prices), pq.Array(currencies), pq.Array(lastSeen))
+	if err != nil {
+		return result, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
 	}
 
-	return newProducts, nil
+	for i, product := range products {
+		if isInserted[i] {
+			result.New = append(result.New, product)
+		} else if isChanged[i] {
+			result.PriceChanged = append(result.PriceChanged, product)
+		}
+		if isRestocked[i] {
+			result.Restocked = append(result.Restocked, product)
+		}
+	}
+	return result, nil
+}
+
+func (p *PostgresDB) ProductExists(ctx context.Context, productID int64) (bool, error) {
+	var exists bool
+	err := p.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+p.productTableName+" WHERE id = $1)", productID).Scan(&exists)
+	return exists, err
 }
 
-func (p *PostgresDB) SetNotifiedProducts(products []models.Product) error {
-	// Update notified status for products in the database
+func (p *PostgresDB) GetPriceHistory(ctx context.Context, productID int64, since time.Time) ([]models.PricePoint, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT price, currency, observed_at FROM "+p.historyTableName+" WHERE product_id = $1 AND observed_at >= $2 ORDER BY observed_at", productID, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.PricePoint
+	for rows.Next() {
+		var point models.PricePoint
+		err := rows.Scan(&point.Price, &point.Currency, &point.ObservedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// SetNotifiedProducts marks the products as notified in a single transaction,
+// by id when the product was read from the database and by identity key otherwise
+func (p *PostgresDB) SetNotifiedProducts(ctx context.Context, products []models.Product) error {
+	var ids []int64
+	var shops, keys []string
 	for _, product := range products {
-		_, err := p.db.Exec("UPDATE "+p.productTableName+" SET notified = true WHERE name = $1 AND shop = $2 AND link = $3", product.Name, product.Shop, product.Link)
+		if product.ID != 0 {
+			ids = append(ids, product.ID)
+		} else {
+			shops = append(shops, product.Shop)
+			keys = append(keys, product.Key())
+		}
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(ids) > 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE "+p.productTableName+" SET notified = true, change_reason = '' WHERE id = ANY($1)", pq.Array(ids))
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	if len(keys) > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE `+p.productTableName+` AS product SET notified = true, change_reason = ''
+            FROM unnest($1::TEXT[], $2::TEXT[]) AS notified(shop, identity_key)
+            WHERE product.shop = notified.shop AND product.identity_key = notified.identity_key`, pq.Array(shops), pq.Array(keys))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (p *PostgresDB) RemoveOldProducts(timeBack time.Duration) error {
+func (p *PostgresDB) RemoveOldProducts(ctx context.Context, timeBack time.Duration) error {
 	threshold := utils.GetPastTimeThreshold(timeBack)
 
 	// Delete items from the database where last_seen is older than the threshold
-	_, err := p.db.Exec("DELETE FROM "+p.productTableName+" WHERE last_seen < $1", threshold.UTC())
-	if err != nil {
-		log.Fatal(err)
-	}
-
+	_, err := p.db.ExecContext(ctx, "DELETE FROM "+p.productTableName+" WHERE last_seen < $1", threshold.UTC())
 	return err
 }
 
-func (p *PostgresDB) DropProductTable() error {
-	_, err := p.db.Exec("DROP TABLE IF EXISTS " + p.productTableName)
+func (p *PostgresDB) DropProductTable(ctx context.Context) error {
+	_, err := p.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+p.historyTableName+", "+p.productTableName+", "+p.migrator().migrationsTable)
 	return err
 }
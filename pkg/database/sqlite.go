@@ -0,0 +1,338 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"shopscraper/pkg/models"
+	"shopscraper/pkg/utils"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDB stores products in a SQLite database file, for running without a
+// PostgreSQL server
+type SQLiteDB struct {
+	db               *sql.DB
+	productTableName string
+	historyTableName string
+}
+
+func NewSQLiteDB() *SQLiteDB {
+	return &SQLiteDB{}
+}
+
+// sqliteDSN converts a connection string such as "sqlite:///var/lib/shopscraper.db"
+// or "sqlite://:memory:" to a DSN for the sqlite driver, enabling foreign keys
+// and waiting for locks held by other processes instead of failing. Transactions
+// take the write lock when they begin, so concurrent migrations are serialized.
+func sqliteDSN(connStr string) string {
+	dsn := connStr
+	if strings.HasPrefix(dsn, "sqlite://") {
+		dsn = strings.TrimPrefix(dsn, "sqlite://")
+	} else {
+		dsn = strings.TrimPrefix(dsn, "sqlite:")
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite&_txlock=immediate"
+}
+
+func (s *SQLiteDB) Initialize(ctx context.Context, connStr string, tableName string) error {
+	var err error
+	s.db, err = sql.Open("sqlite", sqliteDSN(connStr))
+	if err != nil {
+		return err
+	}
+	s.productTableName = tableName
+	s.historyTableName = relatedTableName(tableName, "price_history")
+	// SQLite allows a single writer, and every connection to ":memory:" is a
+	// separate database, so share one connection
+	s.db.SetMaxOpenConns(1)
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLiteDB) Close() error {
+	if s.db != nil {
+		return s.db.Close()
+	}
+	return nil
+}
+
+// EnsureProductTableExists applies all pending schema migrations
+func (s *SQLiteDB) EnsureProductTableExists(ctx context.Context) error {
+	_, err := s.MigrateUp(ctx)
+	return err
+}
+
+func (s *SQLiteDB) migrator() *migrator {
+	return newMigrator(s.db, "sqlite", s.productTableName, s.historyTableName)
+}
+
+func (s *SQLiteDB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return s.migrator().Status(ctx)
+}
+
+func (s *SQLiteDB) MigrateUp(ctx context.Context) (int, error) {
+	return s.migrator().Up(ctx)
+}
+
+func (s *SQLiteDB) MigrateDown(ctx context.Context, steps int) (int, error) {
+	return s.migrator().Down(ctx, steps)
+}
+
+func (s *SQLiteDB) GetNonNotifiedProducts(ctx context.Context) ([]models.Product, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, shop, identity_key, sku, availability, brand, image, description, details_fetched, previous_price, price, currency, link, first_seen, last_seen, notified, change_reason FROM "+s.productTableName+" WHERE notified = false")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		var detailsFetched sql.NullTime
+		err := rows.Scan(&product.ID, &product.Name, &product.Shop, &product.IdentityKey, &product.SKU, &product.Availability, &product.Brand, &product.Image, &product.Description, &detailsFetched, &product.PreviousPrice, &product.Price, &product.Currency, &product.Link, &product.FirstSeen, &product.LastSeen, &product.Notified, &product.ChangeReason)
+		if err != nil {
+			return nil, err
+		}
+		product.DetailsFetched = detailsFetched.Time
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (s *SQLiteDB) GetAllProducts(ctx context.Context) ([]models.Product, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, shop, identity_key, sku, availability, brand, image, description, details_fetched, previous_price, price, currency, link, first_seen, last_seen, notified, change_reason FROM "+s.productTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		var detailsFetched sql.NullTime
+		err := rows.Scan(&product.ID, &product.Name, &product.Shop, &product.IdentityKey, &product.SKU, &product.Availability, &product.Brand, &product.Image, &product.Description, &detailsFetched, &product.PreviousPrice, &product.Price, &product.Currency, &product.Link, &product.FirstSeen, &product.LastSeen, &product.Notified, &product.ChangeReason)
+		if err != nil {
+			return nil, err
+		}
+		product.DetailsFetched = detailsFetched.Time
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// SaveProducts upserts all products and records their prices in a single transaction
+func (s *SQLiteDB) SaveProducts(ctx context.Context, products []models.Product) (SaveResult, error) {
+	var result SaveResult
+
+	// Saving all products in one transaction avoids a disk sync per product
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// Products saved before their scraper switched identity strategy still have
+	// the default key, adopt those rows instead of inserting duplicates
+	rekeyStmt, err := tx.PrepareContext(ctx, `UPDATE `+s.productTableName+` SET identity_key = $3
+        WHERE shop = $1 AND identity_key = $2
+        AND NOT EXISTS (SELECT 1 FROM `+s.productTableName+` WHERE shop = $1 AND identity_key = $3)`)
+	if err != nil {
+		return result, err
+	}
+	defer rekeyStmt.Close()
+
+	selectStmt, err := tx.PrepareContext(ctx, "SELECT id, price, currency, previous_price, first_seen, in_stock, notified, change_reason FROM "+s.productTableName+" WHERE shop = $1 AND identity_key = $2")
+	if err != nil {
+		return result, err
+	}
+	defer selectStmt.Close()
+
+	insertStmt, err := tx.PrepareContext(ctx, `INSERT INTO `+s.productTableName+` (name, shop, identity_key, sku, availability, brand, image, price, currency, link, first_seen, last_seen, notified, in_stock, change_reason, description, details_fetched)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`)
+	if err != nil {
+		return result, err
+	}
+	defer insertStmt.Close()
+
+	updateStmt, err := tx.PrepareContext(ctx, `UPDATE `+s.productTableName+`
+        SET name = $2, sku = $3, availability = $4, brand = $5, image = $6, link = $7, price = $8, currency = $9, last_seen = $10,
+            previous_price = CASE WHEN $11 THEN price ELSE previous_price END,
+            notified = $12, change_reason = $13, in_stock = $14, description = $15, details_fetched = $16
+        WHERE id = $1`)
+	if err != nil {
+		return result, err
+	}
+	defer updateStmt.Close()
+
+	// Record the price in the history unless it matches the latest recorded price
+	historyStmt, err := tx.PrepareContext(ctx, `INSERT INTO `+s.historyTableName+` (product_id, price, currency, observed_at)
+        SELECT $1, $2, $3, $4
+        WHERE NOT EXISTS (
+            SELECT 1 FROM (
+                SELECT price, currency FROM `+s.historyTableName+`
+                WHERE product_id = $1 ORDER BY observed_at DESC LIMIT 1
+            ) latest
+            WHERE latest.price = $2 AND latest.currency = $3
+        )`)
+	if err != nil {
+		return result, err
+	}
+	defer historyStmt.Close()
+
+	for _, product := range uniqueProducts(products) {
+		key := product.IdentityKey
+		if defaultKey := models.ProductKey(models.IdentityNameLink, product); key != defaultKey {
+			_, err := rekeyStmt.ExecContext(ctx, product.Shop, defaultKey, key)
+			if err != nil {
+				return result, err
+			}
+		}
+		lastSeen := product.LastSeen.UTC()
+
+		var price int
+		var currency string
+		var previousPrice sql.NullInt64
+		var wasInStock sql.NullBool
+		var notified bool
+		var pending models.ChangeReason
+		err := selectStmt.QueryRowContext(ctx, product.Shop, key).Scan(&product.ID, &price, &currency, &previousPrice, &product.FirstSeen, &wasInStock, &notified, &pending)
+		switch {
+		case err == sql.ErrNoRows:
+			inserted, err := insertStmt.ExecContext(ctx, product.Name, product.Shop, key, product.SKU, product.Availability, product.Brand, product.Image, product.Price, product.CurrencyCode(), product.Link, lastSeen, lastSeen, product.Notified, inStock(product), models.ChangeNew, product.Description, nullTime(product.DetailsFetched))
+			if err != nil {
+				return result, err
+			}
+			product.ID, err = inserted.LastInsertId()
+			if err != nil {
+				return result, err
+			}
+			product.FirstSeen = lastSeen
+			product.ChangeReason = models.ChangeNew
+			result.New = append(result.New, product)
+		case err != nil:
+			return result, err
+		default:
+			// A change of price or currency, or coming back in stock, resets
+			// notified so the change is picked up by the mailer. A product whose
+			// availability is unknown keeps its stock status.
+			isInStock := inStock(product)
+			if !isInStock.Valid {
+				isInStock = wasInStock
+			}
+			change := productChange{
+				priceChanged: price != product.Price || currency != product.CurrencyCode(),
+				priceDropped: product.Price < price,
+				restocked:    wasInStock.Valid && !wasInStock.Bool && isInStock.Valid && isInStock.Bool,
+				soldOut:      isInStock.Valid && !isInStock.Bool,
+			}
+			product.ChangeReason = change.reason(pending)
+			_, err = updateStmt.ExecContext(ctx, product.ID, product.Name, product.SKU, product.Availability, product.Brand, product.Image, product.Link, product.Price, product.CurrencyCode(), lastSeen,
+				change.priceChanged, change.notified(pending, notified), product.ChangeReason, isInStock, product.Description, nullTime(product.DetailsFetched))
+			if err != nil {
+				return result, err
+			}
+			product.PreviousPrice = previousPrice
+			if change.priceChanged {
+				product.PreviousPrice = sql.NullInt64{Int64: int64(price), Valid: true}
+				result.PriceChanged = append(result.PriceChanged, product)
+			}
+			if change.restocked {
+				result.Restocked = append(result.Restocked, product)
+			}
+		}
+
+		_, err = historyStmt.ExecContext(ctx, product.ID, product.Price, product.CurrencyCode(), lastSeen)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return SaveResult{}, err
+	}
+	return result, nil
+}
+
+func (s *SQLiteDB) ProductExists(ctx context.Context, productID int64) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+s.productTableName+" WHERE id = $1)", productID).Scan(&exists)
+	return exists, err
+}
+
+func (s *SQLiteDB) GetPriceHistory(ctx context.Context, productID int64, since time.Time) ([]models.PricePoint, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT price, currency, observed_at FROM "+s.historyTableName+" WHERE product_id = $1 AND observed_at >= $2 ORDER BY observed_at", productID, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.PricePoint
+	for rows.Next() {
+		var point models.PricePoint
+		err := rows.Scan(&point.Price, &point.Currency, &point.ObservedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// SetNotifiedProducts marks the products as notified in a single transaction,
+// by id when the product was read from the database and by identity key otherwise
+func (s *SQLiteDB) SetNotifiedProducts(ctx context.Context, products []models.Product) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, product := range products {
+		if product.ID != 0 {
+			_, err = tx.ExecContext(ctx, "UPDATE "+s.productTableName+" SET notified = true, change_reason = '' WHERE id = $1", product.ID)
+		} else {
+			_, err = tx.ExecContext(ctx, "UPDATE "+s.productTableName+" SET notified = true, change_reason = '' WHERE shop = $1 AND identity_key = $2", product.Shop, product.Key())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDB) RemoveOldProducts(ctx context.Context, timeBack time.Duration) error {
+	threshold := utils.GetPastTimeThreshold(timeBack)
+
+	// Delete items from the database where last_seen is older than the threshold
+	_, err := s.db.ExecContext(ctx, "DELETE FROM "+s.productTableName+" WHERE last_seen < $1", threshold.UTC())
+	return err
+}
+
+func (s *SQLiteDB) DropProductTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+s.historyTableName)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+s.productTableName)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+s.migrator().migrationsTable)
+	return err
+}
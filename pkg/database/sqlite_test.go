@@ -0,0 +1,25 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteDB(t *testing.T) {
+	ctx := context.Background()
+	runConformanceTests(t, func(t *testing.T) Database {
+		db := NewSQLiteDB()
+		err := db.Initialize(ctx, "sqlite://"+filepath.Join(t.TempDir(), "shopscraper.db"), "test_products")
+		if err != nil {
+			t.Fatalf("failed to initialize database %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		err = db.EnsureProductTableExists(ctx)
+		if err != nil {
+			t.Fatalf("failed to ensure table exists %v", err)
+		}
+		return db
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"shopscraper/pkg/config"
 	"shopscraper/pkg/models"
+	"strings"
 )
 
 type SmtpSender interface {
@@ -28,6 +29,11 @@ func validateConfig(cfg config.EmailConfig) error {
 	if cfg.Recipient == "" || cfg.Sender == "" || cfg.Subject == "" || cfg.Server == "" || cfg.Port == "" {
 		return ErrConfigInvalid
 	}
+	for _, reason := range cfg.Reasons {
+		if !models.ValidChangeReason(models.ChangeReason(reason)) {
+			return fmt.Errorf("unknown reason '%s'", reason)
+		}
+	}
 	return nil
 }
 
@@ -73,18 +79,82 @@ func SendEmail(smtpSender SmtpSender, products []models.Product, programConfig c
 	return nil
 }
 
+// sectionTitles are the headings of the sections of the email
+var sectionTitles = map[models.ChangeReason]string{
+	models.ChangeRestock:   "Back in stock",
+	models.ChangePriceDrop: "Price drops",
+	models.ChangeNew:       "New products",
+	models.ChangePriceRise: "Price increases",
+}
+
+// constructEmailBody lists the products in a section for each change reason
 func constructEmailBody(products []models.Product) string {
+	sections := make(map[models.ChangeReason][]models.Product)
+	for _, p := range products {
+		reason := p.Reason()
+		sections[reason] = append(sections[reason], p)
+	}
+
+	body := ""
+	for _, reason := range models.ChangeReasons {
+		if len(sections[reason]) > 0 {
+			body += sectionTitles[reason] + ":\n\n" + productLines(sections[reason])
+		}
+	}
+	return body
+}
+
+func productLines(products []models.Product) string {
 	body := ""
 	for _, p := range products {
-		line := fmt.Sprintf("%s - %d", p.Name, p.Price)
+		line := fmt.Sprintf("%s - %s", p.Name, models.FormatPrice(int64(p.Price), p.CurrencyCode()))
 		// If previous price is valid, include it
 		if p.PreviousPrice.Valid {
-			line += fmt.Sprintf(" (%d)", p.PreviousPrice.Int64)
+			line += fmt.Sprintf(" (%s)", models.FormatPrice(p.PreviousPrice.Int64, p.CurrencyCode()))
 		}
 		line += fmt.Sprintf(" - %s\n", p.Shop)
-		line += fmt.Sprintf("%s\n\n", p.Link)
+		if details := productDetails(p); details != "" {
+			line += details + "\n"
+		}
+		if p.Description != "" {
+			line += shorten(p.Description, maxDescriptionLength) + "\n"
+		}
+		line += fmt.Sprintf("%s\n", p.Link)
+		if p.Image != "" {
+			line += fmt.Sprintf("Image: %s\n", p.Image)
+		}
 
-		body += line
+		body += line + "\n"
 	}
 	return body
 }
+
+// maxDescriptionLength is the number of characters of a description included
+// in the email
+const maxDescriptionLength = 200
+
+// shorten cuts text to at most max characters, ending it with an ellipsis
+// when it is cut
+func shorten(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+// productDetails returns the optional attributes the product was scraped
+// with on a single line, or an empty string when it has none
+func productDetails(p models.Product) string {
+	var details []string
+	if p.Brand != "" {
+		details = append(details, "Brand: "+p.Brand)
+	}
+	if p.SKU != "" {
+		details = append(details, "SKU: "+p.SKU)
+	}
+	if p.Availability != "" {
+		details = append(details, "Availability: "+p.Availability)
+	}
+	return strings.Join(details, " | ")
+}
@@ -132,3 +132,56 @@ func TestSendEmail_NoProducts(t *testing.T) {
 		t.Errorf("Expected SendMail to not be called, but it was called %v times", len(mockSender.Calls))
 	}
 }
+
+func TestConstructEmailBody(t *testing.T) {
+	products := []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 1999, Currency: "SEK", Link: "https://example.com/product1"},
+		{Name: "Product 2", Shop: "Shop 2", PreviousPrice: sql.NullInt64{Int64: 2050, Valid: true}, Price: 1949, Link: "https://example.com/product2"},
+		{Name: "Product 3", Shop: "Shop 3", Price: 500, Link: "https://example.com/product3", Brand: "Brand 3", SKU: "SKU-3", Availability: "InStock", Image: "https://example.com/product3.jpg", Description: strings.Repeat("Long description ", 20)},
+	}
+
+	body := constructEmailBody(products)
+
+	expected := "Price drops:\n\n" +
+		"Product 2 - 19.49 EUR (20.50 EUR) - Shop 2\nhttps://example.com/product2\n\n" +
+		"New products:\n\n" +
+		"Product 1 - 19.99 SEK - Shop 1\nhttps://example.com/product1\n\n" +
+		"Product 3 - 5.00 EUR - Shop 3\nBrand: Brand 3 | SKU: SKU-3 | Availability: InStock\n" + strings.Repeat("Long description ", 11) + "Long descrip…\nhttps://example.com/product3\nImage: https://example.com/product3.jpg\n\n"
+	if body != expected {
+		t.Errorf("Expected email body %q, got %q", expected, body)
+	}
+}
+
+func TestConstructEmailBodyReasons(t *testing.T) {
+	products := []models.Product{
+		{Name: "Product 1", Shop: "Shop 1", Price: 1999, Link: "https://example.com/product1", ChangeReason: models.ChangeNew},
+		{Name: "Product 2", Shop: "Shop 2", Price: 500, Availability: "InStock", Link: "https://example.com/product2", ChangeReason: models.ChangeRestock},
+		{Name: "Product 3", Shop: "Shop 3", PreviousPrice: sql.NullInt64{Int64: 900, Valid: true}, Price: 1000, Link: "https://example.com/product3", ChangeReason: models.ChangePriceRise},
+	}
+
+	// Test case 1: A section for each reason, back in stock first
+	body := constructEmailBody(products)
+
+	expected := "Back in stock:\n\n" +
+		"Product 2 - 5.00 EUR - Shop 2\nAvailability: InStock\nhttps://example.com/product2\n\n" +
+		"New products:\n\n" +
+		"Product 1 - 19.99 EUR - Shop 1\nhttps://example.com/product1\n\n" +
+		"Price increases:\n\n" +
+		"Product 3 - 10.00 EUR (9.00 EUR) - Shop 3\nhttps://example.com/product3\n\n"
+	if body != expected {
+		t.Errorf("Expected email body %q, got %q", expected, body)
+	}
+
+	// Test case 2: Unknown reasons are rejected
+	err := validateConfig(config.EmailConfig{
+		Recipient: "recipient@example.com",
+		Sender:    "sender@example.com",
+		Subject:   "New Products",
+		Server:    "smtp.example.com",
+		Port:      "587",
+		Reasons:   []string{"price_dropped"},
+	})
+	if err == nil {
+		t.Errorf("Expected an error for an unknown reason")
+	}
+}
@@ -2,16 +2,203 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
 	"time"
 )
 
+// DefaultCurrency is used for products scraped without a known currency
+const DefaultCurrency = "EUR"
+
+// currencyExponents lists the ISO 4217 currencies whose minor unit is not a
+// hundredth of the major unit
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Identity strategies decide which fields identify a product within its shop
+const (
+	// IdentityNameLink identifies products by name and link, the default
+	IdentityNameLink = "name_link"
+	// IdentityLink identifies products by link only, so renamed products are not new
+	IdentityLink = "link"
+	// IdentitySKU identifies products by SKU or GTIN, falling back to the link when missing
+	IdentitySKU = "sku"
+	// IdentityName identifies products by name only, so moved products are not new
+	IdentityName = "name"
+)
+
+// Product is a single product listing. Prices are stored in minor units
+// (e.g. cents) of the ISO 4217 currency in Currency.
 type Product struct {
+	ID            int64         `json:"id"`
 	Name          string        `json:"name"`
 	Shop          string        `json:"shop"`
 	PreviousPrice sql.NullInt64 `json:"previousPrice"`
 	Price         int           `json:"price"`
+	Currency      string        `json:"currency"`
+	Availability  string        `json:"availability"`
 	Link          string        `json:"link"`
-	FirstSeen     time.Time     `json:"firstSeen"`
-	LastSeen      time.Time     `json:"lastSeen"`
-	Notified      bool          `json:"notified"`
+	SKU           string        `json:"sku"`
+	Brand         string        `json:"brand"`
+	Image         string        `json:"image"`
+	Description   string        `json:"description"`
+	IdentityKey   string        `json:"-"`
+	// DetailsFetched is when the fields of the detail page were fetched, zero
+	// when the product has none
+	DetailsFetched time.Time `json:"-"`
+	FirstSeen      time.Time `json:"firstSeen"`
+	LastSeen       time.Time `json:"lastSeen"`
+	Notified       bool      `json:"notified"`
+	// ChangeReason is why the product needs notifying, empty once notified
+	ChangeReason ChangeReason `json:"changeReason"`
+}
+
+// ChangeReason is why a product needs notifying
+type ChangeReason string
+
+const (
+	// ChangeNew is a product seen for the first time
+	ChangeNew ChangeReason = "new"
+	// ChangePriceDrop is a product whose price went down
+	ChangePriceDrop ChangeReason = "price_drop"
+	// ChangePriceRise is a product whose price went up
+	ChangePriceRise ChangeReason = "price_rise"
+	// ChangeRestock is a product that came back in stock
+	ChangeRestock ChangeReason = "restock"
+)
+
+// ChangeReasons lists the change reasons in the order they are notified
+var ChangeReasons = []ChangeReason{ChangeRestock, ChangePriceDrop, ChangeNew, ChangePriceRise}
+
+// ValidChangeReason reports whether reason is a known change reason
+func ValidChangeReason(reason ChangeReason) bool {
+	return slices.Contains(ChangeReasons, reason)
+}
+
+// Reason returns why the product is notified. Products saved before reasons
+// were recorded are told apart by their previous price.
+func (p Product) Reason() ChangeReason {
+	switch {
+	case p.ChangeReason != "":
+		return p.ChangeReason
+	case !p.PreviousPrice.Valid:
+		return ChangeNew
+	case int64(p.Price) < p.PreviousPrice.Int64:
+		return ChangePriceDrop
+	}
+	return ChangePriceRise
+}
+
+// PricePoint is a price observed for a product at a point in time
+type PricePoint struct {
+	Price      int       `json:"price"`
+	Currency   string    `json:"currency"`
+	ObservedAt time.Time `json:"observedAt"`
+}
+
+// ProductKey returns the key identifying the product within its shop using
+// the given identity strategy, an empty strategy means IdentityNameLink
+func ProductKey(strategy string, p Product) string {
+	switch strategy {
+	case IdentityLink:
+		return "link:" + p.Link
+	case IdentitySKU:
+		if p.SKU != "" {
+			return "sku:" + p.SKU
+		}
+		return "link:" + p.Link
+	case IdentityName:
+		return "name:" + p.Name
+	}
+	return "name_link:" + p.Name + "\n" + p.Link
+}
+
+// Key returns the identity key set by the scraper, or the key of the default identity strategy
+func (p Product) Key() string {
+	if p.IdentityKey != "" {
+		return p.IdentityKey
+	}
+	return ProductKey(IdentityNameLink, p)
+}
+
+// ValidIdentity reports whether strategy is a known identity strategy
+func ValidIdentity(strategy string) bool {
+	switch strategy {
+	case "", IdentityNameLink, IdentityLink, IdentitySKU, IdentityName:
+		return true
+	}
+	return false
+}
+
+// Availability values, schema.org ones in their short form, that tell whether
+// a product can be bought. Values are compared without case, spaces, dashes
+// and underscores, so "In stock" and "in_stock" are InStock. Products that can
+// be ordered for later delivery, such as PreOrder, count as in stock so that
+// a sold out product opening for orders again is a restock.
+var (
+	inStockAvailabilities = []string{
+		"instock", "limitedavailability", "instoreonly", "onlineonly", "available",
+		"preorder", "presale", "backorder", "madetoorder",
+	}
+	outOfStockAvailabilities = []string{
+		"outofstock", "soldout", "discontinued", "reserved", "unavailable", "notavailable",
+	}
+)
+
+// InStock reports whether the product can be bought according to its
+// availability, and whether that is known at all
+func (p Product) InStock() (inStock bool, known bool) {
+	availability := strings.ToLower(p.Availability)
+	availability = strings.NewReplacer(" ", "", "-", "", "_", "").Replace(availability)
+	switch {
+	case slices.Contains(inStockAvailabilities, availability):
+		return true, true
+	case slices.Contains(outOfStockAvailabilities, availability):
+		return false, true
+	}
+	return false, false
+}
+
+// CurrencyCode returns the product currency, or DefaultCurrency if unset
+func (p Product) CurrencyCode() string {
+	if p.Currency == "" {
+		return DefaultCurrency
+	}
+	return p.Currency
+}
+
+// CurrencyExponent returns the number of decimals of the minor unit of a
+// currency, e.g. 2 for EUR and 0 for JPY
+func CurrencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[currency]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// MinorUnits converts an amount in major units to minor units of a currency,
+// e.g. MinorUnits(14.99, "EUR") returns 1499
+func MinorUnits(amount float64, currency string) int {
+	return int(math.Round(amount * math.Pow10(CurrencyExponent(currency))))
+}
+
+// FormatPrice formats an amount in minor units, e.g. FormatPrice(149950, "EUR")
+// returns "1499.50 EUR" and FormatPrice(1500, "JPY") returns "1500 JPY"
+func FormatPrice(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	exponent := CurrencyExponent(currency)
+	if exponent == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, currency)
+	}
+	scale := int64(math.Pow10(exponent))
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/scale, exponent, amount%scale, currency)
 }
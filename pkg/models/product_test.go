@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatPrice(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		expected string
+	}{
+		{149950, "EUR", "1499.50 EUR"},
+		{5, "USD", "0.05 USD"},
+		{-1999, "SEK", "-19.99 SEK"},
+		{1500, "JPY", "1500 JPY"},
+		{15000, "KRW", "15000 KRW"},
+		{1250, "KWD", "1.250 KWD"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, FormatPrice(test.amount, test.currency))
+	}
+}
+
+func TestMinorUnits(t *testing.T) {
+	assert.Equal(t, 1499, MinorUnits(14.99, "EUR"))
+	assert.Equal(t, 1500, MinorUnits(1500, "JPY"))
+	assert.Equal(t, 1250, MinorUnits(1.25, "KWD"))
+}
+
+func TestInStock(t *testing.T) {
+	tests := []struct {
+		availability string
+		inStock      bool
+		known        bool
+	}{
+		{"InStock", true, true},
+		{"in_stock", true, true},
+		{"PreOrder", true, true},
+		{"Back-order", true, true},
+		{"OutOfStock", false, true},
+		{"Sold out", false, true},
+		{"", false, false},
+		{"Ask in store", false, false},
+	}
+
+	for _, test := range tests {
+		inStock, known := Product{Availability: test.availability}.InStock()
+		assert.Equal(t, test.inStock, inStock, "In stock mismatch for %q", test.availability)
+		assert.Equal(t, test.known, known, "Known mismatch for %q", test.availability)
+	}
+}
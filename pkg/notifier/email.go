@@ -0,0 +1,29 @@
+package notifier
+
+import (
+	"context"
+
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/mailer"
+	"shopscraper/pkg/models"
+)
+
+// Email sends the products in an email over SMTP
+type Email struct {
+	smtpSender  mailer.SmtpSender
+	emailConfig config.EmailConfig
+}
+
+// NewEmail returns a notifier emailing the products with emailConfig
+func NewEmail(smtpSender mailer.SmtpSender, emailConfig config.EmailConfig) *Email {
+	return &Email{smtpSender: smtpSender, emailConfig: emailConfig}
+}
+
+func (e *Email) Name() string {
+	return "email"
+}
+
+// Notify sends the email, SMTP doesn't support cancelling so ctx is unused
+func (e *Email) Notify(ctx context.Context, products []models.Product) error {
+	return mailer.SendEmail(e.smtpSender, products, config.ProgramConfig{Email: e.emailConfig})
+}
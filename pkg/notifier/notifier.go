@@ -0,0 +1,59 @@
+// Package notifier sends the products to notify to the channels of the
+// configuration, by email or to a webhook
+package notifier
+
+import (
+	"context"
+	"fmt"
+
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/mailer"
+	"shopscraper/pkg/models"
+	"shopscraper/pkg/rules"
+)
+
+// Notifier sends products that need notifying to a destination
+type Notifier interface {
+	// Name identifies the notifier in logs and errors
+	Name() string
+	// Notify sends the products, an error means they were not delivered
+	Notify(ctx context.Context, products []models.Product) error
+}
+
+// New returns the notifiers of the default channel and of every configured
+// channel, keyed by the channel name of the rules. A channel is emailed
+// unless it only configures a webhook.
+func New(programConfig config.ProgramConfig, smtpSender mailer.SmtpSender) (map[string][]Notifier, error) {
+	notifiers := make(map[string][]Notifier)
+	defaultNotifiers, err := channelNotifiers(rules.DefaultChannel, programConfig.Email, programConfig.Email, programConfig.Webhook, smtpSender)
+	if err != nil {
+		return nil, err
+	}
+	notifiers[rules.DefaultChannel] = defaultNotifiers
+
+	for channel, channelConfig := range programConfig.Channels {
+		channelNotifiers, err := channelNotifiers(channel, channelConfig.Email, channelConfig.Email.Merge(programConfig.Email), channelConfig.Webhook, smtpSender)
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel %s: %w", channel, err)
+		}
+		notifiers[channel] = channelNotifiers
+	}
+	return notifiers, nil
+}
+
+// channelNotifiers returns the notifiers of a channel, its own email
+// configuration deciding whether a channel with a webhook is also emailed
+func channelNotifiers(channel string, ownEmail, emailConfig config.EmailConfig, webhookConfig config.WebhookConfig, smtpSender mailer.SmtpSender) ([]Notifier, error) {
+	var notifiers []Notifier
+	if webhookConfig.URL == "" || ownEmail.Recipient != "" {
+		notifiers = append(notifiers, NewEmail(smtpSender, emailConfig))
+	}
+	if webhookConfig.URL != "" {
+		webhook, err := NewWebhook(webhookConfig, channel)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	return notifiers, nil
+}
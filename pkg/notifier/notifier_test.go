@@ -0,0 +1,156 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"testing"
+
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/models"
+	"shopscraper/pkg/rules"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Mock SMTP sender that accepts every email
+type mockSmtpSender struct{}
+
+func (m *mockSmtpSender) SendMail(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	return nil
+}
+
+// notifierNames returns the names of the notifiers of a channel
+func notifierNames(notifiers []Notifier) []string {
+	var names []string
+	for _, n := range notifiers {
+		names = append(names, n.Name())
+	}
+	return names
+}
+
+func TestNew(t *testing.T) {
+	programConfig := config.ProgramConfig{
+		Email: config.EmailConfig{Recipient: "recipient@example.com"},
+		Channels: map[string]config.ChannelConfig{
+			"email":   {Email: config.EmailConfig{Recipient: "deals@example.com"}},
+			"webhook": {Webhook: config.WebhookConfig{URL: "https://example.com/hook"}},
+			"both":    {Email: config.EmailConfig{Recipient: "deals@example.com"}, Webhook: config.WebhookConfig{URL: "https://example.com/hook"}},
+		},
+	}
+
+	// Test case 1: Channels are emailed unless they only configure a webhook
+	notifiers, err := New(programConfig, &mockSmtpSender{})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Equal(t, []string{"email"}, notifierNames(notifiers[rules.DefaultChannel]))
+	assert.Equal(t, []string{"email"}, notifierNames(notifiers["email"]))
+	assert.Equal(t, []string{"webhook"}, notifierNames(notifiers["webhook"]))
+	assert.Equal(t, []string{"email", "webhook"}, notifierNames(notifiers["both"]))
+
+	// Test case 2: The default channel with a webhook but no email
+	programConfig.Email = config.EmailConfig{}
+	programConfig.Webhook = config.WebhookConfig{URL: "https://example.com/hook"}
+	notifiers, err = New(programConfig, &mockSmtpSender{})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Equal(t, []string{"webhook"}, notifierNames(notifiers[rules.DefaultChannel]))
+
+	// Test case 3: Invalid webhooks
+	invalid := []config.WebhookConfig{
+		{URL: "example.com/hook"},
+		{URL: "ftp://example.com/hook"},
+		{URL: "https://example.com/hook", Template: "{{ .Products"},
+	}
+	for _, webhookConfig := range invalid {
+		_, err := New(config.ProgramConfig{Webhook: webhookConfig}, &mockSmtpSender{})
+		assert.Error(t, err, "Expected an error for webhook %+v", webhookConfig)
+	}
+	_, err = New(config.ProgramConfig{Channels: map[string]config.ChannelConfig{
+		"deals": {Webhook: config.WebhookConfig{URL: "deals"}},
+	}}, &mockSmtpSender{})
+	assert.ErrorContains(t, err, "invalid channel deals")
+}
+
+func TestWebhook(t *testing.T) {
+	var body []byte
+	var header http.Header
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	products := []models.Product{
+		{Shop: "Shop1", Name: `Product "1"`, Price: 1999, Currency: "EUR", Link: "https://example.com/product1", ChangeReason: models.ChangeNew},
+	}
+
+	// Test case 1: Without a template the channel and products are posted
+	webhook, err := NewWebhook(config.WebhookConfig{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	}, "deals")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = webhook.Notify(context.Background(), products)
+	assert.NoError(t, err, "Notify should not produce an error")
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Equal(t, "deals", payload.Channel)
+	if assert.Equal(t, 1, len(payload.Products)) {
+		assert.Equal(t, products[0].Name, payload.Products[0].Name)
+		assert.Equal(t, models.ChangeNew, payload.Products[0].ChangeReason)
+	}
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Empty(t, header.Get(DefaultSignatureHeader), "Expected no signature without a secret")
+
+	// Test case 2: A template renders the payload, which is signed
+	webhook, err = NewWebhook(config.WebhookConfig{
+		URL:             server.URL,
+		Template:        `{"text": "{{ len .Products }} products"{{ range .Products }}, "first": {{ json .Name }}, "price": "{{ price . }}"{{ break }}{{ end }}}`,
+		Secret:          "secret",
+		SignatureHeader: "X-Signature",
+	}, rules.DefaultChannel)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = webhook.Notify(context.Background(), products)
+	assert.NoError(t, err, "Notify should not produce an error")
+	assert.JSONEq(t, `{"text": "1 products", "first": "Product \"1\"", "price": "`+models.FormatPrice(1999, "EUR")+`"}`, string(body))
+	assert.Equal(t, Sign("secret", body), header.Get("X-Signature"))
+
+	// Test case 3: A template that doesn't render JSON fails before posting
+	body = nil
+	webhook, err = NewWebhook(config.WebhookConfig{URL: server.URL, Template: `{{ .Channel }}`}, "deals")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = webhook.Notify(context.Background(), products)
+	assert.ErrorContains(t, err, "valid JSON")
+	assert.Nil(t, body, "Nothing should be posted")
+
+	// Test case 4: A response other than 2xx is an error
+	status = http.StatusInternalServerError
+	webhook, err = NewWebhook(config.WebhookConfig{URL: server.URL}, "deals")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = webhook.Notify(context.Background(), products)
+	assert.ErrorContains(t, err, "500")
+}
+
+func TestSign(t *testing.T) {
+	// Test vector of RFC 4231, test case 2
+	assert.Equal(t, "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843", Sign("Jefe", []byte("what do ya want for nothing?")))
+}
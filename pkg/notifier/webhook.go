@@ -0,0 +1,154 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/models"
+)
+
+const (
+	// DefaultSignatureHeader is the header of the payload signature when
+	// none is configured
+	DefaultSignatureHeader = "X-Shopscraper-Signature"
+	defaultWebhookTimeout  = 30 * time.Second
+)
+
+// Payload is what the payload template is executed with, and what is sent
+// as JSON without a template
+type Payload struct {
+	Channel  string           `json:"channel"`
+	Products []models.Product `json:"products"`
+}
+
+// templateFuncs are available in payload templates. json encodes a value,
+// e.g. a name with quotes, and price formats the price of a product.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+	"price": func(p models.Product) string {
+		return models.FormatPrice(int64(p.Price), p.CurrencyCode())
+	},
+}
+
+// Webhook posts the products as JSON to an HTTP endpoint
+type Webhook struct {
+	webhookConfig config.WebhookConfig
+	channel       string
+	template      *template.Template
+	client        *http.Client
+}
+
+// NewWebhook returns a notifier posting the products of channel to the
+// webhook of webhookConfig
+func NewWebhook(webhookConfig config.WebhookConfig, channel string) (*Webhook, error) {
+	u, err := url.Parse(webhookConfig.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url '%s', expected an http or https url", webhookConfig.URL)
+	}
+
+	webhook := &Webhook{webhookConfig: webhookConfig, channel: channel}
+	if webhookConfig.Template != "" {
+		webhook.template, err = template.New("payload").Funcs(templateFuncs).Parse(webhookConfig.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook template: %w", err)
+		}
+	}
+	if webhook.webhookConfig.SignatureHeader == "" {
+		webhook.webhookConfig.SignatureHeader = DefaultSignatureHeader
+	}
+	timeout := webhookConfig.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	webhook.client = &http.Client{Timeout: timeout}
+	return webhook, nil
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Notify posts the payload, any response other than 2xx is an error
+func (w *Webhook) Notify(ctx context.Context, products []models.Product) error {
+	if len(products) == 0 {
+		log.Println("No products to send; skipping webhook.")
+		return nil
+	}
+
+	payload, err := w.payload(products)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.webhookConfig.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.webhookConfig.Headers {
+		req.Header.Set(name, value)
+	}
+	if w.webhookConfig.Secret != "" {
+		req.Header.Set(w.webhookConfig.SignatureHeader, Sign(w.webhookConfig.Secret, payload))
+	}
+
+	// Only the host is logged as the URL may contain a token
+	log.Printf("Posting %d items to webhook at %s", len(products), req.URL.Host)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		log.Printf("Failed to post webhook: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Webhook returned %s", resp.Status)
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// payload renders the template, or encodes the Payload without one
+func (w *Webhook) payload(products []models.Product) ([]byte, error) {
+	data := Payload{Channel: w.channel, Products: products}
+	if w.template == nil {
+		return json.Marshal(data)
+	}
+
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render webhook template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("webhook template did not render valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign returns the signature of the payload, sha256= followed by the hex
+// encoded HMAC-SHA256 of the payload keyed with secret
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
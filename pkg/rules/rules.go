@@ -0,0 +1,240 @@
+// Package rules decides which of the products to notify are sent, and to
+// which channel, according to the rules of the configuration
+package rules
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/models"
+	"slices"
+	"strings"
+)
+
+// Actions of a rule
+const (
+	// ActionNotify sends the products to the default channel
+	ActionNotify = "notify"
+	// ActionSuppress marks the products as notified without sending them
+	ActionSuppress = "suppress"
+	// ActionRoute sends the products to the channel of the rule
+	ActionRoute = "route"
+)
+
+// Stock statuses of the availability condition
+const (
+	StockIn      = "in_stock"
+	StockOut     = "out_of_stock"
+	StockUnknown = "unknown"
+)
+
+// DefaultChannel is the channel of products that are notified rather than
+// routed, using the global email configuration
+const DefaultChannel = ""
+
+// Rule is a validated rule of the configuration
+type Rule struct {
+	config.RuleConfig
+	pattern *regexp.Regexp
+}
+
+// Engine evaluates the rules in order, the first matching rule decides what
+// happens to a product
+type Engine struct {
+	rules []Rule
+	// reasons are the change reasons sent to each channel, all when empty
+	reasons map[string][]string
+}
+
+// New validates the rules against the configured channels. The email reasons
+// of the default channel, and of every channel merged with them, decide which
+// products are sent to the channel.
+func New(ruleConfigs []config.RuleConfig, email config.EmailConfig, channels map[string]config.ChannelConfig) (*Engine, error) {
+	engine := &Engine{reasons: map[string][]string{DefaultChannel: email.Reasons}}
+	for channel, channelConfig := range channels {
+		engine.reasons[channel] = channelConfig.Email.Merge(email).Reasons
+	}
+	for channel, reasons := range engine.reasons {
+		if err := validateReasons(reasons); err != nil {
+			if channel == DefaultChannel {
+				return nil, fmt.Errorf("invalid email: %w", err)
+			}
+			return nil, fmt.Errorf("invalid channel %s: %w", channel, err)
+		}
+	}
+
+	for i, ruleConfig := range ruleConfigs {
+		rule, err := newRule(ruleConfig, channels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %s: %w", ruleName(i, ruleConfig), err)
+		}
+		engine.rules = append(engine.rules, rule)
+	}
+	return engine, nil
+}
+
+func validateReasons(reasons []string) error {
+	for _, reason := range reasons {
+		if !models.ValidChangeReason(models.ChangeReason(reason)) {
+			return fmt.Errorf("unknown reason '%s'", reason)
+		}
+	}
+	return nil
+}
+
+func newRule(ruleConfig config.RuleConfig, channels map[string]config.ChannelConfig) (Rule, error) {
+	rule := Rule{RuleConfig: ruleConfig}
+	if rule.Action == "" {
+		rule.Action = ActionNotify
+	}
+
+	switch rule.Action {
+	case ActionNotify, ActionSuppress:
+		if rule.Channel != "" {
+			return Rule{}, fmt.Errorf("channel '%s' is only used by the route action", rule.Channel)
+		}
+	case ActionRoute:
+		if _, ok := channels[rule.Channel]; !ok {
+			return Rule{}, fmt.Errorf("unknown channel '%s'", rule.Channel)
+		}
+	default:
+		return Rule{}, fmt.Errorf("unknown action '%s', expected notify, suppress or route", rule.Action)
+	}
+
+	if rule.NamePattern != "" {
+		pattern, err := regexp.Compile(rule.NamePattern)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid namePattern: %w", err)
+		}
+		rule.pattern = pattern
+	}
+	if rule.MaxPrice > 0 && rule.MinPrice > rule.MaxPrice {
+		return Rule{}, fmt.Errorf("minPrice %v is above maxPrice %v", rule.MinPrice, rule.MaxPrice)
+	}
+	if err := validateReasons(rule.Reasons); err != nil {
+		return Rule{}, err
+	}
+	for _, availability := range rule.Availability {
+		switch availability {
+		case StockIn, StockOut, StockUnknown:
+		default:
+			return Rule{}, fmt.Errorf("unknown availability '%s', expected in_stock, out_of_stock or unknown", availability)
+		}
+	}
+	return rule, nil
+}
+
+func ruleName(i int, ruleConfig config.RuleConfig) string {
+	if ruleConfig.Name != "" {
+		return fmt.Sprintf("'%s'", ruleConfig.Name)
+	}
+	return fmt.Sprintf("%d", i+1)
+}
+
+// Match returns the first rule matching the product, or nil when none does
+func (e *Engine) Match(p models.Product) *Rule {
+	for i := range e.rules {
+		if e.rules[i].Matches(p) {
+			return &e.rules[i]
+		}
+	}
+	return nil
+}
+
+// Route returns the products to send by channel, and those that are
+// suppressed, by a rule or because their channel isn't sent their reason
+func (e *Engine) Route(products []models.Product) (map[string][]models.Product, []models.Product) {
+	routes := make(map[string][]models.Product)
+	var suppressed []models.Product
+	byReason := 0
+	for _, p := range products {
+		rule := e.Match(p)
+		channel := DefaultChannel
+		switch {
+		case rule == nil || rule.Action == ActionNotify:
+		case rule.Action == ActionSuppress:
+			suppressed = append(suppressed, p)
+			continue
+		case rule.Action == ActionRoute:
+			channel = rule.Channel
+		}
+
+		if reasons := e.reasons[channel]; len(reasons) > 0 && !slices.Contains(reasons, string(p.Reason())) {
+			suppressed = append(suppressed, p)
+			byReason++
+			continue
+		}
+		routes[channel] = append(routes[channel], p)
+	}
+	if byRule := len(suppressed) - byReason; byRule > 0 {
+		log.Printf("Suppressed %d products by rules", byRule)
+	}
+	if byReason > 0 {
+		log.Printf("Suppressed %d products changed for reasons their channel isn't sent", byReason)
+	}
+	return routes, suppressed
+}
+
+// Matches reports whether the product meets all conditions of the rule
+func (r *Rule) Matches(p models.Product) bool {
+	if len(r.Shops) > 0 && !slices.Contains(r.Shops, p.Shop) {
+		return false
+	}
+	if len(r.Keywords) > 0 && !containsKeyword(p.Name, r.Keywords) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(p.Name) {
+		return false
+	}
+	if r.MinPrice > 0 && p.Price < models.MinorUnits(r.MinPrice, p.CurrencyCode()) {
+		return false
+	}
+	if r.MaxPrice > 0 && p.Price > models.MinorUnits(r.MaxPrice, p.CurrencyCode()) {
+		return false
+	}
+	if (r.MinDrop > 0 || r.MinDropPercent > 0) && !r.droppedEnough(p) {
+		return false
+	}
+	if len(r.Reasons) > 0 && !slices.Contains(r.Reasons, string(p.Reason())) {
+		return false
+	}
+	if len(r.Availability) > 0 && !slices.Contains(r.Availability, stockStatus(p)) {
+		return false
+	}
+	return true
+}
+
+// droppedEnough reports whether the price of the product dropped from its
+// previous price by at least MinDrop and MinDropPercent
+func (r *Rule) droppedEnough(p models.Product) bool {
+	if !p.PreviousPrice.Valid || p.PreviousPrice.Int64 <= int64(p.Price) {
+		return false
+	}
+	drop := p.PreviousPrice.Int64 - int64(p.Price)
+	if drop < int64(models.MinorUnits(r.MinDrop, p.CurrencyCode())) {
+		return false
+	}
+	return float64(drop)*100 >= r.MinDropPercent*float64(p.PreviousPrice.Int64)
+}
+
+func containsKeyword(name string, keywords []string) bool {
+	name = strings.ToLower(name)
+	for _, keyword := range keywords {
+		if strings.Contains(name, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+func stockStatus(p models.Product) string {
+	inStock, known := p.InStock()
+	switch {
+	case !known:
+		return StockUnknown
+	case inStock:
+		return StockIn
+	}
+	return StockOut
+}
@@ -0,0 +1,177 @@
+package rules
+
+import (
+	"database/sql"
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	channels := map[string]config.ChannelConfig{
+		"deals": {Email: config.EmailConfig{Recipient: "deals@example.com"}},
+	}
+
+	// Test case 1: Valid rules
+	_, err := New([]config.RuleConfig{
+		{Name: "accessories", Keywords: []string{"cable"}, Action: ActionSuppress},
+		{Name: "deals", MinDropPercent: 10, Action: ActionRoute, Channel: "deals"},
+		{NamePattern: "^GPU", MinPrice: 100, MaxPrice: 500, Reasons: []string{"new"}, Availability: []string{StockIn}},
+	}, config.EmailConfig{}, channels)
+	assert.NoError(t, err, "Valid rules should not produce an error")
+
+	// Test case 2: Invalid rules
+	invalid := []config.RuleConfig{
+		{Action: "forward"},
+		{Action: ActionRoute, Channel: "missing"},
+		{Action: ActionSuppress, Channel: "deals"},
+		{NamePattern: "("},
+		{MinPrice: 20, MaxPrice: 10},
+		{Reasons: []string{"sold_out"}},
+		{Availability: []string{"instock"}},
+	}
+	for _, ruleConfig := range invalid {
+		_, err := New([]config.RuleConfig{ruleConfig}, config.EmailConfig{}, channels)
+		assert.Error(t, err, "Expected an error for rule %+v", ruleConfig)
+	}
+
+	// Test case 3: Errors name the rule
+	_, err = New([]config.RuleConfig{{Name: "deals", Action: ActionRoute, Channel: "missing"}}, config.EmailConfig{}, channels)
+	assert.EqualError(t, err, "invalid rule 'deals': unknown channel 'missing'")
+	_, err = New([]config.RuleConfig{{}, {Action: "forward"}}, config.EmailConfig{}, channels)
+	assert.EqualError(t, err, "invalid rule 2: unknown action 'forward', expected notify, suppress or route")
+
+	// Test case 4: Unknown email reasons are rejected
+	_, err = New(nil, config.EmailConfig{Reasons: []string{"price_dropped"}}, channels)
+	assert.EqualError(t, err, "invalid email: unknown reason 'price_dropped'")
+}
+
+func TestMatches(t *testing.T) {
+	product := models.Product{
+		Shop:          "Shop1",
+		Name:          "GPU RTX 4070",
+		Price:         45000,
+		PreviousPrice: sql.NullInt64{Int64: 50000, Valid: true},
+		Availability:  "InStock",
+		ChangeReason:  models.ChangePriceDrop,
+	}
+
+	tests := []struct {
+		rule    config.RuleConfig
+		matches bool
+	}{
+		{config.RuleConfig{}, true},
+		{config.RuleConfig{Shops: []string{"Shop1"}}, true},
+		{config.RuleConfig{Shops: []string{"Shop2"}}, false},
+		{config.RuleConfig{Keywords: []string{"cable", "rtx"}}, true},
+		{config.RuleConfig{Keywords: []string{"cable"}}, false},
+		{config.RuleConfig{NamePattern: `^GPU RTX 40\d0$`}, true},
+		{config.RuleConfig{NamePattern: `^CPU`}, false},
+		{config.RuleConfig{MinPrice: 450, MaxPrice: 450}, true},
+		{config.RuleConfig{MinPrice: 450.01}, false},
+		{config.RuleConfig{MaxPrice: 449.99}, false},
+		{config.RuleConfig{MinDrop: 50}, true},
+		{config.RuleConfig{MinDrop: 50.01}, false},
+		{config.RuleConfig{MinDropPercent: 10}, true},
+		{config.RuleConfig{MinDropPercent: 10.5}, false},
+		{config.RuleConfig{Reasons: []string{"price_drop", "restock"}}, true},
+		{config.RuleConfig{Reasons: []string{"new"}}, false},
+		{config.RuleConfig{Availability: []string{StockIn}}, true},
+		{config.RuleConfig{Availability: []string{StockOut, StockUnknown}}, false},
+		{config.RuleConfig{Shops: []string{"Shop1"}, Keywords: []string{"cable"}}, false},
+	}
+
+	for _, test := range tests {
+		engine, err := New([]config.RuleConfig{test.rule}, config.EmailConfig{}, nil)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		assert.Equal(t, test.matches, engine.Match(product) != nil, "Unexpected match of rule %+v", test.rule)
+	}
+
+	// Test case 1: Products without a price drop don't match a minimum drop
+	engine, err := New([]config.RuleConfig{{MinDropPercent: 1}}, config.EmailConfig{}, nil)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Nil(t, engine.Match(models.Product{Price: 100}), "A new product should not match a minimum drop")
+
+	// Test case 2: Products without a known availability are unknown
+	engine, err = New([]config.RuleConfig{{Availability: []string{StockUnknown}}}, config.EmailConfig{}, nil)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.NotNil(t, engine.Match(models.Product{Availability: "Ask in store"}), "Expected an unknown availability to match")
+
+	// Test case 3: Prices are compared in the minor unit of the product currency
+	engine, err = New([]config.RuleConfig{{MaxPrice: 2000}}, config.EmailConfig{}, nil)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Nil(t, engine.Match(models.Product{Price: 150000, Currency: "JPY"}), "Expected 150000 JPY to be above 2000")
+	assert.NotNil(t, engine.Match(models.Product{Price: 1500000, Currency: "KWD"}), "Expected 1500 KWD to be below 2000")
+}
+
+func TestRoute(t *testing.T) {
+	channels := map[string]config.ChannelConfig{"deals": {}}
+	engine, err := New([]config.RuleConfig{
+		{Keywords: []string{"cable"}, Action: ActionSuppress},
+		{Shops: []string{"Shop2"}, Action: ActionRoute, Channel: "deals"},
+		{Shops: []string{"Shop2", "Shop3"}, Action: ActionSuppress},
+	}, config.EmailConfig{}, channels)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	products := []models.Product{
+		{Shop: "Shop1", Name: "Product1"},
+		{Shop: "Shop2", Name: "USB cable"},
+		{Shop: "Shop2", Name: "Product2"},
+		{Shop: "Shop3", Name: "Product3"},
+	}
+	routes, suppressed := engine.Route(products)
+
+	// The first matching rule decides
+	assert.Equal(t, map[string][]models.Product{
+		DefaultChannel: {products[0]},
+		"deals":        {products[2]},
+	}, routes)
+	assert.Equal(t, []models.Product{products[1], products[3]}, suppressed)
+
+	// Without rules everything goes to the default channel
+	engine, err = New(nil, config.EmailConfig{}, nil)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	routes, suppressed = engine.Route(products)
+	assert.Equal(t, map[string][]models.Product{DefaultChannel: products}, routes)
+	assert.Empty(t, suppressed)
+
+	// Products changed for reasons their channel isn't sent are suppressed,
+	// channels take the reasons of the email unless they have their own
+	products = []models.Product{
+		{Shop: "Shop1", Name: "Product1", ChangeReason: models.ChangeNew},
+		{Shop: "Shop1", Name: "Product2", ChangeReason: models.ChangePriceRise},
+		{Shop: "Shop2", Name: "Product3", ChangeReason: models.ChangePriceRise},
+		{Shop: "Shop3", Name: "Product4", ChangeReason: models.ChangePriceRise},
+	}
+	channels = map[string]config.ChannelConfig{
+		"deals":  {},
+		"prices": {Email: config.EmailConfig{Reasons: []string{"price_rise"}}},
+	}
+	engine, err = New([]config.RuleConfig{
+		{Shops: []string{"Shop2"}, Action: ActionRoute, Channel: "deals"},
+		{Shops: []string{"Shop3"}, Action: ActionRoute, Channel: "prices"},
+	}, config.EmailConfig{Reasons: []string{"new"}}, channels)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	routes, suppressed = engine.Route(products)
+	assert.Equal(t, map[string][]models.Product{
+		DefaultChannel: {products[0]},
+		"prices":       {products[3]},
+	}, routes)
+	assert.Equal(t, []models.Product{products[1], products[2]}, suppressed)
+}
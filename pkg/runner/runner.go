@@ -0,0 +1,254 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/database"
+	"shopscraper/pkg/models"
+	"shopscraper/pkg/notifier"
+	"shopscraper/pkg/rules"
+	"shopscraper/pkg/scheduler"
+	"shopscraper/pkg/scraper"
+)
+
+// Options configure scrape runs
+type Options struct {
+	// MaxWorkers is the maximum number of pages fetched concurrently per scraper
+	MaxWorkers int
+	// KeepDuration is how long products that are no longer seen are kept
+	KeepDuration time.Duration
+	// RunTimeout limits how long scraping may take, 0 for no limit
+	RunTimeout time.Duration
+	// Interval is the schedule of scrapers that don't configure one
+	Interval time.Duration
+	// Debug prints the scraped, new and changed products
+	Debug bool
+}
+
+// Scrape runs the scrapers concurrently, saves the scraped products and
+// removes products that have not been seen within KeepDuration. Scraping stops
+// at the run timeout or when ctx is done, but what was scraped until then is
+// saved, saving is a single transaction that finishes quickly.
+func Scrape(ctx context.Context, db database.Database, scrapers []scraper.Scraper, opts Options) (database.SaveResult, error) {
+	saveCtx := context.WithoutCancel(ctx)
+	scrapeCtx := ctx
+	if opts.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		scrapeCtx, cancel = context.WithTimeout(ctx, opts.RunTimeout)
+		defer cancel()
+	}
+	report := &scraper.Report{}
+	scrapeCtx = scraper.WithReport(scrapeCtx, report)
+
+	// Create channels to receive scraped products from each scraper
+	productChans := make([]chan []models.Product, len(scrapers))
+
+	// Run each scraper concurrently
+	var wg sync.WaitGroup
+
+	for i, s := range scrapers {
+		productChans[i] = make(chan []models.Product)
+		wg.Add(1)
+		go func(i int, s scraper.Scraper) {
+			defer wg.Done()
+			products, err := s.Scrape(scrapeCtx, opts.MaxWorkers)
+			if err != nil {
+				log.Printf("Failed to scrape using scraper %d: %v", i, err)
+			}
+			productChans[i] <- products
+
+		}(i, s)
+	}
+
+	// Close the channels after all scrapers have finished
+	go func() {
+		wg.Wait()
+		for _, ch := range productChans {
+			close(ch)
+		}
+	}()
+
+	// Aggregate the output from each scraper into a single list
+	var allProducts []models.Product
+	for _, ch := range productChans {
+		products := <-ch
+		allProducts = append(allProducts, products...)
+	}
+
+	logReport(report)
+
+	if opts.Debug {
+		log.Println("All products:")
+		for _, p := range allProducts {
+			fmt.Printf("%s - %s, Price: %s, Link: %s\n", p.Shop, p.Name, models.FormatPrice(int64(p.Price), p.CurrencyCode()), p.Link)
+		}
+	}
+
+	result, err := db.SaveProducts(saveCtx, allProducts)
+	if err != nil {
+		return result, err
+	}
+
+	// Remove items from the database that haven't been seen within the keep duration
+	err = db.RemoveOldProducts(saveCtx, opts.KeepDuration)
+	if err != nil {
+		return result, err
+	}
+
+	if opts.Debug {
+		log.Println("New products:")
+		// Print all new products
+		for _, p := range result.New {
+			fmt.Printf("%s - %s, Price: %s, Link: %s\n", p.Shop, p.Name, models.FormatPrice(int64(p.Price), p.CurrencyCode()), p.Link)
+		}
+		log.Println("Price changes:")
+		for _, p := range result.PriceChanged {
+			fmt.Printf("%s - %s, Price: %s (was %s), Link: %s\n", p.Shop, p.Name, models.FormatPrice(int64(p.Price), p.CurrencyCode()), models.FormatPrice(p.PreviousPrice.Int64, p.CurrencyCode()), p.Link)
+		}
+		log.Println("Back in stock:")
+		for _, p := range result.Restocked {
+			fmt.Printf("%s - %s, Price: %s, Link: %s\n", p.Shop, p.Name, models.FormatPrice(int64(p.Price), p.CurrencyCode()), p.Link)
+		}
+	}
+
+	return result, nil
+}
+
+// LoadDetails fills the detail cache of shared with the details stored with
+// the products in db, so the detail pages of products unchanged since they
+// were saved aren't fetched again
+func LoadDetails(ctx context.Context, db database.Database, programConfig config.ProgramConfig, shared *scraper.Shared) error {
+	products, err := db.GetAllProducts(ctx)
+	if err != nil {
+		return err
+	}
+	shared.Details.Load(programConfig.Scrapers, products)
+	return nil
+}
+
+// Notify sends the products that have not been notified yet through the
+// notifiers of their channel and marks them as notified. The rules of the
+// configuration decide which products are sent and to which channel,
+// suppressed products are marked without being sent. The products of a
+// channel are only marked once all of its notifiers delivered them, so a
+// failed notifier is retried with the next run, when the notifiers that
+// succeeded send them again. They are marked even when ctx is done, so
+// shutting down never causes the same email to be sent twice.
+func Notify(ctx context.Context, db database.Database, notifiers map[string][]notifier.Notifier, programConfig config.ProgramConfig) error {
+	engine, err := rules.New(programConfig.Rules, programConfig.Email, programConfig.Channels)
+	if err != nil {
+		return err
+	}
+
+	nonNotifiedProducts, err := db.GetNonNotifiedProducts(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(nonNotifiedProducts) == 0 {
+		log.Println("No products found to notify")
+		return nil
+	}
+
+	routes, notified := engine.Route(nonNotifiedProducts)
+	channels := make([]string, 0, len(routes))
+	for channel := range routes {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	// A channel failing doesn't keep the others from being sent and marked
+	var errs []error
+	for _, channel := range channels {
+		delivered := len(notifiers[channel]) > 0
+		for _, n := range notifiers[channel] {
+			err := n.Notify(ctx, routes[channel])
+			if err != nil {
+				err = fmt.Errorf("%s: %w", n.Name(), err)
+				if channel != rules.DefaultChannel {
+					err = fmt.Errorf("channel %s: %w", channel, err)
+				}
+				errs = append(errs, err)
+				delivered = false
+			}
+		}
+		if len(notifiers[channel]) == 0 {
+			errs = append(errs, fmt.Errorf("channel %s has no notifiers", channel))
+		}
+		if delivered {
+			notified = append(notified, routes[channel]...)
+		}
+	}
+
+	if len(notified) > 0 {
+		if err := db.SetNotifiedProducts(context.WithoutCancel(ctx), notified); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Jobs returns a scheduler job for every scraper that scrapes and saves on
+// the schedule of its configuration, or every Interval when it has none.
+// The scrapers share shared, which the caller closes once the jobs are done.
+// afterSave is called with the result of every run that saved products.
+func Jobs(db database.Database, programConfig config.ProgramConfig, shared *scraper.Shared, opts Options, afterSave func(database.SaveResult)) ([]scheduler.Job, error) {
+	scrapers, err := scraper.CreateScrapers(programConfig.Scrapers, shared)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []scheduler.Job
+	for i, s := range scrapers {
+		scraperConfig := programConfig.Scrapers[i]
+
+		var schedule scheduler.Schedule = scheduler.Every(opts.Interval)
+		if scraperConfig.Schedule != "" {
+			schedule, err = scheduler.ParseSchedule(scraperConfig.Schedule)
+			if err != nil {
+				return nil, fmt.Errorf("invalid configuration for '%s': %w", scraperConfig.ShopName, err)
+			}
+		}
+
+		// Products of a shop scraped less often than KeepDuration would be
+		// removed and reported as new again on every run
+		if gap := scheduler.LongestGap(schedule, time.Now(), 10); gap >= opts.KeepDuration {
+			log.Printf("Warning: '%s' runs up to %s apart, its products are removed after %s without being seen, increase the keep duration", scraperConfig.ShopName, gap, opts.KeepDuration)
+		}
+
+		jobs = append(jobs, scheduler.Job{
+			Name:     scraperConfig.ShopName,
+			Schedule: schedule,
+			Run: func(ctx context.Context) {
+				result, err := Scrape(ctx, db, []scraper.Scraper{s}, opts)
+				if err != nil {
+					log.Printf("Failed to save products of %s: %v", scraperConfig.ShopName, err)
+					return
+				}
+				if afterSave != nil {
+					afterSave(result)
+				}
+			},
+		})
+	}
+	return jobs, nil
+}
+
+// logReport logs the URLs the scrapers skipped during the run
+func logReport(report *scraper.Report) {
+	skipped := report.SkippedURLs()
+	if len(skipped) == 0 {
+		return
+	}
+	log.Printf("Run report: skipped %d URLs", len(skipped))
+	for _, s := range skipped {
+		log.Printf("Run report: skipped %s for %s: %s", s.URL, s.Shop, s.Reason)
+	}
+}
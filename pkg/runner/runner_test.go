@@ -0,0 +1,535 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"testing"
+	"time"
+
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/database"
+	"shopscraper/pkg/mailer"
+	"shopscraper/pkg/models"
+	"shopscraper/pkg/notifier"
+	"shopscraper/pkg/scraper"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestDB returns an empty in-memory database
+func newTestDB(t *testing.T) database.Database {
+	db := database.NewMemoryDB()
+	err := db.EnsureProductTableExists(context.Background())
+	if err != nil {
+		t.Fatalf("failed to ensure table exists %v", err)
+	}
+	return db
+}
+
+func TestScrapeWithMockScraper(t *testing.T) {
+	db := newTestDB(t)
+
+	var currentTime = time.Now().UTC()
+
+	var product1 = models.Product{Shop: "Shop1", Name: "Product1", Price: 10, Link: "https://example.com/product1", LastSeen: currentTime}
+	var product2 = models.Product{Shop: "Shop1", Name: "Product2", Price: 20, Link: "https://example.com/product2", LastSeen: currentTime}
+	var product3 = models.Product{Shop: "Shop2", Name: "Product3", Price: 30, Link: "https://example.com/product3", LastSeen: currentTime}
+	var product4 = models.Product{Shop: "Shop2", Name: "Product4", Price: 40, Link: "https://example.com/product4", LastSeen: currentTime}
+
+	var expectedProducts = []models.Product{product1, product2, product3, product4}
+
+	mockScrapers := []scraper.Scraper{
+		&mockScraper{products: []models.Product{product1, product2}},
+		&mockScraper{products: []models.Product{product3, product4}},
+	}
+
+	maxWorkers := 2
+	_, err := Scrape(context.Background(), db, mockScrapers, Options{MaxWorkers: maxWorkers, KeepDuration: -1 * time.Hour})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	retrievedProducts, err := db.GetAllProducts(context.Background())
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	assert.Equal(t, len(expectedProducts), len(retrievedProducts), "The number of products does not match")
+
+	for i, expected := range expectedProducts {
+		assert.Equal(t, expected.Name, retrievedProducts[i].Name, "Product names do not match")
+		assert.Equal(t, expected.Shop, retrievedProducts[i].Shop, "Product shops do not match")
+		assert.Equal(t, expected.Price, retrievedProducts[i].Price, "Product prices do not match")
+		assert.Equal(t, expected.Link, retrievedProducts[i].Link, "Product links do not match")
+		expectedRounded := expected.LastSeen.UTC().Round(time.Millisecond)
+		retrievedRounded := retrievedProducts[i].LastSeen.UTC().Round(time.Millisecond)
+		assert.Equal(t, expectedRounded.String(), retrievedRounded.String(), "Product last seen timestamps do not match")
+	}
+}
+func TestScrapeWithMockGetHTML(t *testing.T) {
+	db := newTestDB(t)
+
+	htmlContent := `
+		<div class="item">
+			<div class="name">Product 1</div>
+			<div class="price">1 499,00€</div>
+			<a class="link" href="/product1">Product 1 Link</a>
+		</div>
+		<div class="item">
+			<div class="name">Product 2</div>
+			<div class="price">2 999,00€</div>
+			<a class="link" href="/product2">Product 2 Link</a>
+		</div>
+	`
+
+	config := config.ScraperConfig{
+		URLs:             []string{"http://example.com"},
+		ItemSelector:     ".item",
+		NameSelector:     ".name",
+		LinkSelector:     ".link",
+		NextPageSelector: ".next",
+		PriceSelector:    []string{".price"},
+		ShopName:         "Test Shop",
+	}
+
+	scrapers := []scraper.Scraper{
+		&scraper.BaseScraper{
+			Config: config,
+			HTMLGetter: &mockHTMLGetter{
+				HTMLContent: htmlContent,
+			},
+		},
+	}
+
+	maxWorkers := 1
+	_, err := Scrape(context.Background(), db, scrapers, Options{MaxWorkers: maxWorkers, KeepDuration: 1 * time.Hour})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	products, err := db.GetAllProducts(context.Background())
+	if err != nil {
+		log.Fatalf("error %v", err)
+	}
+
+	expectedProducts := []models.Product{
+		{
+			Name:     "Product 1",
+			Shop:     "Test Shop",
+			Price:    149900,
+			Link:     "http://example.com/product1",
+			LastSeen: time.Now().UTC(),
+			Notified: false,
+		},
+		{
+			Name:     "Product 2",
+			Shop:     "Test Shop",
+			Price:    299900,
+			Link:     "http://example.com/product2",
+			LastSeen: time.Now().UTC(),
+			Notified: false,
+		},
+	}
+	for i, expected := range expectedProducts {
+		assert.Equal(t, expected.Name, products[i].Name, "Product %d Name mismatch", i+1)
+		assert.Equal(t, expected.Shop, products[i].Shop, "Product %d Shop mismatch", i+1)
+		assert.Equal(t, expected.Price, products[i].Price, "Product %d Price mismatch", i+1)
+		assert.Equal(t, expected.Link, products[i].Link, "Product %d Link mismatch", i+1)
+		assert.Equal(t, expected.Notified, products[i].Notified, "Product %d Notified status mismatch", i+1)
+	}
+
+}
+
+func TestScrapeWithRunTimeout(t *testing.T) {
+	db := newTestDB(t)
+
+	product := models.Product{Shop: "Shop1", Name: "Product1", Price: 10, Link: "https://example.com/product1", LastSeen: time.Now().UTC()}
+	scrapers := []scraper.Scraper{
+		&mockScraper{products: []models.Product{product}, waitForDone: true},
+	}
+
+	start := time.Now()
+	_, err := Scrape(context.Background(), db, scrapers, Options{MaxWorkers: 1, KeepDuration: time.Hour, RunTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Less(t, time.Since(start), 5*time.Second, "The run should stop at the run timeout")
+
+	// Products scraped before the timeout are still saved
+	products, err := db.GetAllProducts(context.Background())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Equal(t, 1, len(products), "The products scraped before the timeout should be saved")
+}
+
+func TestScrapeWithStoredDetails(t *testing.T) {
+	listPrice := "10,00 €"
+	detailFetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list":
+			w.Write([]byte(`<div class="item"><div class="name">Product 1</div><div class="price">` + listPrice + `</div><a class="link" href="/product1">Link</a></div>`))
+		case "/product1":
+			detailFetches++
+			w.Write([]byte(`<span class="sku">SKU-1</span><div class="description">A product</div>`))
+		}
+	}))
+	defer server.Close()
+
+	db := newTestDB(t)
+	programConfig := config.ProgramConfig{
+		Scrapers: []config.ScraperConfig{{
+			Type:          "WebShopScraper",
+			ShopName:      "Shop1",
+			URLs:          []string{server.URL + "/list"},
+			ItemSelector:  ".item",
+			NameSelector:  ".name",
+			LinkSelector:  ".link",
+			PriceSelector: []string{".price"},
+			Detail:        &config.DetailConfig{SKUSelector: ".sku", DescriptionSelector: ".description"},
+		}},
+	}
+	opts := Options{MaxWorkers: 1, KeepDuration: time.Hour}
+	// scrape runs the scrapers like a new scraper process
+	scrape := func() {
+		t.Helper()
+		shared := scraper.NewShared(programConfig)
+		defer shared.Close()
+		if err := LoadDetails(context.Background(), db, programConfig, shared); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		scrapers, err := scraper.CreateScrapers(programConfig.Scrapers, shared)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if _, err := Scrape(context.Background(), db, scrapers, opts); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	// Test case 1: The details of the first run are stored with the product
+	scrape()
+	assert.Equal(t, 1, detailFetches)
+
+	// Test case 2: A new process reuses the stored details of an unchanged product
+	scrape()
+	assert.Equal(t, 1, detailFetches, "Expected the stored details to be used")
+	products, err := db.GetAllProducts(context.Background())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if assert.Equal(t, 1, len(products)) {
+		assert.Equal(t, "SKU-1", products[0].SKU)
+		assert.Equal(t, "A product", products[0].Description)
+	}
+
+	// Test case 3: The details of a product whose price changed are fetched again
+	listPrice = "11,00 €"
+	scrape()
+	assert.Equal(t, 2, detailFetches)
+}
+
+func TestNotify(t *testing.T) {
+	originalValue, isSet := os.LookupEnv("SHOPSCRAPER_SMTP_PASSWORD")
+	defer func() {
+		if isSet {
+			os.Setenv("SHOPSCRAPER_SMTP_PASSWORD", originalValue)
+		} else {
+			os.Unsetenv("SHOPSCRAPER_SMTP_PASSWORD")
+		}
+	}()
+	os.Setenv("SHOPSCRAPER_SMTP_PASSWORD", "test")
+
+	db := newTestDB(t)
+	programConfig := config.ProgramConfig{
+		Email: config.EmailConfig{
+			Recipient: "recipient@example.com",
+			Sender:    "sender@example.com",
+			Subject:   "New Products",
+			Server:    "smtp.example.com",
+			Port:      "587",
+		},
+	}
+	_, err := db.SaveProducts(context.Background(), []models.Product{
+		{Shop: "Shop1", Name: "Product1", Price: 10, Link: "https://example.com/product1", LastSeen: time.Now().UTC()},
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// Test case 1: A failed email leaves the products to be notified
+	sender := &mockSmtpSender{err: errors.New("connection refused")}
+	err = Notify(context.Background(), db, newNotifiers(t, sender, programConfig), programConfig)
+	assert.Error(t, err, "Expected the send error to be returned")
+	products, err := db.GetNonNotifiedProducts(context.Background())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Equal(t, 1, len(products), "The product should not be notified")
+
+	// Test case 2: A sent email marks the products as notified, even when the
+	// context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	sender = &mockSmtpSender{onSend: cancel}
+	err = Notify(ctx, db, newNotifiers(t, sender, programConfig), programConfig)
+	assert.NoError(t, err, "Notify should not produce an error")
+	assert.Equal(t, 1, sender.calls, "Expected one email")
+	products, err = db.GetNonNotifiedProducts(context.Background())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Empty(t, products, "The product should be notified")
+
+	// Test case 3: Nothing is sent without products to notify
+	sender = &mockSmtpSender{}
+	err = Notify(context.Background(), db, newNotifiers(t, sender, programConfig), programConfig)
+	assert.NoError(t, err, "Notify should not produce an error")
+	assert.Equal(t, 0, sender.calls, "Expected no email")
+}
+
+func TestNotifyFailingNotifier(t *testing.T) {
+	originalValue, isSet := os.LookupEnv("SHOPSCRAPER_SMTP_PASSWORD")
+	defer func() {
+		if isSet {
+			os.Setenv("SHOPSCRAPER_SMTP_PASSWORD", originalValue)
+		} else {
+			os.Unsetenv("SHOPSCRAPER_SMTP_PASSWORD")
+		}
+	}()
+	os.Setenv("SHOPSCRAPER_SMTP_PASSWORD", "test")
+
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+	}))
+	defer server.Close()
+
+	db := newTestDB(t)
+	programConfig := config.ProgramConfig{
+		Email: config.EmailConfig{
+			Recipient: "recipient@example.com",
+			Sender:    "sender@example.com",
+			Subject:   "New Products",
+			Server:    "smtp.example.com",
+			Port:      "587",
+		},
+		Webhook: config.WebhookConfig{URL: server.URL},
+	}
+	_, err := db.SaveProducts(context.Background(), []models.Product{
+		{Shop: "Shop1", Name: "Product1", Price: 10, Link: "https://example.com/product1", LastSeen: time.Now().UTC()},
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// Test case 1: The products stay to be notified when the email fails,
+	// although the webhook succeeded
+	sender := &mockSmtpSender{err: errors.New("connection refused")}
+	err = Notify(context.Background(), db, newNotifiers(t, sender, programConfig), programConfig)
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Equal(t, 1, posts, "Expected the webhook to be posted to")
+	products, err := db.GetNonNotifiedProducts(context.Background())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Equal(t, 1, len(products), "The product should not be notified")
+
+	// Test case 2: The products are marked once every notifier delivered them
+	sender = &mockSmtpSender{}
+	err = Notify(context.Background(), db, newNotifiers(t, sender, programConfig), programConfig)
+	assert.NoError(t, err, "Notify should not produce an error")
+	assert.Equal(t, 1, sender.calls, "Expected the email to be retried")
+	products, err = db.GetNonNotifiedProducts(context.Background())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Empty(t, products, "The product should be notified")
+}
+
+func TestNotifyRules(t *testing.T) {
+	originalValue, isSet := os.LookupEnv("SHOPSCRAPER_SMTP_PASSWORD")
+	defer func() {
+		if isSet {
+			os.Setenv("SHOPSCRAPER_SMTP_PASSWORD", originalValue)
+		} else {
+			os.Unsetenv("SHOPSCRAPER_SMTP_PASSWORD")
+		}
+	}()
+	os.Setenv("SHOPSCRAPER_SMTP_PASSWORD", "test")
+
+	db := newTestDB(t)
+	programConfig := config.ProgramConfig{
+		Email: config.EmailConfig{
+			Recipient: "recipient@example.com",
+			Sender:    "sender@example.com",
+			Subject:   "New Products",
+			Server:    "smtp.example.com",
+			Port:      "587",
+		},
+		Rules: []config.RuleConfig{
+			{Name: "accessories", Keywords: []string{"cable"}, Action: "suppress"},
+			{Name: "deals", Shops: []string{"Shop2"}, Action: "route", Channel: "deals"},
+		},
+		Channels: map[string]config.ChannelConfig{
+			"deals": {Email: config.EmailConfig{Recipient: "deals@example.com"}},
+		},
+	}
+	_, err := db.SaveProducts(context.Background(), []models.Product{
+		{Shop: "Shop1", Name: "Product1", Price: 1000, Link: "https://example.com/product1", LastSeen: time.Now().UTC()},
+		{Shop: "Shop1", Name: "USB cable", Price: 500, Link: "https://example.com/cable", LastSeen: time.Now().UTC()},
+		{Shop: "Shop2", Name: "Product2", Price: 2000, Link: "https://example.com/product2", LastSeen: time.Now().UTC()},
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// Test case 1: A failing channel doesn't keep the others from being marked
+	sender := &mockSmtpSender{err: errors.New("connection refused")}
+	err = Notify(context.Background(), db, newNotifiers(t, sender, programConfig), programConfig)
+	assert.Error(t, err, "Expected the send error to be returned")
+	products, err := db.GetNonNotifiedProducts(context.Background())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Equal(t, 2, len(products), "Only the suppressed product should be notified")
+
+	// Test case 2: Products are emailed to the channel of their rule
+	sender = &mockSmtpSender{}
+	err = Notify(context.Background(), db, newNotifiers(t, sender, programConfig), programConfig)
+	assert.NoError(t, err, "Notify should not produce an error")
+	assert.ElementsMatch(t, []string{"recipient@example.com", "deals@example.com"}, sender.recipients)
+	products, err = db.GetNonNotifiedProducts(context.Background())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	assert.Empty(t, products, "The products should be notified")
+
+	// Test case 3: Products routed to a channel with a webhook are posted to it
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload notifier.Payload
+		json.NewDecoder(r.Body).Decode(&payload)
+		for _, p := range payload.Products {
+			posted = append(posted, p.Name)
+		}
+	}))
+	defer server.Close()
+	programConfig.Channels["deals"] = config.ChannelConfig{Webhook: config.WebhookConfig{URL: server.URL}}
+	_, err = db.SaveProducts(context.Background(), []models.Product{
+		{Shop: "Shop2", Name: "Product3", Price: 3000, Link: "https://example.com/product3", LastSeen: time.Now().UTC()},
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	sender = &mockSmtpSender{}
+	err = Notify(context.Background(), db, newNotifiers(t, sender, programConfig), programConfig)
+	assert.NoError(t, err, "Notify should not produce an error")
+	assert.Equal(t, 0, sender.calls, "A channel with only a webhook should not be emailed")
+	assert.Equal(t, []string{"Product3"}, posted)
+
+	// Test case 4: Invalid rules fail before anything is sent
+	programConfig.Rules = []config.RuleConfig{{Action: "route", Channel: "missing"}}
+	err = Notify(context.Background(), db, newNotifiers(t, sender, programConfig), programConfig)
+	assert.Error(t, err, "Expected an error for an unknown channel")
+}
+
+func TestJobs(t *testing.T) {
+	db := newTestDB(t)
+	opts := Options{MaxWorkers: 1, KeepDuration: 72 * time.Hour, Interval: time.Hour}
+	programConfig := config.ProgramConfig{
+		Scrapers: []config.ScraperConfig{
+			{Type: "WebShopScraper", ShopName: "Hourly"},
+			{Type: "WebShopScraper", ShopName: "Weekdays", Schedule: "0 6 * * MON-FRI"},
+		},
+	}
+
+	// Test case 1: A job per scraper, using the interval when no schedule is configured
+	shared := scraper.NewShared(programConfig)
+	defer shared.Close()
+	jobs, err := Jobs(db, programConfig, shared, opts, nil)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if assert.Equal(t, 2, len(jobs), "Expected a job per scraper") {
+		now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local) // a Friday
+		assert.Equal(t, "Hourly", jobs[0].Name)
+		assert.Equal(t, now.Add(time.Hour), jobs[0].Schedule.Next(now))
+		assert.Equal(t, "Weekdays", jobs[1].Name)
+		assert.Equal(t, time.Date(2024, 3, 4, 6, 0, 0, 0, time.Local), jobs[1].Schedule.Next(now))
+	}
+
+	// Test case 2: An invalid schedule is a configuration error
+	programConfig.Scrapers[1].Schedule = "every tuesday"
+	_, err = Jobs(db, programConfig, shared, opts, nil)
+	assert.ErrorContains(t, err, "invalid configuration for 'Weekdays'")
+}
+
+// newNotifiers returns the notifiers of the configuration, emailing with sender
+func newNotifiers(t *testing.T, sender mailer.SmtpSender, programConfig config.ProgramConfig) map[string][]notifier.Notifier {
+	notifiers, err := notifier.New(programConfig, sender)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return notifiers
+}
+
+// Mock SMTP sender counts the emails and fails with err if set
+type mockSmtpSender struct {
+	calls  int
+	err    error
+	onSend func()
+	// recipients are those of every email sent
+	recipients []string
+}
+
+func (m *mockSmtpSender) SendMail(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	m.calls++
+	m.recipients = append(m.recipients, to...)
+	if m.onSend != nil {
+		m.onSend()
+	}
+	return m.err
+}
+
+// Mock scraper implementation returns specific products it's set up with
+type mockScraper struct {
+	products []models.Product
+	// waitForDone makes Scrape return only when ctx is done, like a slow shop
+	waitForDone bool
+}
+
+func (s *mockScraper) Scrape(ctx context.Context, maxWorkers int) ([]models.Product, error) {
+	if s.waitForDone {
+		<-ctx.Done()
+		return s.products, ctx.Err()
+	}
+	return s.products, nil
+}
+
+func (s *mockScraper) ParseHTML(htmlContent, fetchedUrl string) ([]models.Product, string, error) {
+	return s.products, "", nil
+}
+
+func (s *mockScraper) GetPrice(q *goquery.Selection) (scraper.Price, error) {
+	return scraper.Price{}, nil
+}
+
+func (s *mockScraper) ParsePrice(itemPrice string) (scraper.Price, error) {
+	return scraper.Price{}, nil
+}
+
+// Mock HTML getter returns specific HTMLContent it's set up with
+type mockHTMLGetter struct {
+	HTMLContent string
+}
+
+func (c *mockHTMLGetter) GetHTML(ctx context.Context, currentURL string) (string, error) {
+	return c.HTMLContent, nil
+}
@@ -0,0 +1,116 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	// Friday 1 March 2024, 12:34:56
+	from := time.Date(2024, 3, 1, 12, 34, 56, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		spec string
+		want []time.Time
+	}{
+		{"duration", "6h", []time.Time{from.Add(6 * time.Hour), from.Add(12 * time.Hour)}},
+		{"every", "@every 30m", []time.Time{from.Add(30 * time.Minute)}},
+		{"every minute", "* * * * *", []time.Time{
+			time.Date(2024, 3, 1, 12, 35, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 12, 36, 0, 0, time.UTC),
+		}},
+		{"hourly", "@hourly", []time.Time{
+			time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC),
+		}},
+		{"weekly on sunday", "@weekly", []time.Time{time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)}},
+		{"steps", "*/20 */6 * * *", []time.Time{
+			time.Date(2024, 3, 1, 12, 40, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 18, 20, 0, 0, time.UTC),
+		}},
+		{"weekdays by name", "0 6 * * MON-FRI", []time.Time{
+			time.Date(2024, 3, 4, 6, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 5, 6, 0, 0, 0, time.UTC),
+		}},
+		{"sunday as 7", "30 8 * * 7", []time.Time{time.Date(2024, 3, 3, 8, 30, 0, 0, time.UTC)}},
+		{"list and month", "0 0 1,15 jun *", []time.Time{
+			time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		{"day of month or day of week", "0 0 13 * FRI", []time.Time{
+			time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
+		}},
+		{"day of month step and day of week", "0 0 */2 * MON", []time.Time{
+			time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 25, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		{"leap day", "0 0 29 2 *", []time.Time{time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)}},
+		{"start with step", "5/30 * * * *", []time.Time{
+			time.Date(2024, 3, 1, 12, 35, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 13, 5, 0, 0, time.UTC),
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tc.spec)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			next := from
+			for _, want := range tc.want {
+				next = schedule.Next(next)
+				if !next.Equal(want) {
+					t.Errorf("Expected %s, but got %s", want, next)
+				}
+			}
+		})
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"-1h",
+		"0s",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"30-10 * * * *",
+		"* * * foo *",
+		"0 0 30 2 *",
+		"@fortnightly",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expected an error for '%s'", spec)
+		}
+	}
+}
+
+func TestLongestGap(t *testing.T) {
+	from := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Test case 1: A fixed interval
+	if gap := LongestGap(Every(time.Hour), from, 5); gap != time.Hour {
+		t.Errorf("Expected 1h, but got %s", gap)
+	}
+
+	// Test case 2: Weekdays are up to three days apart over the weekend
+	schedule, err := ParseSchedule("0 6 * * 1-5")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if gap := LongestGap(schedule, from, 10); gap != 72*time.Hour {
+		t.Errorf("Expected 72h, but got %s", gap)
+	}
+}
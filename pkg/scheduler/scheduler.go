@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"shopscraper/pkg/utils"
+	"sync"
+	"time"
+)
+
+// Job is run by the Scheduler every time its schedule is due. A job never
+// runs concurrently with itself, a run that takes longer than the schedule
+// delays the next one.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context)
+}
+
+// Scheduler runs jobs on their own schedules
+type Scheduler struct {
+	// Jitter is the maximum random delay added to every run, so jobs that are
+	// due at the same time don't all start at once
+	Jitter time.Duration
+	// RunOnStart runs every job once when the scheduler starts, before
+	// following its schedule
+	RunOnStart bool
+
+	jobs []Job
+}
+
+func New(jitter time.Duration, runOnStart bool) *Scheduler {
+	return &Scheduler{Jitter: jitter, RunOnStart: runOnStart}
+}
+
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Run runs the jobs until ctx is done and waits for the runs in progress
+// to finish
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			s.runJob(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job Job) {
+	next := time.Now()
+	if !s.RunOnStart {
+		next = job.Schedule.Next(next)
+	}
+
+	for !next.IsZero() {
+		delay := time.Until(next) + s.jitter()
+		log.Printf("Next run of %s at %s", job.Name, time.Now().Add(delay).Format(time.DateTime))
+		if !utils.SleepContext(ctx, delay) {
+			return
+		}
+
+		job.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		next = job.Schedule.Next(time.Now())
+	}
+}
+
+func (s *Scheduler) jitter() time.Duration {
+	if s.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(s.Jitter)
+}
+
+// Trigger runs a function after it has been fired, at most once per
+// MinInterval. Fires while the function is waiting or running are coalesced
+// into a single run.
+type Trigger struct {
+	MinInterval time.Duration
+
+	run   func(ctx context.Context)
+	fired chan struct{}
+}
+
+func NewTrigger(minInterval time.Duration, run func(ctx context.Context)) *Trigger {
+	return &Trigger{
+		MinInterval: minInterval,
+		run:         run,
+		fired:       make(chan struct{}, 1),
+	}
+}
+
+// Fire requests a run without waiting for it
+func (t *Trigger) Fire() {
+	select {
+	case t.fired <- struct{}{}:
+	default:
+		// A run is already pending
+	}
+}
+
+// Run waits for fires and runs the function until ctx is done
+func (t *Trigger) Run(ctx context.Context) {
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.fired:
+		}
+
+		if wait := time.Until(last.Add(t.MinInterval)); wait > 0 {
+			if !utils.SleepContext(ctx, wait) {
+				return
+			}
+		}
+		// Fires while waiting are handled by this run, only fires during the
+		// run need another one
+		select {
+		case <-t.fired:
+		default:
+		}
+		t.run(ctx)
+		last = time.Now()
+	}
+}
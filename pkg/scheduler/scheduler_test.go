@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var fast, slow atomic.Int32
+	s := New(0, true)
+	s.Add(Job{Name: "fast", Schedule: Every(10 * time.Millisecond), Run: func(ctx context.Context) { fast.Add(1) }})
+	s.Add(Job{Name: "slow", Schedule: Every(time.Hour), Run: func(ctx context.Context) { slow.Add(1) }})
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the scheduler to stop when the context was cancelled")
+	}
+
+	// Test case 1: Both jobs run on start, the fast job repeats on its own schedule
+	if fast.Load() < 3 {
+		t.Errorf("Expected the fast job to run at least 3 times, but it ran %d times", fast.Load())
+	}
+	if slow.Load() != 1 {
+		t.Errorf("Expected the slow job to run once, but it ran %d times", slow.Load())
+	}
+}
+
+func TestSchedulerRunWaitsForJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var finished atomic.Bool
+	s := New(0, true)
+	s.Add(Job{Name: "job", Schedule: Every(time.Hour), Run: func(ctx context.Context) {
+		cancel()
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+	}})
+
+	s.Run(ctx)
+	if !finished.Load() {
+		t.Errorf("Expected Run to wait for the job in progress")
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	s := New(time.Minute, false)
+	for i := 0; i < 100; i++ {
+		if jitter := s.jitter(); jitter < 0 || jitter >= time.Minute {
+			t.Fatalf("Expected jitter within [0, 1m), but got %s", jitter)
+		}
+	}
+	if jitter := New(0, false).jitter(); jitter != 0 {
+		t.Errorf("Expected no jitter, but got %s", jitter)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := make(chan time.Time, 10)
+	trigger := NewTrigger(50*time.Millisecond, func(ctx context.Context) { runs <- time.Now() })
+	go trigger.Run(ctx)
+
+	// Test case 1: Fires close together are coalesced into a run
+	trigger.Fire()
+	first := <-runs
+	trigger.Fire()
+	trigger.Fire()
+	trigger.Fire()
+
+	// Test case 2: The next run waits for the minimum interval
+	second := <-runs
+	if gap := second.Sub(first); gap < 50*time.Millisecond {
+		t.Errorf("Expected runs at least 50ms apart, but they were %s apart", gap)
+	}
+	select {
+	case <-runs:
+		t.Errorf("Expected the fires to be coalesced into a single run")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
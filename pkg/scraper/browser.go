@@ -0,0 +1,471 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"shopscraper/pkg/config"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	DefaultBrowserMaxTabs     = 4
+	DefaultBrowserIdleTimeout = 5 * time.Minute
+	// DefaultMaxWait limits loading a page and waiting for its wait
+	// conditions when no maxWait is configured
+	DefaultMaxWait = 30 * time.Second
+	// browserGracePeriod limits reading the page once it has loaded
+	browserGracePeriod = 10 * time.Second
+)
+
+// BrowserPool shares a headless browser between the JavaScript scrapers and
+// reuses its tabs, instead of starting a browser for every page. The browser
+// is started on first use and stopped after IdleTimeout without pages being
+// loaded.
+type BrowserPool struct {
+	MaxTabs     int
+	IdleTimeout time.Duration
+
+	mu         sync.Mutex
+	browserCtx context.Context
+	cancel     context.CancelFunc
+	idle       []*browserTab
+	inUse      int
+	idleTimer  *time.Timer
+	// starting is the browser being started, if any
+	starting *browserStart
+	// generation is incremented by Close, to stop browsers started before
+	generation int
+	// slots limits the number of open tabs to MaxTabs
+	slots chan struct{}
+}
+
+// browserTab is a tab of the pool's browser, loading one page at a time
+type browserTab struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	browserCtx context.Context
+}
+
+func NewBrowserPool(browserConfig config.BrowserConfig) *BrowserPool {
+	maxTabs := browserConfig.MaxTabs
+	if maxTabs <= 0 {
+		maxTabs = DefaultBrowserMaxTabs
+	}
+	idleTimeout := browserConfig.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultBrowserIdleTimeout
+	}
+	return &BrowserPool{
+		MaxTabs:     maxTabs,
+		IdleTimeout: idleTimeout,
+		slots:       make(chan struct{}, maxTabs),
+	}
+}
+
+// defaultBrowserPool is used by JavaScript scrapers created without shared
+// state
+var defaultBrowserPool = sync.OnceValue(func() *BrowserPool {
+	return NewBrowserPool(config.BrowserConfig{})
+})
+
+// acquire returns an idle tab, or opens a new one when there is none, waiting
+// while MaxTabs tabs are in use
+func (p *BrowserPool) acquire(ctx context.Context) (*browserTab, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	tab, err := p.take(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return tab, nil
+}
+
+// take returns a tab for a reserved slot. The browser and tabs are launched
+// without holding the lock, so other workers can reuse idle tabs meanwhile,
+// and waiting for them stops when ctx is done.
+func (p *BrowserPool) take(ctx context.Context) (*browserTab, error) {
+	p.mu.Lock()
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+		p.idleTimer = nil
+	}
+	// The slot counts as in use while launching, so the browser isn't stopped
+	// for being idle meanwhile
+	p.inUse++
+
+	// Start the browser, again if it has crashed or was stopped
+	for p.browserCtx == nil || p.browserCtx.Err() != nil {
+		start := p.starting
+		if start == nil {
+			start = p.startLocked()
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-start.done:
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.doneLocked()
+			p.mu.Unlock()
+			return nil, ctx.Err()
+		}
+
+		p.mu.Lock()
+		if start.err != nil {
+			p.doneLocked()
+			p.mu.Unlock()
+			return nil, start.err
+		}
+	}
+
+	if n := len(p.idle); n > 0 {
+		tab := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return tab, nil
+	}
+	browserCtx := p.browserCtx
+	p.mu.Unlock()
+
+	tab, err := openTab(ctx, browserCtx)
+	if err != nil {
+		p.mu.Lock()
+		p.doneLocked()
+		p.mu.Unlock()
+		return nil, err
+	}
+	return tab, nil
+}
+
+// openTab opens a tab in the browser of browserCtx, giving up when ctx is
+// done
+func openTab(ctx context.Context, browserCtx context.Context) (*browserTab, error) {
+	tabCtx, cancel := chromedp.NewContext(browserCtx)
+	stop := context.AfterFunc(ctx, cancel)
+	// The first run on a context opens its tab
+	err := chromedp.Run(tabCtx)
+	if !stop() {
+		cancel()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to open browser tab: %w", err)
+	}
+	return &browserTab{ctx: tabCtx, cancel: cancel, browserCtx: browserCtx}, nil
+}
+
+// browserStart is a browser being started, done is closed once it has
+// started or failed with err
+type browserStart struct {
+	done chan struct{}
+	err  error
+}
+
+// startLocked stops the browser and starts a new one in the background
+func (p *BrowserPool) startLocked() *browserStart {
+	p.closeLocked()
+
+	start := &browserStart{done: make(chan struct{})}
+	p.starting = start
+	generation := p.generation
+	go func() {
+		browserCtx, cancel, err := startBrowser()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if err == nil && generation != p.generation {
+			cancel()
+			err = errors.New("browser pool was closed while starting the browser")
+		}
+		if err == nil {
+			p.browserCtx, p.cancel = browserCtx, cancel
+			// Every worker waiting for it may have given up
+			p.scheduleIdleLocked()
+		}
+		start.err = err
+		p.starting = nil
+		close(start.done)
+	}()
+	return start
+}
+
+func startBrowser() (context.Context, context.CancelFunc, error) {
+	// The browser outlives the runs using it, so it isn't derived from their
+	// contexts
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), chromedp.DefaultExecAllocatorOptions[:]...)
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	cancel := func() {
+		cancelBrowser()
+		cancelAlloc()
+	}
+	// The first run on a context starts the browser
+	if err := chromedp.Run(browserCtx); err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("failed to start browser: %w", err)
+	}
+	return browserCtx, cancel, nil
+}
+
+// doneLocked frees the tab of a slot, stopping the browser after IdleTimeout
+// once no tabs are in use
+func (p *BrowserPool) doneLocked() {
+	p.inUse--
+	p.scheduleIdleLocked()
+}
+
+func (p *BrowserPool) scheduleIdleLocked() {
+	if p.inUse == 0 && p.browserCtx != nil {
+		if p.idleTimer != nil {
+			p.idleTimer.Stop()
+		}
+		p.idleTimer = time.AfterFunc(p.IdleTimeout, p.closeIdle)
+	}
+}
+
+// release returns a tab to the pool, or closes it when it can't be reused
+func (p *BrowserPool) release(tab *browserTab, reuse bool) {
+	p.mu.Lock()
+	if reuse && tab.browserCtx == p.browserCtx && tab.ctx.Err() == nil {
+		p.idle = append(p.idle, tab)
+	} else {
+		tab.cancel()
+	}
+	p.doneLocked()
+	p.mu.Unlock()
+
+	<-p.slots
+}
+
+func (p *BrowserPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inUse == 0 {
+		p.closeLocked()
+	}
+}
+
+// Close stops the browser. Pages being loaded fail, and the browser is
+// started again when a page is loaded afterwards.
+func (p *BrowserPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+		p.idleTimer = nil
+	}
+	// A browser being started is stopped once it has started
+	p.generation++
+	p.closeLocked()
+}
+
+func (p *BrowserPool) closeLocked() {
+	for _, tab := range p.idle {
+		tab.cancel()
+	}
+	p.idle = nil
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.browserCtx, p.cancel = nil, nil
+}
+
+// pageWait are the conditions a loaded page has to meet before it is read
+type pageWait struct {
+	// selector has to be visible
+	selector string
+	// networkIdle waits until the page has made no network requests for
+	// 500ms
+	networkIdle bool
+	// maxWait limits loading the page and waiting for the conditions
+	maxWait time.Duration
+}
+
+// newPageWait returns the wait conditions of the configuration. Without a
+// selector the page is read once the network is idle.
+func newPageWait(scraperConfig config.ScraperConfig) pageWait {
+	wait := pageWait{
+		selector:    scraperConfig.WaitSelector,
+		networkIdle: scraperConfig.NetworkIdle || scraperConfig.WaitSelector == "",
+		maxWait:     scraperConfig.MaxWait,
+	}
+	if wait.maxWait <= 0 {
+		wait.maxWait = DefaultMaxWait
+	}
+	return wait
+}
+
+// newDetailPageWait returns the wait conditions of the detail pages of the
+// configuration. Without a selector the page is read once the network is
+// idle, and without maxWait that of the list pages is used.
+func newDetailPageWait(scraperConfig config.ScraperConfig) pageWait {
+	detailConfig := scraperConfig.Detail
+	wait := pageWait{
+		selector:    detailConfig.WaitSelector,
+		networkIdle: detailConfig.NetworkIdle || detailConfig.WaitSelector == "",
+		maxWait:     detailConfig.MaxWait,
+	}
+	if wait.maxWait <= 0 {
+		wait.maxWait = newPageWait(scraperConfig).maxWait
+	}
+	return wait
+}
+
+// load navigates the tab to currentURL and returns its HTML once the wait
+// conditions are met and more items have been loaded. When the network
+// doesn't become idle within maxWait the page is read anyway, while a selector
+// that doesn't become visible fails with context.DeadlineExceeded.
+func (t *browserTab) load(ctx context.Context, currentURL string, wait pageWait, more pageLoadMore) (string, error) {
+	loadCtx, cancel := context.WithCancel(t.ctx)
+	defer cancel()
+	// Stop loading when the run is cancelled
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	waitCtx, cancelWait := context.WithTimeout(loadCtx, wait.maxWait)
+	defer cancelWait()
+
+	events := newLifecycleEvents()
+	chromedp.ListenTarget(loadCtx, events.handle)
+
+	err := chromedp.Run(waitCtx,
+		page.SetLifecycleEventsEnabled(true),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			loaderID, err := navigate(ctx, currentURL)
+			if err != nil {
+				return err
+			}
+			// A navigation within the same document doesn't load the page,
+			// so load it from a blank page instead
+			if loaderID == "" {
+				if _, err := navigate(ctx, "about:blank"); err != nil {
+					return err
+				}
+				if loaderID, err = navigate(ctx, currentURL); err != nil {
+					return err
+				}
+			}
+			if err := events.wait(ctx, loaderID, "load"); err != nil {
+				return err
+			}
+			if wait.networkIdle {
+				if err := events.wait(ctx, loaderID, "networkIdle"); err != nil {
+					if loadCtx.Err() != nil {
+						return err
+					}
+					log.Printf("Network of %s not idle after %s, reading the page anyway", currentURL, wait.maxWait)
+				}
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if wait.selector != "" {
+		if err := chromedp.Run(waitCtx, chromedp.WaitVisible(wait.selector, chromedp.ByQuery)); err != nil {
+			return "", fmt.Errorf("waiting for '%s': %w", wait.selector, err)
+		}
+	}
+
+	if err := more.load(loadCtx, currentURL); err != nil {
+		return "", err
+	}
+
+	readCtx, cancelRead := context.WithTimeout(loadCtx, browserGracePeriod)
+	defer cancelRead()
+	var htmlContent string
+	if err := chromedp.Run(readCtx, chromedp.OuterHTML("html", &htmlContent, chromedp.ByQuery)); err != nil {
+		return "", err
+	}
+	return htmlContent, nil
+}
+
+// navigate starts loading rawURL in the tab of ctx and returns the loader of
+// the new document, which is empty for a navigation within the same document
+func navigate(ctx context.Context, rawURL string) (cdp.LoaderID, error) {
+	_, loaderID, errorText, err := page.Navigate(rawURL).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	if errorText != "" {
+		return "", fmt.Errorf("failed to load %s: %s", rawURL, errorText)
+	}
+	return loaderID, nil
+}
+
+// lifecycleEvents records the lifecycle events of the documents loaded in a
+// tab, so they can be waited for even when they arrive before the navigation
+// returns
+type lifecycleEvents struct {
+	mu      sync.Mutex
+	seen    map[lifecycleEvent]bool
+	changed chan struct{}
+}
+
+type lifecycleEvent struct {
+	loaderID cdp.LoaderID
+	name     string
+}
+
+func newLifecycleEvents() *lifecycleEvents {
+	return &lifecycleEvents{
+		seen:    make(map[lifecycleEvent]bool),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (e *lifecycleEvents) handle(ev interface{}) {
+	event, ok := ev.(*page.EventLifecycleEvent)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	e.seen[lifecycleEvent{loaderID: event.LoaderID, name: event.Name}] = true
+	e.mu.Unlock()
+
+	select {
+	case e.changed <- struct{}{}:
+	default:
+	}
+}
+
+// wait waits until the lifecycle event name of the document of loaderID has
+// been seen
+func (e *lifecycleEvents) wait(ctx context.Context, loaderID cdp.LoaderID, name string) error {
+	for {
+		e.mu.Lock()
+		seen := e.seen[lifecycleEvent{loaderID: loaderID, name: name}]
+		e.mu.Unlock()
+		if seen {
+			return nil
+		}
+
+		select {
+		case <-e.changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// browserPool returns the browser pool of the scraper's shared state
+func (bs *BaseScraper) browserPool() *BrowserPool {
+	if bs.Shared == nil || bs.Shared.Browser == nil {
+		return defaultBrowserPool()
+	}
+	return bs.Shared.Browser
+}
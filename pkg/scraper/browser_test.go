@@ -0,0 +1,143 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"shopscraper/pkg/config"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPageWait(t *testing.T) {
+	// Test case 1: Without a selector the page is read once the network is idle
+	wait := newPageWait(config.ScraperConfig{})
+	assert.Equal(t, pageWait{networkIdle: true, maxWait: DefaultMaxWait}, wait)
+
+	// Test case 2: With a selector the network is only waited for when configured
+	wait = newPageWait(config.ScraperConfig{WaitSelector: ".product", MaxWait: 10 * time.Second})
+	assert.Equal(t, pageWait{selector: ".product", maxWait: 10 * time.Second}, wait)
+
+	wait = newPageWait(config.ScraperConfig{WaitSelector: ".product", NetworkIdle: true})
+	assert.Equal(t, pageWait{selector: ".product", networkIdle: true, maxWait: DefaultMaxWait}, wait)
+}
+
+func TestNewDetailPageWait(t *testing.T) {
+	// Test case 1: The wait conditions of the list pages are not used
+	cfg := config.ScraperConfig{WaitSelector: ".product", MaxWait: 10 * time.Second, Detail: &config.DetailConfig{}}
+	assert.Equal(t, pageWait{networkIdle: true, maxWait: 10 * time.Second}, newDetailPageWait(cfg))
+
+	// Test case 2: The wait conditions of the detail configuration are used
+	cfg.Detail = &config.DetailConfig{WaitSelector: ".sku", MaxWait: 5 * time.Second}
+	assert.Equal(t, pageWait{selector: ".sku", maxWait: 5 * time.Second}, newDetailPageWait(cfg))
+}
+
+func TestLifecycleEventsWait(t *testing.T) {
+	events := newLifecycleEvents()
+
+	// Test case 1: Events seen before waiting are found
+	events.handle(&page.EventLifecycleEvent{LoaderID: "loader1", Name: "load"})
+	if err := events.wait(context.Background(), "loader1", "load"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Test case 2: Events of other documents are ignored
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	events.handle(&page.EventLifecycleEvent{LoaderID: "loader2", Name: "networkIdle"})
+	if err := events.wait(ctx, "loader1", "networkIdle"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, but got %v", err)
+	}
+
+	// Test case 3: Events arriving while waiting end the wait
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		events.handle(&page.EventLifecycleEvent{LoaderID: "loader1", Name: "networkIdle"})
+	}()
+	if err := events.wait(context.Background(), "loader1", "networkIdle"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestBrowserPoolLoad(t *testing.T) {
+	if !chromeInstalled() {
+		t.Skip("no Chrome or Chromium installed")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><script>
+			setTimeout(() => { document.body.innerHTML = '<div class="product">Rendered</div>' }, 300)
+		</script></body></html>`))
+	}))
+	defer server.Close()
+
+	pool := NewBrowserPool(config.BrowserConfig{MaxTabs: 1})
+	defer pool.Close()
+	js := NewJavaScriptWebShopScraper(config.ScraperConfig{WaitSelector: ".product", MaxWait: 10 * time.Second}, &Shared{Browser: pool})
+
+	// The rendered page is read, and the tab is reused for the next page
+	for i := 0; i < 2; i++ {
+		html, err := js.GetHTML(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		assert.Contains(t, html, "Rendered")
+		assert.Equal(t, 1, len(pool.idle), "Expected the tab to be kept for reuse")
+	}
+}
+
+func TestBrowserPoolAcquireWhileStarting(t *testing.T) {
+	pool := NewBrowserPool(config.BrowserConfig{MaxTabs: 1})
+	// A browser that takes until the end of the test to start
+	pool.starting = &browserStart{done: make(chan struct{})}
+
+	// Test case 1: Waiting for the browser to start stops with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := pool.acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, pool.inUse, "Expected the tab to be freed")
+	assert.Equal(t, 0, len(pool.slots), "Expected the slot to be freed")
+
+	// Test case 2: The lock isn't held while waiting
+	waiting := make(chan error)
+	go func() {
+		_, err := pool.acquire(context.Background())
+		waiting <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		pool.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Close was blocked by a worker waiting for the browser")
+	}
+
+	// Test case 3: Workers waiting for the browser get its error
+	pool.mu.Lock()
+	pool.starting.err = errors.New("failed to start browser")
+	close(pool.starting.done)
+	pool.starting = nil
+	pool.mu.Unlock()
+	assert.EqualError(t, <-waiting, "failed to start browser")
+	assert.Equal(t, 0, pool.inUse)
+}
+
+// chromeInstalled reports whether a browser chromedp can start is installed
+func chromeInstalled() bool {
+	for _, name := range []string{"headless-shell", "chromium", "chromium-browser", "google-chrome"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,361 @@
+package scraper
+
+import (
+	"context"
+	"log"
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/models"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// DefaultDetailCacheDuration is how long product details are reused when no
+// cacheDuration is configured
+const DefaultDetailCacheDuration = 24 * time.Hour
+
+// productDetail holds the fields found on a product's detail page
+type productDetail struct {
+	price        *Price
+	sku          string
+	availability string
+	brand        string
+	image        string
+	description  string
+}
+
+// apply returns the product with the fields found on its detail page
+func (d productDetail) apply(product models.Product) models.Product {
+	if d.price != nil {
+		product.Price = d.price.Amount
+		product.Currency = d.price.Currency
+	}
+	if d.sku != "" {
+		product.SKU = d.sku
+	}
+	if d.availability != "" {
+		product.Availability = d.availability
+	}
+	if d.brand != "" {
+		product.Brand = d.brand
+	}
+	if d.image != "" {
+		product.Image = d.image
+	}
+	if d.description != "" {
+		product.Description = d.description
+	}
+	return product
+}
+
+// DetailCache keeps the details of products, so the detail page of a product
+// that is unchanged on its list page isn't fetched again. It is filled with
+// the details stored in the database by Load, and kept up to date by the runs
+// sharing it.
+type DetailCache struct {
+	mu      sync.Mutex
+	entries map[string]detailEntry
+	now     func() time.Time
+}
+
+type detailEntry struct {
+	// name and price are those of the list page the details were fetched for
+	name     string
+	price    int
+	currency string
+	detail   productDetail
+	fetched  time.Time
+}
+
+func NewDetailCache() *DetailCache {
+	return &DetailCache{entries: make(map[string]detailEntry), now: time.Now}
+}
+
+func detailKey(product models.Product) string {
+	return product.Shop + "\n" + product.Link
+}
+
+// apply returns the product with the cached details and the time they were
+// fetched
+func (e detailEntry) apply(product models.Product) models.Product {
+	product = e.detail.apply(product)
+	product.DetailsFetched = e.fetched
+	return product
+}
+
+// Load adds the details stored with the products of the scrapers with a
+// detail configuration, unless the cache has newer ones. Only the fields the
+// detail configuration extracts are taken from the stored product, and the
+// stored price takes the place of the price on the list page, so the details
+// of a product whose detail page changes its price are fetched again.
+func (c *DetailCache) Load(scraperConfigs []config.ScraperConfig, products []models.Product) {
+	detailConfigs := make(map[string]*config.DetailConfig)
+	for _, scraperConfig := range scraperConfigs {
+		if scraperConfig.Detail != nil {
+			detailConfigs[scraperConfig.ShopName] = scraperConfig.Detail
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, product := range products {
+		detailConfig, ok := detailConfigs[product.Shop]
+		if !ok || product.DetailsFetched.IsZero() {
+			continue
+		}
+		key := detailKey(product)
+		if entry, ok := c.entries[key]; ok && !entry.fetched.Before(product.DetailsFetched) {
+			continue
+		}
+		c.entries[key] = detailEntry{
+			name:     product.Name,
+			price:    product.Price,
+			currency: product.CurrencyCode(),
+			detail:   storedDetail(detailConfig, product),
+			fetched:  product.DetailsFetched,
+		}
+	}
+}
+
+// storedDetail returns the fields of the stored product the detail
+// configuration extracts
+func storedDetail(detailConfig *config.DetailConfig, product models.Product) productDetail {
+	structured := detailConfig.ExtractionMode == ExtractionModeStructured
+	var detail productDetail
+	if structured || detailConfig.SKUSelector != "" {
+		detail.sku = product.SKU
+	}
+	if structured || detailConfig.AvailabilitySelector != "" {
+		detail.availability = product.Availability
+	}
+	if structured || detailConfig.BrandSelector != "" {
+		detail.brand = product.Brand
+	}
+	if structured || detailConfig.ImageSelector != "" {
+		detail.image = product.Image
+	}
+	if structured || detailConfig.DescriptionSelector != "" {
+		detail.description = product.Description
+	}
+	return detail
+}
+
+// get returns the cached details of the product as found on its list page,
+// unless it changed since or they are older than maxAge
+func (c *DetailCache) get(product models.Product, maxAge time.Duration) (detailEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[detailKey(product)]
+	if !ok || entry.name != product.Name || entry.price != product.Price || entry.currency != product.CurrencyCode() {
+		return detailEntry{}, false
+	}
+	if c.now().Sub(entry.fetched) > maxAge {
+		return detailEntry{}, false
+	}
+	return entry, true
+}
+
+// stale returns the last details of the product however old they are or
+// whether it changed on its list page since
+func (c *DetailCache) stale(product models.Product) (detailEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[detailKey(product)]
+	return entry, ok
+}
+
+func (c *DetailCache) put(product models.Product, detail productDetail) detailEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := detailEntry{
+		name:     product.Name,
+		price:    product.Price,
+		currency: product.CurrencyCode(),
+		detail:   detail,
+		fetched:  c.now(),
+	}
+	c.entries[detailKey(product)] = entry
+	return entry
+}
+
+// prune removes the expired details of the products of shop that are no
+// longer listed. The details of listed products are kept to fall back on when
+// their detail page fails.
+func (c *DetailCache) prune(shop string, maxAge time.Duration, listed []models.Product) {
+	keep := make(map[string]bool, len(listed))
+	for _, product := range listed {
+		keep[detailKey(product)] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if strings.HasPrefix(key, shop+"\n") && !keep[key] && c.now().Sub(entry.fetched) > maxAge {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// detailCache returns the shared detail cache, or the scraper's own when it
+// has no shared state
+func (bs *BaseScraper) detailCache() *DetailCache {
+	if bs.Shared != nil && bs.Shared.Details != nil {
+		return bs.Shared.Details
+	}
+	bs.detailsOnce.Do(func() {
+		bs.details = NewDetailCache()
+	})
+	return bs.details
+}
+
+// addDetails follows the link of every product to its detail page and adds
+// the fields found there. The detail pages are fetched concurrently, each
+// taking a worker of semaphore. Products whose detail page is disallowed by
+// robots.txt are kept as found on the list page. When a detail page fails,
+// the last details of the product are used, and products without any are
+// kept as found on the list page.
+func (bs *BaseScraper) addDetails(ctx context.Context, products []models.Product, semaphore workers) []models.Product {
+	cache := bs.detailCache()
+	maxAge := bs.Config.Detail.CacheDuration
+	if maxAge <= 0 {
+		maxAge = DefaultDetailCacheDuration
+	}
+
+	detailed := make([]models.Product, len(products))
+	var wg sync.WaitGroup
+	for i, product := range products {
+		if entry, ok := cache.get(product, maxAge); ok {
+			// The details may change the fields identifying the product
+			detailed[i] = bs.identify(entry.apply(product))
+			continue
+		}
+
+		detailed[i] = product
+		wg.Add(1)
+		go func(i int, product models.Product) {
+			defer wg.Done()
+			if !semaphore.acquire(ctx) {
+				return
+			}
+			defer semaphore.release()
+			if !bs.robotsAllowed(ctx, product.Link) {
+				return
+			}
+
+			var entry detailEntry
+			if detail, ok := bs.fetchDetail(ctx, product); ok {
+				entry = cache.put(product, detail)
+			} else if entry, ok = cache.stale(product); ok {
+				log.Println("Using the previous details of", product.Link)
+			} else {
+				log.Println("Keeping", product.Link, "as found on the list page")
+				return
+			}
+			detailed[i] = bs.identify(entry.apply(product))
+		}(i, product)
+	}
+	wg.Wait()
+	cache.prune(bs.Config.ShopName, maxAge, products)
+	return detailed
+}
+
+// fetchDetail fetches and parses the detail page of the product
+func (bs *BaseScraper) fetchDetail(ctx context.Context, product models.Product) (productDetail, bool) {
+	htmlContent, err := bs.getDetailHTML(ctx, product.Link)
+	if err != nil {
+		log.Println("Error scraping details from", product.Link, ":", err)
+		return productDetail{}, false
+	}
+	detail, err := bs.parseDetail(htmlContent, product.Link)
+	if err != nil {
+		log.Println("Error parsing details from", product.Link, ":", err)
+		return productDetail{}, false
+	}
+	return detail, true
+}
+
+// getDetailHTML fetches the detail page at currentURL like getHTML, with the
+// DetailHTMLGetter of the scraper when it has one
+func (bs *BaseScraper) getDetailHTML(ctx context.Context, currentURL string) (string, error) {
+	getter, ok := bs.HTMLGetter.(DetailHTMLGetter)
+	if !ok {
+		return bs.getHTML(ctx, currentURL)
+	}
+	return bs.retryPolicy().Do(ctx, currentURL, func(ctx context.Context) (string, error) {
+		return getter.GetDetailHTML(ctx, currentURL)
+	})
+}
+
+// parseDetail extracts the fields of the detail configuration from a detail
+// page
+func (bs *BaseScraper) parseDetail(htmlContent, fetchedUrl string) (productDetail, error) {
+	detailConfig := bs.Config.Detail
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return productDetail{}, err
+	}
+
+	var detail productDetail
+	if detailConfig.ExtractionMode == ExtractionModeStructured {
+		if products := bs.parseStructuredData(doc, fetchedUrl); len(products) > 0 {
+			p := products[0]
+			if p.Price != 0 {
+				detail.price = &Price{Amount: p.Price, Currency: p.Currency}
+			}
+			detail.sku = p.SKU
+			detail.availability = p.Availability
+			detail.brand = p.Brand
+			detail.image = p.Image
+			detail.description = p.Description
+		}
+	}
+
+	if len(detailConfig.PriceSelector) > 0 {
+		price, err := bs.priceFrom(doc.Selection, detailConfig.PriceSelector)
+		if err != nil {
+			log.Printf("Failed to get price from %s: %v", fetchedUrl, err)
+		} else {
+			detail.price = &price
+		}
+	}
+	if detailConfig.SKUSelector != "" {
+		if sku := selectorValue(doc.Find(detailConfig.SKUSelector), detailConfig.SKUAttribute); sku != "" {
+			detail.sku = sku
+		}
+	}
+	if detailConfig.AvailabilitySelector != "" {
+		if availability := selectorValue(doc.Find(detailConfig.AvailabilitySelector), detailConfig.AvailabilityAttribute); availability != "" {
+			detail.availability = normalizeAvailability(availability)
+		}
+	}
+	if detailConfig.BrandSelector != "" {
+		if brand := selectorValue(doc.Find(detailConfig.BrandSelector), detailConfig.BrandAttribute); brand != "" {
+			detail.brand = brand
+		}
+	}
+	if detailConfig.ImageSelector != "" {
+		if image := imageURL(doc.Selection, detailConfig.ImageSelector, detailConfig.ImageAttribute, fetchedUrl); image != "" {
+			detail.image = image
+		}
+	}
+	if detailConfig.DescriptionSelector != "" {
+		if description := selectorValue(doc.Find(detailConfig.DescriptionSelector), detailConfig.DescriptionAttribute); description != "" {
+			detail.description = collapseSpace(description)
+		}
+	}
+	return detail, nil
+}
+
+// collapseSpace replaces every run of whitespace with a single space, as
+// descriptions are usually spread over several indented lines
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// validDetail reports whether the detail configuration can be used with the
+// scraper type, as detail pages are parsed as HTML
+func validDetail(scraperConfig config.ScraperConfig) bool {
+	return scraperConfig.Detail == nil || scraperConfig.Type != "JSONAPIScraper"
+}
@@ -0,0 +1,328 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrapeDetails(t *testing.T) {
+	var mu sync.Mutex
+	listPrice := "10,00 €"
+	failing := false
+	fetches := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches[r.URL.Path]++
+		switch r.URL.Path {
+		case "/list":
+			w.Write([]byte(`<div class="item"><div class="name">Product 1</div><div class="price">` + listPrice + `</div><a class="link" href="/product1">Link</a></div>
+				<div class="item"><div class="name">Product 2</div><div class="price">20,00 €</div><a class="link" href="/product2">Link</a></div>`))
+		case "/product1":
+			if failing {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`<div class="price">9,50 €</div><div class="stock" data-state="InStock">Available</div><span class="sku">SKU-1</span>
+				<div class="description">
+					A fine
+					product
+				</div>`))
+		case "/product2":
+			w.Write([]byte(`<script type="application/ld+json">{"@type": "Product", "name": "Product 2", "sku": "SKU-2", "description": "Another product",
+				"offers": {"@type": "Offer", "price": "19.00", "priceCurrency": "EUR", "availability": "https://schema.org/OutOfStock"}}</script>`))
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.ScraperConfig{
+		URLs:          []string{server.URL + "/list"},
+		ItemSelector:  ".item",
+		NameSelector:  ".name",
+		LinkSelector:  ".link",
+		PriceSelector: []string{".price"},
+		ShopName:      "Test Shop",
+		Identity:      models.IdentitySKU,
+		Detail: &config.DetailConfig{
+			ExtractionMode:        ExtractionModeStructured,
+			PriceSelector:         []string{".price"},
+			SKUSelector:           ".sku",
+			AvailabilitySelector:  ".stock",
+			AvailabilityAttribute: "data-state",
+			DescriptionSelector:   ".description",
+		},
+	}
+	ws := NewWebShopScraper(cfg, NewShared(config.ProgramConfig{}))
+
+	// Test case 1: The fields of the detail pages are added to the products
+	products, err := ws.Scrape(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Len(t, products, 2) {
+		assert.Equal(t, 950, products[0].Price)
+		assert.Equal(t, "SKU-1", products[0].SKU)
+		assert.Equal(t, "InStock", products[0].Availability)
+		assert.Equal(t, "sku:SKU-1", products[0].IdentityKey, "Expected the identity to use the SKU of the detail page")
+		assert.Equal(t, "A fine product", products[0].Description)
+
+		assert.Equal(t, 1900, products[1].Price)
+		assert.Equal(t, "SKU-2", products[1].SKU)
+		assert.Equal(t, "OutOfStock", products[1].Availability)
+		assert.Equal(t, "Another product", products[1].Description, "Expected the description of the structured data")
+	}
+
+	// Test case 2: Detail pages of products unchanged on the list page are
+	// not fetched again, those of changed products are
+	listPrice = "11,00 €"
+	products, err = ws.Scrape(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Len(t, products, 2)
+	assert.Equal(t, 2, fetches["/product1"])
+	assert.Equal(t, 1, fetches["/product2"])
+	assert.Equal(t, 1900, products[1].Price, "Expected the cached details to be used")
+
+	// Test case 3: A failing detail page falls back on the previous details,
+	// keeping the identity of the product
+	listPrice = "12,00 €"
+	failing = true
+	products, err = ws.Scrape(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Len(t, products, 2) {
+		assert.Equal(t, "sku:SKU-1", products[0].IdentityKey, "Expected the previous details to be used")
+		assert.Equal(t, 950, products[0].Price)
+	}
+
+	// Test case 4: Products whose detail page fails without previous details
+	// are kept as found on the list page
+	ws = NewWebShopScraper(cfg, NewShared(config.ProgramConfig{}))
+	products, err = ws.Scrape(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Len(t, products, 2) {
+		assert.Equal(t, "Product 1", products[0].Name)
+		assert.Equal(t, 1200, products[0].Price, "Expected the price of the list page")
+		assert.Empty(t, products[0].SKU)
+		assert.Equal(t, "SKU-2", products[1].SKU)
+	}
+}
+
+func TestScrapeDetailsWorkers(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		if r.URL.Path == "/list" {
+			for i := 0; i < 6; i++ {
+				fmt.Fprintf(w, `<div class="item"><div class="name">Product %d</div><div class="price">10,00 €</div><a class="link" href="/product%d">Link</a></div>`, i, i)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprintf(w, `<span class="sku">SKU%s</span>`, strings.TrimPrefix(r.URL.Path, "/product"))
+	}))
+	defer server.Close()
+
+	cfg := config.ScraperConfig{
+		URLs:          []string{server.URL + "/list"},
+		ItemSelector:  ".item",
+		NameSelector:  ".name",
+		LinkSelector:  ".link",
+		PriceSelector: []string{".price"},
+		ShopName:      "Test Shop",
+		Detail:        &config.DetailConfig{SKUSelector: ".sku"},
+	}
+	ws := NewWebShopScraper(cfg, nil)
+
+	// The detail pages are fetched concurrently, by no more than maxWorkers
+	products, err := ws.Scrape(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Len(t, products, 6) {
+		for i, product := range products {
+			assert.Equal(t, fmt.Sprintf("SKU%d", i), product.SKU, "Expected the products in the order of the list page")
+		}
+	}
+	assert.Equal(t, 2, maxRunning)
+}
+
+// detailHTMLGetter serves a list page linking to a detail page, recording
+// whether each page was fetched as a detail page
+type detailHTMLGetter struct {
+	mu       sync.Mutex
+	fetches  map[string]string
+	listHTML string
+}
+
+func (g *detailHTMLGetter) GetHTML(ctx context.Context, currentURL string) (string, error) {
+	g.record(currentURL, "list")
+	return g.listHTML, nil
+}
+
+func (g *detailHTMLGetter) GetDetailHTML(ctx context.Context, currentURL string) (string, error) {
+	g.record(currentURL, "detail")
+	return `<span class="sku">SKU-1</span>`, nil
+}
+
+func (g *detailHTMLGetter) record(currentURL, kind string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.fetches[currentURL] = kind
+}
+
+func TestScrapeDetailsWithDetailHTMLGetter(t *testing.T) {
+	getter := &detailHTMLGetter{
+		fetches:  map[string]string{},
+		listHTML: `<div class="item"><div class="name">Product 1</div><div class="price">10,00 €</div><a class="link" href="https://example.com/product1">Link</a></div>`,
+	}
+	cfg := config.ScraperConfig{
+		URLs:          []string{"https://example.com/list"},
+		ItemSelector:  ".item",
+		NameSelector:  ".name",
+		LinkSelector:  ".link",
+		PriceSelector: []string{".price"},
+		ShopName:      "Test Shop",
+		Detail:        &config.DetailConfig{SKUSelector: ".sku"},
+	}
+	bs := &BaseScraper{Config: cfg, HTMLGetter: getter}
+
+	products, err := bs.Scrape(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Len(t, products, 1) {
+		assert.Equal(t, "SKU-1", products[0].SKU)
+	}
+	assert.Equal(t, map[string]string{"https://example.com/list": "list", "https://example.com/product1": "detail"}, getter.fetches)
+}
+
+func TestScrapeJavaScriptDetails(t *testing.T) {
+	if !chromeInstalled() {
+		t.Skip("no Chrome or Chromium installed")
+	}
+
+	// The list is rendered with a load more button, the detail page only
+	// renders its SKU
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list":
+			w.Write([]byte(`<html><body><div id="items"></div><button class="more">Load more</button><script>
+				setTimeout(() => {
+					document.getElementById('items').innerHTML = '<div class="item"><div class="name">Product 1</div><div class="price">10,00 €</div><a class="link" href="/product1">Link</a></div>';
+					document.querySelector('.more').remove();
+				}, 100)
+			</script></body></html>`))
+		case "/product1":
+			w.Write([]byte(`<html><body><script>
+				setTimeout(() => { document.body.innerHTML = '<span class="sku">SKU-1</span>' }, 100)
+			</script></body></html>`))
+		}
+	}))
+	defer server.Close()
+
+	pool := NewBrowserPool(config.BrowserConfig{})
+	defer pool.Close()
+	cfg := config.ScraperConfig{
+		URLs:             []string{server.URL + "/list"},
+		ItemSelector:     ".item",
+		NameSelector:     ".name",
+		LinkSelector:     ".link",
+		PriceSelector:    []string{".price"},
+		ShopName:         "Test Shop",
+		WaitSelector:     ".item",
+		LoadMoreSelector: "button.more",
+		MaxWait:          5 * time.Second,
+		Retry:            config.RetryConfig{MaxAttempts: 1},
+		Detail:           &config.DetailConfig{SKUSelector: ".sku", WaitSelector: ".sku"},
+	}
+	js := NewJavaScriptWebShopScraper(cfg, &Shared{Browser: pool})
+
+	// The detail page is read without waiting for the list's elements
+	products, err := js.Scrape(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Len(t, products, 1) {
+		assert.Equal(t, "SKU-1", products[0].SKU)
+		assert.True(t, strings.HasSuffix(products[0].Link, "/product1"))
+	}
+}
+
+func TestDetailCacheExpiry(t *testing.T) {
+	now := time.Now()
+	c := NewDetailCache()
+	c.now = func() time.Time { return now }
+	product := models.Product{Shop: "Shop", Name: "Product", Link: "https://example.com/p", Price: 100}
+
+	c.put(product, productDetail{sku: "SKU"})
+	if _, ok := c.get(product, time.Hour); !ok {
+		t.Errorf("Expected the details to be cached")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := c.get(product, time.Hour); ok {
+		t.Errorf("Expected the details to have expired")
+	}
+	c.prune("Shop", time.Hour, []models.Product{product})
+	assert.NotEmpty(t, c.entries, "Expected the details of listed products to be kept")
+	c.prune("Shop", time.Hour, nil)
+	assert.Empty(t, c.entries)
+}
+
+func TestDetailCacheLoad(t *testing.T) {
+	now := time.Now()
+	c := NewDetailCache()
+	c.now = func() time.Time { return now }
+	scraperConfigs := []config.ScraperConfig{{ShopName: "Shop", Detail: &config.DetailConfig{SKUSelector: ".sku"}}}
+	stored := models.Product{Shop: "Shop", Name: "Product", Link: "https://example.com/p", Price: 100, Currency: "EUR", SKU: "SKU", Brand: "Brand", DetailsFetched: now.Add(-time.Hour)}
+
+	// Test case 1: Only the fields of the detail configuration are loaded
+	c.Load(scraperConfigs, []models.Product{stored})
+	entry, ok := c.get(models.Product{Shop: "Shop", Name: "Product", Link: "https://example.com/p", Price: 100, Currency: "EUR"}, 24*time.Hour)
+	if assert.True(t, ok, "Expected the stored details to be cached") {
+		assert.Equal(t, productDetail{sku: "SKU"}, entry.detail)
+		assert.Equal(t, stored.DetailsFetched, entry.fetched)
+	}
+
+	// Test case 2: Newer details in the cache are kept
+	c.put(stored, productDetail{sku: "NEW"})
+	c.Load(scraperConfigs, []models.Product{stored})
+	entry, _ = c.stale(stored)
+	assert.Equal(t, "NEW", entry.detail.sku)
+
+	// Test case 3: Products without details or detail configuration are skipped
+	c = NewDetailCache()
+	c.Load(scraperConfigs, []models.Product{{Shop: "Shop", Link: "https://example.com/q"}})
+	c.Load(nil, []models.Product{stored})
+	assert.Empty(t, c.entries)
+}
+
+func TestCreateScrapersDetail(t *testing.T) {
+	_, err := CreateScrapers([]config.ScraperConfig{{Type: "JSONAPIScraper", ShopName: "Shop", Detail: &config.DetailConfig{}}}, nil)
+	assert.ErrorContains(t, err, "detail is not supported by JSONAPIScraper")
+}
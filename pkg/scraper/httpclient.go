@@ -0,0 +1,242 @@
+package scraper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"shopscraper/pkg/config"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultUserAgent is sent when no user agent is configured, as several
+	// shops reject Go's default user agent
+	DefaultUserAgent = "Mozilla/5.0 (compatible; shopscraper/1.0; +https://github.com/pppontusw/shopscraper)"
+	// DefaultHTTPTimeout limits a request when no timeout is configured
+	DefaultHTTPTimeout = 30 * time.Second
+)
+
+// Transports shares HTTP transports between scrapers, one per combination of
+// proxy and TLS options, so connections to a host are reused across scrapers
+type Transports struct {
+	mu         sync.Mutex
+	transports map[transportKey]*http.Transport
+}
+
+type transportKey struct {
+	proxy              string
+	insecureSkipVerify bool
+	caFile             string
+	minVersion         string
+}
+
+func NewTransports() *Transports {
+	return &Transports{transports: make(map[transportKey]*http.Transport)}
+}
+
+func (t *Transports) get(httpConfig config.HTTPConfig) (*http.Transport, error) {
+	key := transportKey{
+		proxy:              httpConfig.Proxy,
+		insecureSkipVerify: config.Enabled(httpConfig.TLS.InsecureSkipVerify),
+		caFile:             httpConfig.TLS.CAFile,
+		minVersion:         httpConfig.TLS.MinVersion,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if transport, ok := t.transports[key]; ok {
+		return transport, nil
+	}
+	transport, err := newTransport(httpConfig)
+	if err != nil {
+		return nil, err
+	}
+	t.transports[key] = transport
+	return transport, nil
+}
+
+func newTransport(httpConfig config.HTTPConfig) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if httpConfig.Proxy != "" {
+		proxyURL, err := url.Parse(httpConfig.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy '%s': %w", httpConfig.Proxy, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid proxy '%s': scheme must be http, https or socks5", httpConfig.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := newTLSConfig(httpConfig.TLS)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func newTLSConfig(tlsOptions config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.Enabled(tlsOptions.InsecureSkipVerify),
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	switch tlsOptions.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid TLS minVersion '%s', expected 1.2 or 1.3", tlsOptions.MinVersion)
+	}
+
+	if tlsOptions.CAFile != "" {
+		pem, err := os.ReadFile(tlsOptions.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS caFile: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS caFile '%s'", tlsOptions.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// newHTTPClient creates a client using the shared transport for the proxy and
+// TLS options of httpConfig, with its own cookie jar when cookies are kept
+func newHTTPClient(httpConfig config.HTTPConfig, transports *Transports) (*http.Client, error) {
+	transport, err := transports.get(httpConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   httpConfig.Timeout,
+	}
+	if client.Timeout == 0 {
+		client.Timeout = DefaultHTTPTimeout
+	}
+	if config.Enabled(httpConfig.Cookies) {
+		// Without a public suffix list cookies are only sent back to the host
+		// that set them, which is all a scraper needs
+		client.Jar, err = cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// httpConfig returns the HTTP configuration of the scraper merged with the
+// global one
+func (bs *BaseScraper) httpConfig() config.HTTPConfig {
+	if bs.Shared == nil {
+		return bs.Config.HTTP
+	}
+	return bs.Config.HTTP.Merge(bs.Shared.HTTP)
+}
+
+// httpClient returns the client of the scraper, created on first use
+func (bs *BaseScraper) httpClient() (*http.Client, error) {
+	bs.clientOnce.Do(func() {
+		transports := NewTransports()
+		if bs.Shared != nil && bs.Shared.Transports != nil {
+			transports = bs.Shared.Transports
+		}
+		bs.client, bs.clientErr = newHTTPClient(bs.httpConfig(), transports)
+	})
+	return bs.client, bs.clientErr
+}
+
+// fetch requests currentURL with the configured headers once the rate limit
+// allows it and returns the body of an accepted response. accept is sent as
+// the Accept header unless the headers configure one.
+func (bs *BaseScraper) fetch(ctx context.Context, currentURL, accept string) (string, error) {
+	client, err := bs.httpClient()
+	if err != nil {
+		return "", err
+	}
+	if err := bs.waitForHost(ctx, currentURL); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, currentURL, nil)
+	if err != nil {
+		return "", err
+	}
+	httpConfig := bs.httpConfig()
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	if httpConfig.UserAgent != "" {
+		req.Header.Set("User-Agent", httpConfig.UserAgent)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	for name, value := range httpConfig.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		return "", &RetryableError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if !statusAccepted(resp.StatusCode, httpConfig.AcceptedStatusCodes) {
+		err := fmt.Errorf("failed to fetch URL %s: status code %d", currentURL, resp.StatusCode)
+		if retryStatus(resp.StatusCode) {
+			return "", &RetryableError{Err: err, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+		}
+		return "", err
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		return "", &RetryableError{Err: err}
+	}
+
+	return string(content), nil
+}
+
+func statusAccepted(statusCode int, accepted []int) bool {
+	if len(accepted) == 0 {
+		return statusCode == http.StatusOK
+	}
+	return slices.Contains(accepted, statusCode)
+}
@@ -0,0 +1,199 @@
+package scraper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"shopscraper/pkg/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchHeaders(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	// Test case 1: The default user agent is sent instead of Go's
+	ws := NewWebShopScraper(config.ScraperConfig{}, nil)
+	if _, err := ws.GetHTML(ctx, server.URL); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, DefaultUserAgent, header.Get("User-Agent"))
+
+	// Test case 2: The scraper's headers and user agent override the global ones
+	shared := NewShared(config.ProgramConfig{HTTP: config.HTTPConfig{
+		UserAgent: "GlobalAgent/1.0",
+		Headers:   map[string]string{"Accept-Language": "de-DE", "x-global": "yes"},
+	}})
+	ws = NewWebShopScraper(config.ScraperConfig{HTTP: config.HTTPConfig{
+		UserAgent: "ShopAgent/1.0",
+		Headers:   map[string]string{"accept-language": "sv-SE"},
+	}}, shared)
+	if _, err := ws.GetHTML(ctx, server.URL); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, "ShopAgent/1.0", header.Get("User-Agent"))
+	assert.Equal(t, "sv-SE", header.Get("Accept-Language"))
+	assert.Equal(t, "yes", header.Get("X-Global"))
+
+	// Test case 3: JSON API scrapers ask for JSON unless configured otherwise
+	js := NewJSONAPIScraper(config.ScraperConfig{}, shared)
+	if _, err := js.GetHTML(ctx, server.URL); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, "application/json", header.Get("Accept"))
+	assert.Equal(t, "GlobalAgent/1.0", header.Get("User-Agent"))
+}
+
+func TestFetchAcceptedStatusCodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<html>Not found, but still has products</html>"))
+	}))
+	defer server.Close()
+
+	// Test case 1: Only 200 is accepted by default
+	ws := NewWebShopScraper(config.ScraperConfig{}, nil)
+	if _, err := ws.GetHTML(context.Background(), server.URL); err == nil {
+		t.Errorf("Expected an error for status code 404")
+	}
+
+	// Test case 2: Configured status codes are accepted
+	ws = NewWebShopScraper(config.ScraperConfig{HTTP: config.HTTPConfig{AcceptedStatusCodes: []int{200, 404}}}, nil)
+	html, err := ws.GetHTML(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Contains(t, html, "still has products")
+}
+
+func TestFetchCookiesAndTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/session":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		default:
+			if cookie, err := r.Cookie("session"); err == nil {
+				w.Write([]byte(cookie.Value))
+			}
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	// Test case 1: Cookies are kept between requests when enabled
+	ws := NewWebShopScraper(config.ScraperConfig{HTTP: config.HTTPConfig{Cookies: boolPtr(true)}}, nil)
+	if _, err := ws.GetHTML(ctx, server.URL+"/session"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, err := ws.GetHTML(ctx, server.URL+"/products")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, "abc", body)
+
+	// Test case 2: Cookies are not kept by default
+	ws = NewWebShopScraper(config.ScraperConfig{}, nil)
+	if _, err := ws.GetHTML(ctx, server.URL+"/session"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, err = ws.GetHTML(ctx, server.URL+"/products")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, "", body)
+
+	// Test case 3: A scraper can turn off the cookies enabled globally
+	shared := NewShared(config.ProgramConfig{HTTP: config.HTTPConfig{Cookies: boolPtr(true)}})
+	ws = NewWebShopScraper(config.ScraperConfig{HTTP: config.HTTPConfig{Cookies: boolPtr(false)}}, shared)
+	if _, err := ws.GetHTML(ctx, server.URL+"/session"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, err = ws.GetHTML(ctx, server.URL+"/products")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, "", body)
+
+	// Test case 4: Requests exceeding the timeout fail
+	ws = NewWebShopScraper(config.ScraperConfig{HTTP: config.HTTPConfig{Timeout: 50 * time.Millisecond}}, nil)
+	if _, err := ws.GetHTML(ctx, server.URL+"/slow"); err == nil {
+		t.Errorf("Expected the request to time out")
+	}
+}
+
+func TestHTTPConfigMerge(t *testing.T) {
+	global := config.HTTPConfig{Cookies: boolPtr(true), TLS: config.TLSConfig{InsecureSkipVerify: boolPtr(true)}}
+
+	// Test case 1: Unset flags are taken from the global configuration
+	merged := config.HTTPConfig{}.Merge(global)
+	assert.True(t, config.Enabled(merged.Cookies))
+	assert.True(t, config.Enabled(merged.TLS.InsecureSkipVerify))
+
+	// Test case 2: An explicit false overrides the global true
+	merged = config.HTTPConfig{Cookies: boolPtr(false), TLS: config.TLSConfig{InsecureSkipVerify: boolPtr(false)}}.Merge(global)
+	assert.False(t, config.Enabled(merged.Cookies))
+	assert.False(t, config.Enabled(merged.TLS.InsecureSkipVerify))
+
+	// Test case 3: The certificate is verified by a scraper that turns off the
+	// global insecureSkipVerify
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+	shared := NewShared(config.ProgramConfig{HTTP: global})
+	ws := NewWebShopScraper(config.ScraperConfig{}, shared)
+	if _, err := ws.GetHTML(context.Background(), server.URL); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ws = NewWebShopScraper(config.ScraperConfig{HTTP: config.HTTPConfig{TLS: config.TLSConfig{InsecureSkipVerify: boolPtr(false)}}}, shared)
+	if _, err := ws.GetHTML(context.Background(), server.URL); err == nil {
+		t.Errorf("Expected the self-signed certificate to be rejected")
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestTransportsShared(t *testing.T) {
+	transports := NewTransports()
+	first, err := transports.get(config.HTTPConfig{UserAgent: "A"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := transports.get(config.HTTPConfig{UserAgent: "B", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	proxied, err := transports.get(config.HTTPConfig{Proxy: "http://proxy.example.com:3128"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	assert.Same(t, first, second)
+	assert.NotSame(t, first, proxied)
+}
+
+func TestCreateScrapersInvalidHTTPConfig(t *testing.T) {
+	tests := []config.HTTPConfig{
+		{Proxy: "ftp://proxy.example.com"},
+		{TLS: config.TLSConfig{MinVersion: "1.0"}},
+		{TLS: config.TLSConfig{CAFile: "/nonexistent/ca.pem"}},
+	}
+	for _, httpConfig := range tests {
+		_, err := CreateScrapers([]config.ScraperConfig{{Type: "WebShopScraper", ShopName: "Shop", HTTP: httpConfig}}, NewShared(config.ProgramConfig{}))
+		if err == nil {
+			t.Errorf("Expected an error for %+v", httpConfig)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/models"
+	"shopscraper/pkg/utils"
+	"strings"
+	"time"
+)
+
+// JSONAPIScraper scrapes shops that expose their catalogue through a JSON
+// endpoint, using JSONPath-style expressions instead of CSS selectors
+type JSONAPIScraper struct {
+	BaseScraper
+}
+
+// NewJSONAPIScraper creates a new instance of JSONAPIScraper
+func NewJSONAPIScraper(config config.ScraperConfig, shared *Shared) *JSONAPIScraper {
+	js := &JSONAPIScraper{
+		BaseScraper: BaseScraper{
+			Config: config,
+			Shared: shared,
+		},
+	}
+	js.HTMLGetter = js
+	js.Parser = js
+	return js
+}
+
+func (js *JSONAPIScraper) GetHTML(ctx context.Context, currentURL string) (string, error) {
+	return js.fetch(ctx, currentURL, "application/json")
+}
+
+// ParseHTML parses the JSON content and extracts product information
+func (js *JSONAPIScraper) ParseHTML(jsonContent, fetchedUrl string) ([]models.Product, string, error) {
+	decoder := json.NewDecoder(strings.NewReader(jsonContent))
+	decoder.UseNumber()
+
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, "", fmt.Errorf("failed to decode JSON from %s: %w", fetchedUrl, err)
+	}
+
+	items, err := evalJSONPath(data, js.Config.ItemsPath)
+	if err != nil {
+		return nil, "", err
+	}
+	// Allow the items path to point at the array itself rather than its elements
+	if len(items) == 1 {
+		if list, ok := items[0].([]interface{}); ok {
+			items = list
+		}
+	}
+
+	var products []models.Product
+	for _, item := range items {
+		itemName, err := jsonPathString(item, js.Config.NamePath)
+		if err != nil {
+			return nil, "", err
+		}
+		itemName = strings.Trim(itemName, "-. \t\n")
+
+		itemPrice, err := js.getJSONPrice(item)
+		if err != nil {
+			log.Printf("Failed to get price %v", err)
+		}
+
+		itemCurrency, err := jsonPathString(item, js.Config.CurrencyPath)
+		if err != nil {
+			return nil, "", err
+		}
+		if itemCurrency == "" {
+			itemCurrency = itemPrice.Currency
+		}
+
+		itemLink, err := jsonPathString(item, js.Config.LinkPath)
+		if err != nil {
+			return nil, "", err
+		}
+		itemLink, err = utils.EnsureFullUrl(itemLink, fetchedUrl, js.Config.UniqueParameters, js.Config.RemoveFragment)
+		if err != nil {
+			log.Printf("Failed to get full URL %v", err)
+		}
+
+		itemSKU, err := jsonPathString(item, js.Config.SKUPath)
+		if err != nil {
+			return nil, "", err
+		}
+
+		itemAvailability, err := jsonPathString(item, js.Config.AvailabilityPath)
+		if err != nil {
+			return nil, "", err
+		}
+
+		itemBrand, err := jsonPathString(item, js.Config.BrandPath)
+		if err != nil {
+			return nil, "", err
+		}
+
+		itemImage, err := jsonPathString(item, js.Config.ImagePath)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if itemName != "" && itemLink != "" {
+			products = appendUnique(products, js.identify(models.Product{
+				Name:         itemName,
+				Shop:         js.Config.ShopName,
+				Price:        itemPrice.Amount,
+				Currency:     itemCurrency,
+				Availability: normalizeAvailability(itemAvailability),
+				Link:         itemLink,
+				SKU:          itemSKU,
+				Brand:        itemBrand,
+				Image:        fullImageURL(itemImage, fetchedUrl),
+				LastSeen:     time.Now().UTC(),
+				Notified:     false,
+			}))
+		}
+	}
+
+	nextURL, err := js.nextPageURL(data, fetchedUrl)
+	if err != nil {
+		log.Printf("Failed to get next page URL %v", err)
+	}
+
+	return products, nextURL, nil
+}
+
+// getJSONPrice returns the price at PricePath. Numeric values are read as
+// decimal numbers, strings are parsed like HTML prices.
+func (js *JSONAPIScraper) getJSONPrice(item interface{}) (Price, error) {
+	price := Price{Currency: js.currency()}
+
+	value, err := jsonPathValue(item, js.Config.PricePath)
+	if err != nil || value == nil {
+		return price, err
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		price.Amount, err = parseStructuredPrice(v.String(), price.Currency)
+		return price, err
+	case string:
+		parsed, err := js.ParsePrice(v)
+		if err != nil {
+			return price, err
+		}
+		return parsed, nil
+	}
+	return price, fmt.Errorf("unsupported price value %v", value)
+}
+
+// nextPageURL resolves the next page either from a URL found at NextPagePath
+// or, when CursorParameter is set, by adding the cursor found at NextPagePath
+// to the fetched URL
+func (js *JSONAPIScraper) nextPageURL(data interface{}, fetchedUrl string) (string, error) {
+	next, err := jsonPathString(data, js.Config.NextPagePath)
+	if err != nil || next == "" || next == "false" {
+		return "", err
+	}
+
+	if js.Config.CursorParameter == "" {
+		return utils.EnsureFullUrl(next, fetchedUrl, []string{}, false)
+	}
+
+	nextURL, err := url.Parse(fetchedUrl)
+	if err != nil {
+		return "", err
+	}
+	query := nextURL.Query()
+	query.Set(js.Config.CursorParameter, next)
+	nextURL.RawQuery = query.Encode()
+	return nextURL.String(), nil
+}
@@ -0,0 +1,126 @@
+package scraper
+
+import (
+	"shopscraper/pkg/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalJSONPath(t *testing.T) {
+	data := map[string]interface{}{
+		"data": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"title": "Product 1"},
+				map[string]interface{}{"title": "Product 2"},
+			},
+		},
+		"product name": "Quoted",
+	}
+
+	// Test case 1: Wildcard over array elements
+	nodes, err := evalJSONPath(data, "$.data.items[*].title")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"Product 1", "Product 2"}, nodes)
+
+	// Test case 2: Array index, including negative index
+	nodes, err = evalJSONPath(data, "$.data.items[-1].title")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"Product 2"}, nodes)
+
+	// Test case 3: Quoted bracket key
+	nodes, err = evalJSONPath(data, "$['product name']")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"Quoted"}, nodes)
+
+	// Test case 4: Relative path without leading $
+	nodes, err = evalJSONPath(data, "data.items[0].title")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"Product 1"}, nodes)
+
+	// Test case 5: Missing key returns no nodes
+	nodes, err = evalJSONPath(data, "$.data.missing")
+	assert.NoError(t, err)
+	assert.Empty(t, nodes)
+
+	// Test case 6: Invalid path
+	_, err = evalJSONPath(data, "$.data.items[abc")
+	assert.Error(t, err)
+
+	// Test case 7: Wildcard over an object matches in key order
+	prices := map[string]interface{}{"prices": map[string]interface{}{"sek": 3, "eur": 1, "usd": 2, "gbp": 4}}
+	for i := 0; i < 10; i++ {
+		nodes, err = evalJSONPath(prices, "$.prices.*")
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{1, 4, 3, 2}, nodes)
+	}
+}
+
+func TestJSONAPIParseHTML(t *testing.T) {
+	js := NewJSONAPIScraper(config.ScraperConfig{
+		ShopName:     "Test Shop",
+		ItemsPath:    "$.data.products",
+		NamePath:     "name",
+		PricePath:    "$.price.amount",
+		LinkPath:     "url",
+		ImagePath:    "image",
+		BrandPath:    "brand",
+		NextPagePath: "$.links.next",
+	}, nil)
+
+	// Test case 1: Products with a next page URL
+	jsonContent := `{
+		"data": {
+			"products": [
+				{"name": "Product 1", "price": {"amount": 1499.95}, "url": "/product1", "brand": "Brand 1", "image": "/images/product1.jpg"},
+				{"name": "Product 2", "price": {"amount": "2 999,00€"}, "url": "https://example.com/product2"},
+				{"name": "", "price": {"amount": 10}, "url": "/nameless"}
+			]
+		},
+		"links": {"next": "/api/products?page=2"}
+	}`
+
+	products, nextURL, err := js.ParseHTML(jsonContent, "https://example.com/api/products")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(products) != 2 {
+		t.Fatalf("Expected 2 products, but got %d", len(products))
+	}
+	assert.Equal(t, "Product 1", products[0].Name)
+	assert.Equal(t, "Test Shop", products[0].Shop)
+	assert.Equal(t, 149995, products[0].Price)
+	assert.Equal(t, "EUR", products[0].Currency)
+	assert.Equal(t, "https://example.com/product1", products[0].Link)
+	assert.Equal(t, "Brand 1", products[0].Brand)
+	assert.Equal(t, "https://example.com/images/product1.jpg", products[0].Image)
+	assert.Equal(t, "Product 2", products[1].Name)
+	assert.Equal(t, 299900, products[1].Price)
+	assert.Equal(t, "https://example.com/product2", products[1].Link)
+	assert.Equal(t, "https://example.com/api/products?page=2", nextURL)
+
+	// Test case 2: Cursor based pagination
+	js.Config.NextPagePath = "$.meta.cursor"
+	js.Config.CursorParameter = "after"
+	jsonContent = `{"data": {"products": []}, "meta": {"cursor": "abc123"}}`
+
+	products, nextURL, err = js.ParseHTML(jsonContent, "https://example.com/api/products?limit=50")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Empty(t, products)
+	assert.Equal(t, "https://example.com/api/products?after=abc123&limit=50", nextURL)
+
+	// Test case 3: Last page has no cursor
+	jsonContent = `{"data": {"products": []}, "meta": {"cursor": null}}`
+	_, nextURL, err = js.ParseHTML(jsonContent, "https://example.com/api/products?limit=50")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, "", nextURL)
+
+	// Test case 4: Invalid JSON
+	_, _, err = js.ParseHTML("<html></html>", "https://example.com/api/products")
+	assert.Error(t, err)
+}
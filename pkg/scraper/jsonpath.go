@@ -0,0 +1,148 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is a single segment of a parsed JSONPath expression, either an
+// object key, an array index or a wildcard matching every child
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses a JSONPath-style expression such as "$.data.items[*]",
+// "$['product name']" or "offers[0].price". A leading "$" is optional, paths
+// without it are evaluated relative to the node they are applied to.
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+
+	var steps []jsonPathStep
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			key := path[i:end]
+			if key == "" {
+				return nil, fmt.Errorf("invalid JSON path '%s': empty key", path)
+			}
+			steps = append(steps, jsonPathStep{key: key, wildcard: key == "*"})
+			i = end
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSON path '%s': missing ']'", path)
+			}
+			inner := strings.TrimSpace(path[i+1 : i+end])
+			i += end + 1
+
+			switch {
+			case inner == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid JSON path '%s': bad index '%s'", path, inner)
+				}
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			}
+		default:
+			// Relative paths may start directly with a key, e.g. "name"
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			key := path[i:end]
+			steps = append(steps, jsonPathStep{key: key, wildcard: key == "*"})
+			i = end
+		}
+	}
+	return steps, nil
+}
+
+// evalJSONPath returns every node in data matched by the given path. A
+// wildcard over an object matches its values in the order of their keys.
+func evalJSONPath(data interface{}, path string) ([]interface{}, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []interface{}{data}
+	for _, step := range steps {
+		var next []interface{}
+		for _, node := range nodes {
+			switch v := node.(type) {
+			case map[string]interface{}:
+				if step.wildcard {
+					// Maps are unordered, so children are taken in key order
+					// for the products and first matches to be stable
+					for _, key := range sortedKeys(v) {
+						next = append(next, v[key])
+					}
+				} else if child, ok := v[step.key]; ok && !step.isIndex {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if step.wildcard {
+					next = append(next, v...)
+				} else if step.isIndex {
+					index := step.index
+					if index < 0 {
+						index += len(v)
+					}
+					if index >= 0 && index < len(v) {
+						next = append(next, v[index])
+					}
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes, nil
+}
+
+// jsonPathValue evaluates path against data and returns the first match, or
+// nil if nothing matched
+func jsonPathValue(data interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return nil, nil
+	}
+	nodes, err := evalJSONPath(data, path)
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+// jsonPathString evaluates path against data and returns the first match as a
+// string, or an empty string if nothing matched
+func jsonPathString(data interface{}, path string) (string, error) {
+	node, err := jsonPathValue(data, path)
+	if err != nil {
+		return "", err
+	}
+	switch v := node.(type) {
+	case string:
+		return strings.TrimSpace(v), nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,163 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"shopscraper/pkg/config"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// loadMoreWait is how long to wait for more items after scrolling or
+	// clicking before concluding that there are none
+	loadMoreWait = 5 * time.Second
+	// maxLoadMoreClicks stops clicking a load more button that never
+	// disappears
+	maxLoadMoreClicks = 100
+)
+
+// pageLoadMore loads more items into a page that has no next page link, by
+// scrolling to the bottom or clicking a load more button
+type pageLoadMore struct {
+	// scrollTimes is how often to scroll to the bottom
+	scrollTimes int
+	// loadMoreSelector is clicked until it disappears
+	loadMoreSelector string
+	// itemSelector counts the loaded items, without it the height of the
+	// page tells whether more items loaded
+	itemSelector string
+	// maxItems stops loading more items once this many are loaded
+	maxItems int
+}
+
+func newPageLoadMore(scraperConfig config.ScraperConfig) pageLoadMore {
+	return pageLoadMore{
+		scrollTimes:      scraperConfig.ScrollTimes,
+		loadMoreSelector: scraperConfig.LoadMoreSelector,
+		itemSelector:     scraperConfig.ItemSelector,
+		maxItems:         scraperConfig.MaxItems,
+	}
+}
+
+// validMaxItems reports whether the loaded items can be counted for maxItems,
+// which needs the itemSelector
+func validMaxItems(scraperConfig config.ScraperConfig) bool {
+	return scraperConfig.MaxItems == 0 || scraperConfig.ItemSelector != ""
+}
+
+// steps returns how often more items are loaded at most
+func (m pageLoadMore) steps() int {
+	if m.loadMoreSelector != "" {
+		return maxLoadMoreClicks
+	}
+	return m.scrollTimes
+}
+
+// load loads more items into the page of the tab of ctx until the load more
+// button disappears, scrollTimes is reached, maxItems are loaded or a scroll
+// or click loads nothing within loadMoreWait
+func (m pageLoadMore) load(ctx context.Context, currentURL string) error {
+	steps := m.steps()
+	if steps == 0 {
+		return nil
+	}
+
+	progress, err := m.progress(ctx)
+	if err != nil {
+		return err
+	}
+	for step := 0; step < steps; step++ {
+		if m.maxItems > 0 && m.itemSelector != "" && progress >= m.maxItems {
+			log.Printf("Loaded %d items of %s, the maximum", progress, currentURL)
+			return nil
+		}
+
+		triggered, err := m.trigger(ctx)
+		if err != nil {
+			return err
+		}
+		if !triggered {
+			// The load more button is gone, everything is loaded
+			return nil
+		}
+
+		next, err := m.waitForProgress(ctx, progress)
+		if err != nil {
+			return err
+		}
+		if next <= progress {
+			// Nothing more loaded
+			return nil
+		}
+		progress = next
+	}
+	return nil
+}
+
+// trigger scrolls to the bottom or clicks the load more button, reporting
+// false when there is no visible button to click
+func (m pageLoadMore) trigger(ctx context.Context) (bool, error) {
+	if m.loadMoreSelector == "" {
+		return true, chromedp.Run(ctx, chromedp.Evaluate(`window.scrollTo(0, document.body.scrollHeight)`, nil))
+	}
+
+	var clicked bool
+	err := chromedp.Run(ctx, chromedp.Evaluate(clickScript(m.loadMoreSelector), &clicked))
+	return clicked, err
+}
+
+// waitForProgress waits up to loadMoreWait for the progress of the page to
+// exceed previous and returns it
+func (m pageLoadMore) waitForProgress(ctx context.Context, previous int) (int, error) {
+	deadline := time.Now().Add(loadMoreWait)
+	for {
+		progress, err := m.progress(ctx)
+		if err != nil || progress > previous || time.Now().After(deadline) {
+			return progress, err
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// progress returns the number of loaded items, or the height of the page
+// without an item selector
+func (m pageLoadMore) progress(ctx context.Context) (int, error) {
+	var progress int
+	err := chromedp.Run(ctx, chromedp.Evaluate(progressScript(m.itemSelector), &progress))
+	return progress, err
+}
+
+func progressScript(itemSelector string) string {
+	if itemSelector == "" {
+		return `document.body.scrollHeight`
+	}
+	return fmt.Sprintf(`document.querySelectorAll(%s).length`, jsString(itemSelector))
+}
+
+// clickScript returns a script clicking the element of selector when it is
+// visible and enabled, and returning whether it did
+func clickScript(selector string) string {
+	return fmt.Sprintf(`(() => {
+		const button = document.querySelector(%s);
+		if (!button || button.disabled || button.offsetParent === null) {
+			return false;
+		}
+		button.scrollIntoView({block: "center"});
+		button.click();
+		return true;
+	})()`, jsString(selector))
+}
+
+// jsString quotes s as a JavaScript string literal
+func jsString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
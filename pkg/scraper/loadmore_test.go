@@ -0,0 +1,73 @@
+package scraper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"shopscraper/pkg/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageLoadMoreSteps(t *testing.T) {
+	// Test case 1: Nothing is loaded without configuration
+	assert.Equal(t, 0, newPageLoadMore(config.ScraperConfig{}).steps())
+
+	// Test case 2: Scrolling is limited to scrollTimes
+	assert.Equal(t, 3, newPageLoadMore(config.ScraperConfig{ScrollTimes: 3}).steps())
+
+	// Test case 3: A load more button is clicked until it disappears
+	assert.Equal(t, maxLoadMoreClicks, newPageLoadMore(config.ScraperConfig{LoadMoreSelector: "button.more"}).steps())
+}
+
+func TestLoadMoreScripts(t *testing.T) {
+	assert.Equal(t, `document.body.scrollHeight`, progressScript(""))
+	assert.Equal(t, `document.querySelectorAll("div[data-type=\"product\"]").length`, progressScript(`div[data-type="product"]`))
+	assert.Contains(t, clickScript(`button.load-more`), `document.querySelector("button.load-more")`)
+}
+
+func TestLoadMoreInBrowser(t *testing.T) {
+	if !chromeInstalled() {
+		t.Skip("no Chrome or Chromium installed")
+	}
+
+	// Every click on the button adds 5 items, up to 20
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><div id="items"></div><button class="more">Load more</button><script>
+			let count = 0;
+			const add = () => {
+				for (let i = 0; i < 5; i++) {
+					document.getElementById('items').insertAdjacentHTML('beforeend', '<div class="item">Item ' + count++ + '</div>');
+				}
+				if (count >= 20) document.querySelector('.more').remove();
+			};
+			add();
+			document.querySelector('.more').addEventListener('click', () => setTimeout(add, 100));
+		</script></body></html>`))
+	}))
+	defer server.Close()
+
+	pool := NewBrowserPool(config.BrowserConfig{})
+	defer pool.Close()
+	cfg := config.ScraperConfig{ItemSelector: ".item", LoadMoreSelector: "button.more", MaxWait: 10 * time.Second}
+
+	// Test case 1: The button is clicked until it disappears
+	js := NewJavaScriptWebShopScraper(cfg, &Shared{Browser: pool})
+	html, err := js.GetHTML(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, 20, strings.Count(html, `class="item"`))
+
+	// Test case 2: Loading stops at the item cap
+	cfg.MaxItems = 10
+	js = NewJavaScriptWebShopScraper(cfg, &Shared{Browser: pool})
+	html, err = js.GetHTML(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, 10, strings.Count(html, `class="item"`))
+}
@@ -1,77 +1,84 @@
 package scraper
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"shopscraper/pkg/models"
 	"shopscraper/pkg/utils"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
-func (bs *BaseScraper) ParsePrice(itemPrice string) string {
-	switch bs.Config.PriceFormat {
-	case "reverse":
-		// example: 1.499,00€
-		itemPrice = strings.Split(itemPrice, ",")[0]
-		itemPrice = strings.ReplaceAll(itemPrice, ".", "")
-	case "double_eur":
-		// example: 1 499,00EUR 2 500,00EUR
-		// this is the case in shops that use one text paragraph
-		// with strikethrough text to indicate a sale
-		itemPrice = strings.Split(itemPrice, "EUR")[0]
-	default:
-		itemPrice = strings.Split(itemPrice, ".")[0]
-		itemPrice = strings.Split(itemPrice, ",")[0]
-	}
-	itemPrice = strings.ReplaceAll(itemPrice, " ", "")
-	itemPrice = strings.ReplaceAll(itemPrice, "\u00a0", "")
-	itemPrice = strings.ReplaceAll(itemPrice, "\t", "")
-	itemPrice = strings.ReplaceAll(itemPrice, "\n", "")
-	itemPrice = strings.ReplaceAll(itemPrice, "EUR", "")
-	itemPrice = strings.ReplaceAll(itemPrice, "€", "")
-	return itemPrice
+// priceParser returns the price parser for the scraper configuration, creating it on first use
+func (bs *BaseScraper) priceParser() (*PriceParser, error) {
+	bs.pricesOnce.Do(func() {
+		bs.prices, bs.pricesErr = NewPriceParser(bs.Config)
+	})
+	return bs.prices, bs.pricesErr
+}
+
+// ParsePrice parses a price string such as "ab 1.499,00 €" using the
+// scraper's price locale, pattern and currency
+func (bs *BaseScraper) ParsePrice(itemPrice string) (Price, error) {
+	parser, err := bs.priceParser()
+	if err != nil {
+		return Price{}, err
+	}
+	return parser.Parse(itemPrice)
+}
+
+// currency returns the currency configured for the scraper, or models.DefaultCurrency
+func (bs *BaseScraper) currency() string {
+	if bs.Config.Currency != "" {
+		return bs.Config.Currency
+	}
+	return models.DefaultCurrency
+}
+
+// GetPrice extracts the price of an item. If a price selector matches
+// several elements the lowest price is used.
+func (bs *BaseScraper) GetPrice(s *goquery.Selection) (Price, error) {
+	return bs.priceFrom(s, bs.Config.PriceSelector)
 }
 
-func (bs *BaseScraper) GetPrice(s *goquery.Selection) (int, error) {
-	var itemPrice string
-	var lowestPrice int = 999999
+// priceFrom extracts the price found by the first of selectors that matches a
+// single element, or the lowest price matched by any of them
+func (bs *BaseScraper) priceFrom(s *goquery.Selection, selectors []string) (Price, error) {
+	var itemPrice Price
+	found := false
+	var errs []error
 
-	for _, selector := range bs.Config.PriceSelector {
+	for _, selector := range selectors {
 		findPrice := s.Find(selector)
 		if len(findPrice.Nodes) == 1 {
-			itemPrice = bs.ParsePrice(findPrice.Text())
-			break
-		} else {
-			findPrice.Each(func(j int, p *goquery.Selection) {
-				currentPrice := bs.ParsePrice(p.Text())
-				currentPriceInt, err := strconv.Atoi(currentPrice)
-				if err != nil {
-					log.Println("Error converting price to integer", currentPrice)
-					return // continue
-				}
-				if currentPriceInt < lowestPrice {
-					lowestPrice = currentPriceInt
-					itemPrice = currentPrice
-				}
-			})
+			return bs.ParsePrice(findPrice.Text())
 		}
-	}
 
-	if itemPrice == "" {
-		return 0, fmt.Errorf("unable to extract price from %s", s.Text())
+		findPrice.Each(func(j int, p *goquery.Selection) {
+			currentPrice, err := bs.ParsePrice(p.Text())
+			if err != nil {
+				errs = append(errs, err)
+				return // continue
+			}
+			if !found || currentPrice.Amount < itemPrice.Amount {
+				itemPrice = currentPrice
+				found = true
+			}
+		})
 	}
 
-	itemPriceInt, err := strconv.Atoi(itemPrice)
-	if err != nil {
-		log.Println("Error converting final price to integer:", itemPrice)
-		return 0, err
+	if !found {
+		if len(errs) > 0 {
+			return Price{}, fmt.Errorf("unable to extract price from %s: %w", s.Text(), errors.Join(errs...))
+		}
+		return Price{}, fmt.Errorf("unable to extract price from %s", s.Text())
 	}
 
-	return itemPriceInt, nil
+	return itemPrice, nil
 }
 
 // ParseHTML parses the HTML content and extracts product information
@@ -81,20 +88,26 @@ func (bs *BaseScraper) ParseHTML(htmlContent, fetchedUrl string) ([]models.Produ
 		return nil, "", err
 	}
 
+	if bs.Config.ExtractionMode == ExtractionModeStructured {
+		if products := bs.parseStructuredData(doc, fetchedUrl); len(products) > 0 {
+			return products, bs.nextPageURL(doc, fetchedUrl), nil
+		}
+	}
+
 	var products []models.Product
 	doc.Find(bs.Config.ItemSelector).Each(func(i int, s *goquery.Selection) {
 		itemName := s.Find(bs.Config.NameSelector).Text()
 		itemName = strings.TrimLeft(itemName, "-. \t\n")
 		itemName = strings.TrimRight(itemName, "-. \t\n")
 
-		var itemPrice int
+		itemPrice := Price{Currency: bs.currency()}
 		if len(bs.Config.PriceSelector) != 0 {
-			itemPrice, err = bs.GetPrice(s)
+			price, err := bs.GetPrice(s)
 			if err != nil {
 				log.Printf("Failed to get price %v", err)
+			} else {
+				itemPrice = price
 			}
-		} else {
-			itemPrice = 0
 		}
 
 		itemLink, _ := s.Find(bs.Config.LinkSelector).Attr("href")
@@ -105,33 +118,28 @@ func (bs *BaseScraper) ParseHTML(htmlContent, fetchedUrl string) ([]models.Produ
 
 		if itemName != "" && itemLink != "" {
 			product := models.Product{
-				Name:     itemName,
-				Shop:     bs.Config.ShopName,
-				Price:    itemPrice,
-				Link:     itemLink,
-				LastSeen: time.Now().UTC(),
-				Notified: false,
-			}
-
-			// Check if the product already exists in the products slice
-			exists := false
-			for _, p := range products {
-				if p.Name == product.Name && p.Price == product.Price && p.Link == product.Link {
-					exists = true
-					break
-				}
-			}
-
-			// Append the product to the products slice only if it doesn't already exist
-			if !exists {
-				products = append(products, product)
-
+				Name:         itemName,
+				Shop:         bs.Config.ShopName,
+				Price:        itemPrice.Amount,
+				Currency:     itemPrice.Currency,
+				Availability: normalizeAvailability(itemValue(s, bs.Config.AvailabilitySelector, bs.Config.AvailabilityAttribute)),
+				Link:         itemLink,
+				SKU:          itemValue(s, bs.Config.SKUSelector, bs.Config.SKUAttribute),
+				Brand:        itemValue(s, bs.Config.BrandSelector, bs.Config.BrandAttribute),
+				Image:        imageURL(s, bs.Config.ImageSelector, bs.Config.ImageAttribute, fetchedUrl),
+				LastSeen:     time.Now().UTC(),
+				Notified:     false,
 			}
 
+			products = appendUnique(products, bs.identify(product))
 		}
 	})
 
-	// Check for pagination if nextPageSelector is provided
+	return products, bs.nextPageURL(doc, fetchedUrl), nil
+}
+
+// nextPageURL returns the full URL of the next page if nextPageSelector is provided
+func (bs *BaseScraper) nextPageURL(doc *goquery.Document, fetchedUrl string) string {
 	nextURL := ""
 	if bs.Config.NextPageSelector != "" {
 		doc.Find(bs.Config.NextPageSelector).Each(func(i int, s *goquery.Selection) {
@@ -139,6 +147,7 @@ func (bs *BaseScraper) ParseHTML(htmlContent, fetchedUrl string) ([]models.Produ
 				return
 			}
 			if href, exists := s.Attr("href"); exists {
+				var err error
 				nextURL, err = utils.EnsureFullUrl(href, fetchedUrl, []string{}, false)
 				if err != nil {
 					log.Printf("Failed to get full URL %v", err)
@@ -147,5 +156,82 @@ func (bs *BaseScraper) ParseHTML(htmlContent, fetchedUrl string) ([]models.Produ
 		})
 	}
 
-	return products, nextURL, nil
+	return nextURL
+}
+
+// selectorValue returns the trimmed text of the first matched element, or the
+// value of attribute when one is given
+func selectorValue(s *goquery.Selection, attribute string) string {
+	s = s.First()
+	if attribute != "" {
+		value, _ := s.Attr(attribute)
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(s.Text())
+}
+
+// itemValue returns the value of selector within the item, or of attribute of
+// the item element itself when there is no selector
+func itemValue(s *goquery.Selection, selector, attribute string) string {
+	if selector != "" {
+		return selectorValue(s.Find(selector), attribute)
+	}
+	if attribute != "" {
+		return selectorValue(s, attribute)
+	}
+	return ""
+}
+
+// imageURL returns the full URL of the image of selector within the item,
+// read from attribute or src. The first candidate of a srcset is used, and
+// inline data images, usually lazy loading placeholders, are ignored.
+func imageURL(s *goquery.Selection, selector, attribute, fetchedUrl string) string {
+	if selector != "" && attribute == "" {
+		attribute = "src"
+	}
+	image := itemValue(s, selector, attribute)
+	if strings.HasSuffix(strings.ToLower(attribute), "srcset") {
+		image, _, _ = strings.Cut(image, ",")
+		if fields := strings.Fields(image); len(fields) > 0 {
+			image = fields[0]
+		}
+	}
+	return fullImageURL(image, fetchedUrl)
+}
+
+// fullImageURL resolves an image URL against the page it was found on
+func fullImageURL(image, fetchedUrl string) string {
+	if image == "" || strings.HasPrefix(image, "data:") {
+		return ""
+	}
+	// Protocol relative URLs are common for images served from a CDN
+	if strings.HasPrefix(image, "//") {
+		scheme := "https"
+		if u, err := url.Parse(fetchedUrl); err == nil && u.Scheme != "" {
+			scheme = u.Scheme
+		}
+		image = scheme + ":" + image
+	}
+	image, err := utils.EnsureFullUrl(image, fetchedUrl, nil, false)
+	if err != nil {
+		log.Printf("Failed to get full image URL %v", err)
+		return ""
+	}
+	return image
+}
+
+// identify sets the identity key of the product according to the scraper's identity strategy
+func (bs *BaseScraper) identify(product models.Product) models.Product {
+	product.IdentityKey = models.ProductKey(bs.Config.Identity, product)
+	return product
+}
+
+// appendUnique appends the product to the products slice only if it doesn't already exist
+func appendUnique(products []models.Product, product models.Product) []models.Product {
+	for _, p := range products {
+		if p.Name == product.Name && p.Price == product.Price && p.Link == product.Link {
+			return products
+		}
+	}
+	return append(products, product)
 }
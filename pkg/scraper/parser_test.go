@@ -13,47 +13,57 @@ import (
 )
 
 func TestParsePrice(t *testing.T) {
-	bs := &BaseScraper{
-		Config: config.ScraperConfig{
-			PriceFormat: "reverse",
-		},
-	}
-
 	// Test case 1: Price format is "reverse"
+	bs := &BaseScraper{Config: config.ScraperConfig{PriceFormat: "reverse"}}
 	itemPrice := "1.499,00€"
-	expectedResult := "1499"
-	result := bs.ParsePrice(itemPrice)
-	if result != expectedResult {
-		t.Errorf("Expected %s, but got %s", expectedResult, result)
+	expectedResult := Price{Amount: 149900, Currency: "EUR"}
+	result, err := bs.ParsePrice(itemPrice)
+	if err != nil || result != expectedResult {
+		t.Errorf("Expected %v, but got %v (%v)", expectedResult, result, err)
 	}
 
 	// Test case 2: Price format is not set
-	bs.Config.PriceFormat = ""
+	bs = &BaseScraper{Config: config.ScraperConfig{}}
 	itemPrice = "1 499.00 EUR"
-	expectedResult = "1499"
-	result = bs.ParsePrice(itemPrice)
-	if result != expectedResult {
-		t.Errorf("Expected %s, but got %s", expectedResult, result)
+	expectedResult = Price{Amount: 149900, Currency: "EUR"}
+	result, err = bs.ParsePrice(itemPrice)
+	if err != nil || result != expectedResult {
+		t.Errorf("Expected %v, but got %v (%v)", expectedResult, result, err)
 	}
 
 	// Test case 3: Price format is unknown
-	bs.Config.PriceFormat = "unknown"
-	itemPrice = "1499,00€"
-	expectedResult = "1499"
-	result = bs.ParsePrice(itemPrice)
-	if result != expectedResult {
-		t.Errorf("Expected %s, but got %s", expectedResult, result)
+	bs = &BaseScraper{Config: config.ScraperConfig{PriceFormat: "unknown"}}
+	itemPrice = "1499,50€"
+	expectedResult = Price{Amount: 149950, Currency: "EUR"}
+	result, err = bs.ParsePrice(itemPrice)
+	if err != nil || result != expectedResult {
+		t.Errorf("Expected %v, but got %v (%v)", expectedResult, result, err)
 	}
 
 	// Test case 4: Price format is double_eur
-	bs.Config.PriceFormat = "double_eur"
+	bs = &BaseScraper{Config: config.ScraperConfig{PriceFormat: "double_eur"}}
 	itemPrice = "1 499 EUR 2 055 EUR"
-	expectedResult = "1499"
-	result = bs.ParsePrice(itemPrice)
-	if result != expectedResult {
-		t.Errorf("Expected %s, but got %s", expectedResult, result)
+	expectedResult = Price{Amount: 149900, Currency: "EUR"}
+	result, err = bs.ParsePrice(itemPrice)
+	if err != nil || result != expectedResult {
+		t.Errorf("Expected %v, but got %v (%v)", expectedResult, result, err)
+	}
+
+	// Test case 5: Configured currency is used when the price has none
+	bs = &BaseScraper{Config: config.ScraperConfig{Currency: "SEK", PriceLocale: "sv"}}
+	itemPrice = "1 499,00"
+	expectedResult = Price{Amount: 149900, Currency: "SEK"}
+	result, err = bs.ParsePrice(itemPrice)
+	if err != nil || result != expectedResult {
+		t.Errorf("Expected %v, but got %v (%v)", expectedResult, result, err)
 	}
 
+	// Test case 6: Invalid locale is reported
+	bs = &BaseScraper{Config: config.ScraperConfig{PriceLocale: "xx"}}
+	_, err = bs.ParsePrice("10")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown locale")
+	}
 }
 
 func TestGetPrice(t *testing.T) {
@@ -67,30 +77,30 @@ func TestGetPrice(t *testing.T) {
 	doc := `
 		<div class="price">1 499,00€</div>
 	`
-	expectedResult := 1499
+	expectedResult := 149900
 	docSelection, err := goquery.NewDocumentFromReader(strings.NewReader(doc))
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
 	result, _ := bs.GetPrice(docSelection.Selection)
-	if result != expectedResult {
-		t.Errorf("Expected %d, but got %d", expectedResult, result)
+	if result.Amount != expectedResult {
+		t.Errorf("Expected %d, but got %d", expectedResult, result.Amount)
 	}
 
 	// Test case 2: Multiple price elements, lowest price is selected
 	doc = `
 		<div class="price">2 499,00€</div>
 		<div class="price">1 999,00€</div>
-		<div class="price">1 499,00€</div>
+		<div class="price">1 499,50€</div>
 	`
-	expectedResult = 1499
+	expectedResult = 149950
 	docSelection, err = goquery.NewDocumentFromReader(strings.NewReader(doc))
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
 	result, _ = bs.GetPrice(docSelection.Selection)
-	if result != expectedResult {
-		t.Errorf("Expected %d, but got %d", expectedResult, result)
+	if result.Amount != expectedResult {
+		t.Errorf("Expected %d, but got %d", expectedResult, result.Amount)
 	}
 
 	// Test case 3: No price element found
@@ -107,8 +117,8 @@ func TestGetPrice(t *testing.T) {
 		if !strings.Contains(err.Error(), fmt.Sprintf("unable to extract price from %s", docSelection.Text())) {
 			t.Errorf("Expected error message 'unable to extract price from %s', but got: %s", docSelection.Text(), err)
 		}
-	} else if result != expectedResult {
-		t.Errorf("Expected %d, but got %d", expectedResult, result)
+	} else if result.Amount != expectedResult {
+		t.Errorf("Expected %d, but got %d", expectedResult, result.Amount)
 	}
 
 	// Test case 4: Multiple price elements, including broken
@@ -118,14 +128,14 @@ func TestGetPrice(t *testing.T) {
 		<div class="price">Fake</div>
 		<div class="price">1 499,00€</div>
 	`
-	expectedResult = 1499
+	expectedResult = 149900
 	docSelection, err = goquery.NewDocumentFromReader(strings.NewReader(doc))
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
 	result, _ = bs.GetPrice(docSelection.Selection)
-	if result != expectedResult {
-		t.Errorf("Expected %d, but got %d", expectedResult, result)
+	if result.Amount != expectedResult {
+		t.Errorf("Expected %d, but got %d", expectedResult, result.Amount)
 	}
 }
 
@@ -159,7 +169,8 @@ func TestParseHTML(t *testing.T) {
 		{
 			Name:     "Product 1",
 			Shop:     "Test Shop",
-			Price:    1499,
+			Price:    149900,
+			Currency: "EUR",
 			Link:     "https://example.com/product1",
 			LastSeen: time.Now().UTC(),
 			Notified: false,
@@ -167,7 +178,8 @@ func TestParseHTML(t *testing.T) {
 		{
 			Name:     "Product 2",
 			Shop:     "Test Shop",
-			Price:    2999,
+			Price:    299900,
+			Currency: "EUR",
 			Link:     "https://example.com/product2",
 			LastSeen: time.Now().UTC(),
 			Notified: false,
@@ -189,6 +201,7 @@ func TestParseHTML(t *testing.T) {
 		assert.Equal(t, expected.Name, products[i].Name, "Product %d Name mismatch", i+1)
 		assert.Equal(t, expected.Shop, products[i].Shop, "Product %d Shop mismatch", i+1)
 		assert.Equal(t, expected.Price, products[i].Price, "Product %d Price mismatch", i+1)
+		assert.Equal(t, expected.Currency, products[i].Currency, "Product %d Currency mismatch", i+1)
 		assert.Equal(t, expected.Link, products[i].Link, "Product %d Link mismatch", i+1)
 		assert.Equal(t, expected.Notified, products[i].Notified, "Product %d Notified status mismatch", i+1)
 	}
@@ -228,6 +241,7 @@ func TestParseHTML(t *testing.T) {
 		assert.Equal(t, expected.Name, products[i].Name, "Product %d Name mismatch", i+1)
 		assert.Equal(t, expected.Shop, products[i].Shop, "Product %d Shop mismatch", i+1)
 		assert.Equal(t, expected.Price, products[i].Price, "Product %d Price mismatch", i+1)
+		assert.Equal(t, expected.Currency, products[i].Currency, "Product %d Currency mismatch", i+1)
 		assert.Equal(t, expected.Link, products[i].Link, "Product %d Link mismatch", i+1)
 		assert.Equal(t, expected.Notified, products[i].Notified, "Product %d Notified status mismatch", i+1)
 	}
@@ -238,3 +252,314 @@ func TestParseHTML(t *testing.T) {
 	}
 
 }
+
+func TestParseHTMLStructuredData(t *testing.T) {
+	bs := &BaseScraper{
+		Config: config.ScraperConfig{
+			ExtractionMode:   ExtractionModeStructured,
+			ItemSelector:     ".item",
+			NameSelector:     ".name",
+			LinkSelector:     ".link",
+			NextPageSelector: ".next",
+			PriceSelector:    []string{".price"},
+			PriceFormat:      "reverse",
+			ShopName:         "Test Shop",
+		},
+	}
+
+	// Test case 1: ItemList with Products, offers as a list and an AggregateOffer
+	htmlContent := `
+		<script type="application/ld+json">
+		{
+			"@context": "https://schema.org",
+			"@type": "ItemList",
+			"itemListElement": [
+				{
+					"@type": "ListItem",
+					"position": 1,
+					"item": {
+						"@type": "Product",
+						"name": "Product 1",
+						"url": "/product1",
+						"brand": {"@type": "Brand", "name": "Brand 1"},
+						"image": [{"@type": "ImageObject", "url": "//cdn.example.com/product1.jpg"}],
+						"offers": [
+							{"@type": "Offer", "price": "1499.00", "priceCurrency": "EUR", "availability": "https://schema.org/InStock"},
+							{"@type": "Offer", "price": 1299.50, "priceCurrency": "EUR", "availability": "https://schema.org/OutOfStock"}
+						]
+					}
+				},
+				{
+					"@type": "ListItem",
+					"position": 2,
+					"item": {
+						"@type": "Product",
+						"name": "Product 2",
+						"url": "https://example.com/product2",
+						"offers": {"@type": "AggregateOffer", "lowPrice": "2999", "priceCurrency": "SEK"}
+					}
+				}
+			]
+		}
+		</script>
+		<div class="item">
+			<div class="name">Selector Product</div>
+			<div class="price">1.499,00€</div>
+			<a class="link" href="/selector">Link</a>
+		</div>
+		<a class="next" href="/next">Next Page</a>
+	`
+
+	products, nextURL, err := bs.ParseHTML(htmlContent, "https://example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(products) != 2 {
+		t.Fatalf("Expected 2 products, but got %d", len(products))
+	}
+	assert.Equal(t, "Product 1", products[0].Name)
+	assert.Equal(t, 129950, products[0].Price)
+	assert.Equal(t, "EUR", products[0].Currency)
+	assert.Equal(t, "OutOfStock", products[0].Availability)
+	assert.Equal(t, "https://example.com/product1", products[0].Link)
+	assert.Equal(t, "Brand 1", products[0].Brand)
+	assert.Equal(t, "https://cdn.example.com/product1.jpg", products[0].Image)
+	assert.Equal(t, "Product 2", products[1].Name)
+	assert.Equal(t, 299900, products[1].Price)
+	assert.Equal(t, "SEK", products[1].Currency)
+	assert.Equal(t, "https://example.com/product2", products[1].Link)
+	assert.Equal(t, "https://example.com/next", nextURL)
+
+	// Test case 2: Product page in a @graph without its own URL
+	htmlContent = `
+		<script type="application/ld+json">
+		{"@graph": [
+			{"@type": "WebPage", "name": "Product page"},
+			{"@type": ["Product", "Thing"], "name": "Product 3", "brand": {"@type": "Brand", "@id": "https://example.com/#brand", "name": "Brand 3"}, "offers": {"@type": "Offer", "priceSpecification": {"price": "19.99", "priceCurrency": "USD"}}}
+		]}
+		</script>
+	`
+
+	products, _, err = bs.ParseHTML(htmlContent, "https://example.com/product3")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(products) != 1 {
+		t.Fatalf("Expected 1 product, but got %d", len(products))
+	}
+	assert.Equal(t, "Product 3", products[0].Name)
+	assert.Equal(t, 1999, products[0].Price)
+	assert.Equal(t, "USD", products[0].Currency)
+	assert.Equal(t, "https://example.com/product3", products[0].Link)
+	assert.Equal(t, "Brand 3", products[0].Brand, "The brand name should be used rather than its @id")
+
+	// Test case 3: Types with a schema.org prefix
+	for _, schemaType := range []string{"schema:Product", "http://schema.org/Product", "https://schema.org/Product"} {
+		htmlContent = `<script type="application/ld+json">
+			{"@type": "` + schemaType + `", "name": "Product 4", "offers": {"@type": "Offer", "price": "5.00", "priceCurrency": "EUR"}}
+			</script>`
+
+		products, _, err = bs.ParseHTML(htmlContent, "https://example.com/product4")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if assert.Len(t, products, 1, "Expected a product of type %s", schemaType) {
+			assert.Equal(t, "Product 4", products[0].Name)
+			assert.Equal(t, 500, products[0].Price)
+		}
+	}
+
+	// Test case 4: ListItems referring to their product by @id, described
+	// elsewhere on the page or only named by the ListItem
+	htmlContent = `
+		<script type="application/ld+json">
+		{"@type": "ItemList", "itemListElement": [
+			{"@type": "ListItem", "position": 1, "item": {"@id": "https://example.com/product5"}},
+			{"@type": "ListItem", "position": 2, "name": "Product 6", "item": {"@id": "https://example.com/product6"}},
+			{"@type": "ListItem", "position": 3, "item": {"@id": "https://example.com/unnamed"}}
+		]}
+		</script>
+		<script type="application/ld+json">
+		{"@graph": [
+			{"@type": "Product", "@id": "https://example.com/product5", "name": "Product 5", "url": "https://example.com/product5",
+				"offers": {"@type": "Offer", "price": "7.00", "priceCurrency": "EUR"}}
+		]}
+		</script>
+	`
+
+	products, _, err = bs.ParseHTML(htmlContent, "https://example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Len(t, products, 2) {
+		assert.Equal(t, "Product 5", products[0].Name)
+		assert.Equal(t, 700, products[0].Price)
+		assert.Equal(t, "Product 6", products[1].Name)
+		assert.Equal(t, "https://example.com/product6", products[1].Link)
+	}
+
+	// Test case 5: Products under several keys of an object are found in key
+	// order, on every run
+	htmlContent = `
+		<script type="application/ld+json">
+		{"@type": "WebPage",
+			"mainEntity": {"@type": "Product", "name": "Product 8", "offers": {"@type": "Offer", "price": "8.00", "priceCurrency": "EUR"}},
+			"hasPart": {"@type": "Product", "name": "Product 7", "offers": {"@type": "Offer", "price": "7.00", "priceCurrency": "EUR"}}
+		}
+		</script>
+	`
+
+	for i := 0; i < 20; i++ {
+		products, _, err = bs.ParseHTML(htmlContent, "https://example.com")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(products) != 2 {
+			t.Fatalf("Expected 2 products, but got %d", len(products))
+		}
+		assert.Equal(t, []string{"Product 7", "Product 8"}, []string{products[0].Name, products[1].Name})
+	}
+
+	// Test case 6: No structured data falls back to selectors
+	htmlContent = `
+		<div class="item">
+			<div class="name">Selector Product</div>
+			<div class="price">1.499,00€</div>
+			<a class="link" href="/selector">Link</a>
+		</div>
+	`
+
+	products, _, err = bs.ParseHTML(htmlContent, "https://example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(products) != 1 {
+		t.Fatalf("Expected 1 product, but got %d", len(products))
+	}
+	assert.Equal(t, "Selector Product", products[0].Name)
+	assert.Equal(t, 149900, products[0].Price)
+	assert.Equal(t, "EUR", products[0].Currency)
+	assert.Equal(t, "https://example.com/selector", products[0].Link)
+}
+
+func TestParseHTMLIdentity(t *testing.T) {
+	htmlContent := `
+		<div class="item" data-sku="SKU-1">
+			<div class="name">Product 1</div>
+			<span class="gtin">4006381333931</span>
+			<a class="link" href="/product1">Link</a>
+		</div>
+	`
+	cfg := config.ScraperConfig{
+		ItemSelector: ".item",
+		NameSelector: ".name",
+		LinkSelector: ".link",
+		ShopName:     "Test Shop",
+	}
+
+	// Test case 1: The default identity uses the name and link
+	bs := &BaseScraper{Config: cfg}
+	products, _, err := bs.ParseHTML(htmlContent, "https://example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Equal(t, 1, len(products)) {
+		assert.Equal(t, "name_link:Product 1\nhttps://example.com/product1", products[0].IdentityKey)
+	}
+
+	// Test case 2: SKU from the text of an element
+	cfg.Identity = models.IdentitySKU
+	cfg.SKUSelector = ".gtin"
+	bs = &BaseScraper{Config: cfg}
+	products, _, err = bs.ParseHTML(htmlContent, "https://example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Equal(t, 1, len(products)) {
+		assert.Equal(t, "4006381333931", products[0].SKU)
+		assert.Equal(t, "sku:4006381333931", products[0].IdentityKey)
+	}
+
+	// Test case 3: SKU from an attribute of the item itself
+	cfg.SKUSelector = ""
+	cfg.SKUAttribute = "data-sku"
+	bs = &BaseScraper{Config: cfg}
+	products, _, err = bs.ParseHTML(htmlContent, "https://example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Equal(t, 1, len(products)) {
+		assert.Equal(t, "sku:SKU-1", products[0].IdentityKey)
+	}
+
+	// Test case 4: Products without a SKU fall back to the link
+	cfg.SKUSelector = ".missing"
+	bs = &BaseScraper{Config: cfg}
+	products, _, err = bs.ParseHTML(htmlContent, "https://example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Equal(t, 1, len(products)) {
+		assert.Equal(t, "link:https://example.com/product1", products[0].IdentityKey)
+	}
+}
+
+func TestParseHTMLAttributes(t *testing.T) {
+	htmlContent := `
+		<div class="item" data-brand="Brand 1">
+			<img class="thumb" src="data:image/gif;base64,R0lGODlhAQABAAAAACw=" data-src="/images/product1.jpg" srcset="/images/product1-small.jpg 1x, /images/product1-large.jpg 2x">
+			<div class="name">Product 1</div>
+			<a class="link" href="/product1">Link</a>
+			<span class="stock" data-availability="https://schema.org/InStock">In stock</span>
+		</div>
+	`
+	cfg := config.ScraperConfig{
+		ItemSelector:          ".item",
+		NameSelector:          ".name",
+		LinkSelector:          ".link",
+		ImageSelector:         ".thumb",
+		ImageAttribute:        "data-src",
+		AvailabilitySelector:  ".stock",
+		AvailabilityAttribute: "data-availability",
+		BrandAttribute:        "data-brand",
+	}
+
+	// Test case 1: Attributes from elements within the item and from the item itself
+	bs := &BaseScraper{Config: cfg}
+	products, _, err := bs.ParseHTML(htmlContent, "https://example.com/list")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Equal(t, 1, len(products)) {
+		assert.Equal(t, "https://example.com/images/product1.jpg", products[0].Image)
+		assert.Equal(t, "InStock", products[0].Availability)
+		assert.Equal(t, "Brand 1", products[0].Brand)
+	}
+
+	// Test case 2: The text of the availability element and the first srcset candidate
+	cfg.AvailabilityAttribute = ""
+	cfg.ImageAttribute = "srcset"
+	bs = &BaseScraper{Config: cfg}
+	products, _, err = bs.ParseHTML(htmlContent, "https://example.com/list")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Equal(t, 1, len(products)) {
+		assert.Equal(t, "https://example.com/images/product1-small.jpg", products[0].Image)
+		assert.Equal(t, "In stock", products[0].Availability)
+	}
+
+	// Test case 3: The src of a lazy loaded image is a placeholder and ignored
+	cfg.ImageAttribute = ""
+	bs = &BaseScraper{Config: cfg}
+	products, _, err = bs.ParseHTML(htmlContent, "https://example.com/list")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if assert.Equal(t, 1, len(products)) {
+		assert.Equal(t, "", products[0].Image)
+	}
+}
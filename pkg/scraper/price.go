@@ -0,0 +1,403 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/models"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Price is a parsed price in minor units of Currency, e.g. cents for EUR
+type Price struct {
+	Amount   int
+	Currency string
+}
+
+var (
+	// ErrNoPrice is returned when the text contains no number
+	ErrNoPrice = errors.New("no price found")
+	// ErrPatternMismatch is returned when the configured price pattern does not match
+	ErrPatternMismatch = errors.New("price pattern did not match")
+	// ErrInvalidNumber is returned when the number does not follow the separator conventions
+	ErrInvalidNumber = errors.New("invalid number format")
+)
+
+// PriceError describes why a price string could not be parsed
+type PriceError struct {
+	Input string
+	Err   error
+}
+
+func (e *PriceError) Error() string {
+	return fmt.Sprintf("unable to parse price '%s': %v", e.Input, e.Err)
+}
+
+func (e *PriceError) Unwrap() error {
+	return e.Err
+}
+
+// locale describes the number conventions of a locale. Any separator other
+// than the decimal separator is accepted as a thousands separator. A zero
+// decimal separator means it is inferred from the price itself.
+type locale struct {
+	decimal rune
+	krona   string
+}
+
+var locales = map[string]locale{
+	"en": {decimal: '.'},
+	"de": {decimal: ','},
+	"nl": {decimal: ','},
+	"es": {decimal: ','},
+	"it": {decimal: ','},
+	"pt": {decimal: ','},
+	"fr": {decimal: ','},
+	"fi": {decimal: ','},
+	"pl": {decimal: ','},
+	"cs": {decimal: ','},
+	"da": {decimal: ',', krona: "DKK"},
+	"sv": {decimal: ',', krona: "SEK"},
+	"nb": {decimal: ',', krona: "NOK"},
+	"no": {decimal: ',', krona: "NOK"},
+	"ch": {decimal: '.'},
+}
+
+// lookupLocale resolves locales such as "de", "de-AT" or "sv_SE"; the
+// region "CH" selects Swiss conventions regardless of the language
+func lookupLocale(name string) (locale, error) {
+	if name == "" {
+		return locale{}, nil
+	}
+	name = strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+	language, region, _ := strings.Cut(name, "-")
+	if region == "ch" {
+		language = "ch"
+	}
+	l, ok := locales[language]
+	if !ok {
+		return locale{}, fmt.Errorf("unknown price locale '%s'", name)
+	}
+	return l, nil
+}
+
+// currencyTokens maps currency symbols and codes to ISO 4217 codes. "kr" and
+// "$" are resolved through the locale or the configured currency since they
+// are shared by several currencies.
+var currencyTokens = []struct {
+	token    string
+	currency string
+}{
+	{"EUR", "EUR"}, {"€", "EUR"},
+	{"USD", "USD"}, {"US$", "USD"}, {"$", ""},
+	{"CAD", "CAD"}, {"CA$", "CAD"}, {"C$", "CAD"},
+	{"AUD", "AUD"}, {"AU$", "AUD"}, {"A$", "AUD"},
+	{"NZD", "NZD"}, {"NZ$", "NZD"},
+	{"SGD", "SGD"}, {"S$", "SGD"},
+	{"HKD", "HKD"}, {"HK$", "HKD"},
+	{"GBP", "GBP"}, {"£", "GBP"},
+	{"CHF", "CHF"}, {"Fr.", "CHF"},
+	{"SEK", "SEK"}, {"NOK", "NOK"}, {"DKK", "DKK"}, {"kr", ""},
+	{"PLN", "PLN"}, {"zł", "PLN"},
+	{"CZK", "CZK"}, {"Kč", "CZK"},
+	{"JPY", "JPY"}, {"円", "JPY"},
+	{"KRW", "KRW"}, {"₩", "KRW"},
+}
+
+// validPriceFormat reports whether format is a supported legacy price format,
+// "reverse" being the only one left
+func validPriceFormat(format string) bool {
+	return format == "" || format == "reverse"
+}
+
+// PriceParser parses prices written in the conventions of a locale
+type PriceParser struct {
+	locale          locale
+	defaultCurrency string
+	pattern         *regexp.Regexp
+}
+
+// NewPriceParser creates a price parser from the priceLocale, pricePattern,
+// priceFormat and currency options of a scraper configuration
+func NewPriceParser(cfg config.ScraperConfig) (*PriceParser, error) {
+	localeName := cfg.PriceLocale
+	// "reverse" is the legacy name for prices such as 1.499,00€
+	if localeName == "" && cfg.PriceFormat == "reverse" {
+		localeName = "de"
+	}
+	l, err := lookupLocale(localeName)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &PriceParser{
+		locale:          l,
+		defaultCurrency: cfg.Currency,
+	}
+	if parser.defaultCurrency == "" {
+		parser.defaultCurrency = models.DefaultCurrency
+	}
+
+	if cfg.PricePattern != "" {
+		parser.pattern, err = regexp.Compile(cfg.PricePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price pattern '%s': %w", cfg.PricePattern, err)
+		}
+	}
+	return parser, nil
+}
+
+// Parse extracts the first price from text, e.g. "ab 1.499,00 €" or
+// "$10–$20", where ranges resolve to their lower bound
+func (pp *PriceParser) Parse(text string) (Price, error) {
+	priceText := text
+	currencyText := text
+
+	if pp.pattern != nil {
+		match := pp.pattern.FindStringSubmatch(text)
+		if match == nil {
+			return Price{}, &PriceError{Input: text, Err: ErrPatternMismatch}
+		}
+		// Use the "price" group, the first group or the whole match, in that order
+		priceText = match[0]
+		if i := pp.pattern.SubexpIndex("price"); i != -1 {
+			priceText = match[i]
+		} else if len(match) > 1 {
+			priceText = match[1]
+		}
+		if i := pp.pattern.SubexpIndex("currency"); i != -1 && match[i] != "" {
+			currencyText = match[i]
+		}
+	}
+
+	number, err := firstNumber(priceText)
+	if err != nil {
+		return Price{}, &PriceError{Input: text, Err: err}
+	}
+
+	currency := pp.detectCurrency(currencyText)
+	amount, err := pp.toMinorUnits(number, models.CurrencyExponent(currency))
+	if err != nil {
+		return Price{}, &PriceError{Input: text, Err: err}
+	}
+
+	return Price{Amount: amount, Currency: currency}, nil
+}
+
+// firstNumber returns the first run of digits in text together with any
+// separators inside it. Whitespace and apostrophes only count as thousands
+// separators when followed by exactly three digits, so "10 20" yields "10".
+// A "." or "," right before the digits is kept as the decimal separator of a
+// number such as ".99", unless it follows a letter as in "Nr.5". Negative
+// numbers and numbers glued to more digits by a letter, such as "1e999", are
+// not valid.
+func firstNumber(text string) (string, error) {
+	runes := []rune(text)
+
+	start := -1
+	for i, r := range runes {
+		if unicode.IsDigit(r) {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		return "", ErrNoPrice
+	}
+	first := start
+	if first > 0 && (runes[first-1] == '.' || runes[first-1] == ',') && (first == 1 || !unicode.IsLetter(runes[first-2])) {
+		first--
+	}
+
+	digitsFrom := func(i int) int {
+		n := 0
+		for i+n < len(runes) && unicode.IsDigit(runes[i+n]) {
+			n++
+		}
+		return n
+	}
+
+	end := start + digitsFrom(start)
+	for end+1 < len(runes) {
+		separator := runes[end]
+		digits := digitsFrom(end + 1)
+		if digits == 0 {
+			break
+		}
+		if isGroupSeparator(separator) {
+			if digits != 3 {
+				break
+			}
+		} else if separator != '.' && separator != ',' {
+			break
+		}
+		end += 1 + digits
+	}
+
+	number := string(runes[first:end])
+	if first > 0 && (runes[first-1] == '-' || runes[first-1] == '−') {
+		return number, fmt.Errorf("%w: negative price '-%s'", ErrInvalidNumber, number)
+	}
+	if end+1 < len(runes) && unicode.IsLetter(runes[end]) && unicode.IsDigit(runes[end+1]) {
+		return number, fmt.Errorf("%w: '%s' is followed by more digits", ErrInvalidNumber, number)
+	}
+	return number, nil
+}
+
+func isGroupSeparator(r rune) bool {
+	return r == ' ' || r == '\u00a0' || r == '\u202f' || r == '\'' || r == '’'
+}
+
+// toMinorUnits converts a number as returned by firstNumber to minor units
+// with exponent decimals using the locale's decimal separator, or inferring it
+// if there is none. A leading separator, as in ".99", is always the decimal
+// separator.
+func (pp *PriceParser) toMinorUnits(number string, exponent int) (int, error) {
+	decimal := pp.locale.decimal
+	leading := rune(number[0])
+	if leading == '.' || leading == ',' {
+		if decimal != 0 && decimal != leading {
+			return 0, fmt.Errorf("%w: '%s' starts with '%c', expected the decimal separator '%c'", ErrInvalidNumber, number, leading, decimal)
+		}
+		decimal = leading
+	} else if decimal == 0 {
+		decimal = inferDecimalSeparator(number, exponent)
+	}
+
+	integerPart, fraction := number, ""
+	if decimal != 0 {
+		if i := strings.LastIndexByte(number, byte(decimal)); i != -1 {
+			integerPart, fraction = number[:i], number[i+1:]
+		}
+	}
+
+	if len(fraction) > exponent {
+		return 0, fmt.Errorf("%w: too many decimals in '%s'", ErrInvalidNumber, number)
+	}
+	if decimal != 0 && strings.ContainsRune(integerPart, decimal) {
+		return 0, fmt.Errorf("%w: repeated decimal separator in '%s'", ErrInvalidNumber, number)
+	}
+
+	// Thousands separators must split the number into groups of three digits.
+	// A shorter last group is most likely the decimals of another locale.
+	groups := strings.FieldsFunc(integerPart, func(r rune) bool { return !unicode.IsDigit(r) })
+	if last := len(groups) - 1; last > 0 && len(groups[last]) < 3 {
+		return 0, fmt.Errorf("%w: '%s' has %d digits after a thousands separator, expected the decimal separator '%c'", ErrInvalidNumber, number, len(groups[last]), decimal)
+	}
+	for i, group := range groups {
+		if (i > 0 && len(group) != 3) || (i == 0 && len(groups) > 1 && len(group) > 3) {
+			return 0, fmt.Errorf("%w: misplaced thousands separator in '%s'", ErrInvalidNumber, number)
+		}
+	}
+
+	units, err := strconv.Atoi("0" + strings.Join(groups, ""))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidNumber, err)
+	}
+	minor := 0
+	if exponent > 0 {
+		minor, err = strconv.Atoi((fraction + strings.Repeat("0", exponent))[:exponent])
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrInvalidNumber, err)
+		}
+	}
+	return units*int(math.Pow10(exponent)) + minor, nil
+}
+
+// inferDecimalSeparator guesses the decimal separator of a number without a
+// locale: the last "." or "," is the decimal separator when followed by one or
+// two digits, or up to exponent digits for currencies such as KWD, otherwise
+// all separators are thousands separators
+func inferDecimalSeparator(number string, exponent int) rune {
+	i := strings.LastIndexAny(number, ".,")
+	if i == -1 || len(number)-i-1 > max(exponent, 2) {
+		return 0
+	}
+	return rune(number[i])
+}
+
+// detectCurrency returns the ISO code of the first currency symbol or code in
+// text, falling back to the default currency
+func (pp *PriceParser) detectCurrency(text string) string {
+	best, bestToken, bestIndex := "", "", -1
+	for _, c := range currencyTokens {
+		i := indexToken(text, c.token)
+		if i == -1 {
+			continue
+		}
+		if bestIndex == -1 || i < bestIndex || (i == bestIndex && len(c.token) > len(bestToken)) {
+			best, bestToken, bestIndex = c.currency, c.token, i
+		}
+	}
+
+	switch {
+	case bestIndex == -1:
+		return pp.defaultCurrency
+	case bestToken == "$":
+		return pp.dollarCurrency()
+	case best == "":
+		return pp.kronaCurrency()
+	}
+	return best
+}
+
+// dollarCurrency resolves "$" to the configured currency when it is a dollar,
+// or otherwise to USD
+func (pp *PriceParser) dollarCurrency() string {
+	switch pp.defaultCurrency {
+	case "USD", "CAD", "AUD", "NZD", "SGD", "HKD", "MXN":
+		return pp.defaultCurrency
+	}
+	return "USD"
+}
+
+// kronaCurrency resolves "kr" to the krona of the locale or the configured currency
+func (pp *PriceParser) kronaCurrency() string {
+	if pp.locale.krona != "" {
+		return pp.locale.krona
+	}
+	switch pp.defaultCurrency {
+	case "SEK", "NOK", "DKK", "ISK":
+		return pp.defaultCurrency
+	}
+	return "SEK"
+}
+
+// indexToken finds token in text, requiring alphabetic tokens such as "kr" or
+// "EUR" to not be part of a longer word
+func indexToken(text, token string) int {
+	offset := 0
+	for {
+		i := strings.Index(text[offset:], token)
+		if i == -1 {
+			return -1
+		}
+		i += offset
+		end := i + len(token)
+
+		if !isLetter(token[0]) || ((i == 0 || !isLetterBefore(text, i)) && (end == len(text) || !isLetterAfter(text, end))) {
+			return i
+		}
+		offset = end
+	}
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isLetterBefore(text string, i int) bool {
+	r := []rune(text[:i])
+	return unicode.IsLetter(r[len(r)-1])
+}
+
+func isLetterAfter(text string, i int) bool {
+	for _, r := range text[i:] {
+		return unicode.IsLetter(r)
+	}
+	return false
+}
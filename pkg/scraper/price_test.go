@@ -0,0 +1,100 @@
+package scraper
+
+import (
+	"errors"
+	"shopscraper/pkg/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceParserParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   config.ScraperConfig
+		input    string
+		expected Price
+		err      error
+	}{
+		{"inferred decimal comma", config.ScraperConfig{}, "1.499,95 €", Price{149995, "EUR"}, nil},
+		{"inferred decimal point", config.ScraperConfig{}, "$1,299.99", Price{129999, "USD"}, nil},
+		{"inferred thousands point", config.ScraperConfig{}, "1.499 €", Price{149900, "EUR"}, nil},
+		{"dash instead of decimals", config.ScraperConfig{}, "1499,- €", Price{149900, "EUR"}, nil},
+		{"german locale", config.ScraperConfig{PriceLocale: "de-DE"}, "ab 1.499,00 €", Price{149900, "EUR"}, nil},
+		{"english locale", config.ScraperConfig{PriceLocale: "en"}, "from £1,499", Price{149900, "GBP"}, nil},
+		{"swedish kronor", config.ScraperConfig{PriceLocale: "sv"}, "1 499,50 kr", Price{149950, "SEK"}, nil},
+		{"norwegian kroner", config.ScraperConfig{PriceLocale: "nb-NO"}, "Fra 2 990,- kr", Price{299000, "NOK"}, nil},
+		{"swiss francs", config.ScraperConfig{PriceLocale: "de-CH"}, "CHF 1'499.90", Price{149990, "CHF"}, nil},
+		{"range uses lower bound", config.ScraperConfig{}, "10–20 €", Price{1000, "EUR"}, nil},
+		{"sale with two prices", config.ScraperConfig{PriceFormat: "double_eur"}, "1 499,00EUR 2 500,00EUR", Price{149900, "EUR"}, nil},
+		{"dollar of a canadian shop", config.ScraperConfig{Currency: "CAD", PriceLocale: "en"}, "$19.99", Price{1999, "CAD"}, nil},
+		{"dollar of an australian shop", config.ScraperConfig{Currency: "AUD"}, "A$ 19.99", Price{1999, "AUD"}, nil},
+		{"US dollar in a canadian shop", config.ScraperConfig{Currency: "CAD"}, "US$19.99", Price{1999, "USD"}, nil},
+		{"dollar of a euro shop", config.ScraperConfig{Currency: "EUR"}, "$19.99", Price{1999, "USD"}, nil},
+		{"canadian dollar symbol", config.ScraperConfig{}, "C$19.99", Price{1999, "CAD"}, nil},
+		{"yen without decimals", config.ScraperConfig{Currency: "JPY", PriceLocale: "en"}, "¥1,500", Price{1500, "JPY"}, nil},
+		{"yen sign", config.ScraperConfig{}, "1.500円", Price{1500, "JPY"}, nil},
+		{"won sign", config.ScraperConfig{PriceLocale: "en"}, "₩15,000", Price{15000, "KRW"}, nil},
+		{"dinar with three decimals", config.ScraperConfig{Currency: "KWD"}, "1.250", Price{1250, "KWD"}, nil},
+		{"yen with decimals", config.ScraperConfig{Currency: "JPY", PriceLocale: "en"}, "¥1,500.50", Price{}, ErrInvalidNumber},
+		{"currency after number", config.ScraperConfig{Currency: "USD"}, "19.99", Price{1999, "USD"}, nil},
+		{"kr inside a word is not a currency", config.ScraperConfig{}, "Skruvdragare 199", Price{19900, "EUR"}, nil},
+		{"pattern with named groups", config.ScraperConfig{PricePattern: `Now (?P<price>[\d.,]+) (?P<currency>\w+)`}, "Was 25.00 EUR Now 19.99 USD", Price{1999, "USD"}, nil},
+		{"pattern with a group", config.ScraperConfig{PricePattern: `Now: ([\d.,]+)`}, "Was: 25.00 Now: 19.99 €", Price{1999, "EUR"}, nil},
+		{"pattern mismatch", config.ScraperConfig{PricePattern: `Now: ([\d.,]+)`}, "25.00 €", Price{}, ErrPatternMismatch},
+		{"no digits", config.ScraperConfig{}, "Sold out", Price{}, ErrNoPrice},
+		{"wrong locale", config.ScraperConfig{PriceLocale: "en"}, "1.499,00 €", Price{}, ErrInvalidNumber},
+		{"misplaced thousands separator", config.ScraperConfig{PriceLocale: "de"}, "14.99", Price{}, ErrInvalidNumber},
+		{"letters inside number", config.ScraperConfig{}, "1e999,00€", Price{}, ErrInvalidNumber},
+		{"leading decimal point", config.ScraperConfig{}, ".99 €", Price{99, "EUR"}, nil},
+		{"leading decimal comma", config.ScraperConfig{PriceLocale: "de"}, "nur ,99 €", Price{99, "EUR"}, nil},
+		{"leading thousands separator", config.ScraperConfig{PriceLocale: "de"}, ".99 €", Price{}, ErrInvalidNumber},
+		{"leading point after a letter", config.ScraperConfig{}, "Nr.5", Price{500, "EUR"}, nil},
+		{"negative number", config.ScraperConfig{}, "-5 €", Price{}, ErrInvalidNumber},
+		{"decimal point in german locale", config.ScraperConfig{PriceLocale: "de"}, "0.5", Price{}, ErrInvalidNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := NewPriceParser(tt.config)
+			if err != nil {
+				t.Fatalf("Unexpected error creating parser: %v", err)
+			}
+
+			result, err := parser.Parse(tt.input)
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "Expected error %v, got %v", tt.err, err)
+				var priceErr *PriceError
+				if assert.True(t, errors.As(err, &priceErr), "Expected a PriceError") {
+					assert.Equal(t, tt.input, priceErr.Input)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestNewPriceParserInvalidConfig(t *testing.T) {
+	_, err := NewPriceParser(config.ScraperConfig{PriceLocale: "xx"})
+	assert.Error(t, err)
+
+	_, err = NewPriceParser(config.ScraperConfig{PricePattern: "(unclosed"})
+	assert.Error(t, err)
+}
+
+func TestPriceParserErrors(t *testing.T) {
+	parser, err := NewPriceParser(config.ScraperConfig{PriceLocale: "de"})
+	if err != nil {
+		t.Fatalf("Unexpected error creating parser: %v", err)
+	}
+
+	// Test case 1: Decimals of another locale name the expected separator
+	_, err = parser.Parse("0.5")
+	assert.EqualError(t, err, "unable to parse price '0.5': invalid number format: '0.5' has 1 digits after a thousands separator, expected the decimal separator ','")
+
+	// Test case 2: Negative prices are rejected rather than the sign dropped
+	_, err = parser.Parse("-5,00 €")
+	assert.EqualError(t, err, "unable to parse price '-5,00 €': invalid number format: negative price '-5,00'")
+}
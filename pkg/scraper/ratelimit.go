@@ -0,0 +1,165 @@
+package scraper
+
+import (
+	"context"
+	"net/url"
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/utils"
+	"sync"
+	"time"
+)
+
+// HostLimiter spaces out requests per host, so scrapers sharing a host share
+// its limit
+type HostLimiter struct {
+	mu    sync.Mutex
+	hosts map[string]*hostState
+	now   func() time.Time
+}
+
+type hostState struct {
+	// next is when the request after the burst would be allowed at the
+	// sustained rate
+	next time.Time
+	// last is when the latest request was allowed
+	last time.Time
+}
+
+func NewHostLimiter() *HostLimiter {
+	return &HostLimiter{hosts: make(map[string]*hostState), now: time.Now}
+}
+
+// Wait blocks until a request to the host of rawURL is allowed by limit, or
+// returns the context's error when ctx is done first
+func (l *HostLimiter) Wait(ctx context.Context, rawURL string, limit config.RateLimitConfig) error {
+	delay := l.reserve(hostOf(rawURL), limit)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	if !utils.SleepContext(ctx, delay) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// reserve takes the next slot for a request to host and returns how long to
+// wait for it. Slots follow the sustained rate, allowing burst requests ahead
+// of it, and are at least MinDelay apart.
+func (l *HostLimiter) reserve(host string, limit config.RateLimitConfig) time.Duration {
+	if limit.RequestsPerSecond <= 0 && limit.MinDelay <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	state, ok := l.hosts[host]
+	if !ok {
+		state = &hostState{}
+		l.hosts[host] = state
+	}
+
+	allowed := now
+	if limit.RequestsPerSecond > 0 {
+		interval := time.Duration(float64(time.Second) / limit.RequestsPerSecond)
+		burst := max(limit.Burst, 1)
+
+		next := state.next
+		if next.Before(now) {
+			next = now
+		}
+		if earliest := next.Add(-time.Duration(burst-1) * interval); earliest.After(allowed) {
+			allowed = earliest
+		}
+		state.next = next.Add(interval)
+	}
+	if !state.last.IsZero() {
+		if earliest := state.last.Add(limit.MinDelay); earliest.After(allowed) {
+			allowed = earliest
+		}
+	}
+	state.last = allowed
+
+	return allowed.Sub(now)
+}
+
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	return parsed.Host
+}
+
+// Shared holds the state shared by all scrapers of a process
+type Shared struct {
+	Limiter *HostLimiter
+	// RateLimit applies to scrapers that don't configure their own
+	RateLimit config.RateLimitConfig
+	// Robots is nil unless robots.txt is honoured
+	Robots *RobotsCache
+	// RobotsUserAgent is the configured robots.txt user agent, which is used
+	// instead of the user agent of each scraper's requests
+	RobotsUserAgent string
+	// HTTP applies to scrapers that don't configure their own
+	HTTP       config.HTTPConfig
+	Transports *Transports
+	// Retry applies to scrapers that don't configure their own
+	Retry config.RetryConfig
+	// Browser is shared by the JavaScript scrapers
+	Browser *BrowserPool
+	// Details keeps the product details between runs, filled with those
+	// stored in the database when the scrapers start
+	Details *DetailCache
+}
+
+// NewShared creates the shared state for the scrapers of the configuration
+func NewShared(programConfig config.ProgramConfig) *Shared {
+	shared := &Shared{
+		Limiter:    NewHostLimiter(),
+		RateLimit:  programConfig.RateLimit,
+		HTTP:       programConfig.HTTP,
+		Transports: NewTransports(),
+		Retry:      programConfig.Retry,
+		Browser:    NewBrowserPool(programConfig.Browser),
+		Details:    NewDetailCache(),
+	}
+	if programConfig.Robots.Enabled {
+		// Without a user agent of its own robots.txt is honoured for the one
+		// the requests are made with
+		shared.RobotsUserAgent = programConfig.Robots.UserAgent
+		userAgent := programConfig.Robots.UserAgent
+		if userAgent == "" {
+			userAgent = programConfig.HTTP.UserAgent
+		}
+		shared.Robots = NewRobotsCache(userAgent)
+		// Fetch robots.txt through the global proxy and TLS options, an
+		// invalid configuration is reported when creating the scrapers
+		if transport, err := shared.Transports.get(programConfig.HTTP); err == nil {
+			shared.Robots.client.Transport = transport
+		}
+	}
+	return shared
+}
+
+// Close releases the resources of the shared state, such as the browser
+func (s *Shared) Close() {
+	if s.Browser != nil {
+		s.Browser.Close()
+	}
+}
+
+// waitForHost waits until the rate limit of the scraper, and the Crawl-delay
+// of the host's robots.txt when it is honoured, allow a request to
+// currentURL. Scrapers created without shared state are not limited.
+func (bs *BaseScraper) waitForHost(ctx context.Context, currentURL string) error {
+	if bs.Shared == nil || bs.Shared.Limiter == nil {
+		return ctx.Err()
+	}
+	limit := bs.Config.RateLimit.Merge(bs.Shared.RateLimit)
+	if bs.Shared.Robots != nil {
+		limit.MinDelay = max(limit.MinDelay, bs.Shared.Robots.CrawlDelay(currentURL, bs.robotsUserAgent()))
+	}
+	return bs.Shared.Limiter.Wait(ctx, currentURL, limit)
+}
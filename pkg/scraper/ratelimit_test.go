@@ -0,0 +1,138 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shopscraper/pkg/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterReserve(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	l := NewHostLimiter()
+	l.now = func() time.Time { return now }
+
+	// Test case 1: Requests beyond the burst wait for the rate
+	limit := config.RateLimitConfig{RequestsPerSecond: 2, Burst: 3}
+	expected := []time.Duration{0, 0, 0, 500 * time.Millisecond, time.Second}
+	for i, want := range expected {
+		if got := l.reserve("shop.example.com", limit); got != want {
+			t.Errorf("Request %d: expected a delay of %s, but got %s", i+1, want, got)
+		}
+	}
+
+	// Test case 2: Other hosts are not affected
+	if got := l.reserve("other.example.com", limit); got != 0 {
+		t.Errorf("Expected no delay for another host, but got %s", got)
+	}
+
+	// Test case 3: The burst is available again after an idle period
+	now = start.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		if got := l.reserve("shop.example.com", limit); got != 0 {
+			t.Errorf("Expected no delay after being idle, but got %s", got)
+		}
+	}
+
+	// Test case 4: The minimum delay spaces out requests even within the burst
+	limit = config.RateLimitConfig{MinDelay: 2 * time.Second}
+	expected = []time.Duration{0, 2 * time.Second, 4 * time.Second}
+	for i, want := range expected {
+		if got := l.reserve("slow.example.com", limit); got != want {
+			t.Errorf("Request %d: expected a delay of %s, but got %s", i+1, want, got)
+		}
+	}
+
+	// Test case 5: No limit configured
+	for i := 0; i < 3; i++ {
+		if got := l.reserve("fast.example.com", config.RateLimitConfig{}); got != 0 {
+			t.Errorf("Expected no delay without a limit, but got %s", got)
+		}
+	}
+}
+
+func TestHostLimiterWaitCancelled(t *testing.T) {
+	l := NewHostLimiter()
+	limit := config.RateLimitConfig{MinDelay: time.Hour}
+	if err := l.Wait(context.Background(), "https://shop.example.com/page1", limit); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := l.Wait(ctx, "https://shop.example.com/page2", limit)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, but got %v", err)
+	}
+}
+
+func TestRateLimitConfigMerge(t *testing.T) {
+	global := config.RateLimitConfig{RequestsPerSecond: 1, Burst: 2, MinDelay: time.Second}
+	merged := config.RateLimitConfig{RequestsPerSecond: 0.5}.Merge(global)
+	expected := config.RateLimitConfig{RequestsPerSecond: 0.5, Burst: 2, MinDelay: time.Second}
+	if merged != expected {
+		t.Errorf("Expected %+v, but got %+v", expected, merged)
+	}
+
+	// Negative values switch off the global limit
+	merged = config.RateLimitConfig{RequestsPerSecond: -1, MinDelay: -1}.Merge(global)
+	expected = config.RateLimitConfig{RequestsPerSecond: -1, Burst: 2, MinDelay: -1}
+	if merged != expected {
+		t.Errorf("Expected %+v, but got %+v", expected, merged)
+	}
+	limiter := NewHostLimiter()
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := limiter.Wait(context.Background(), "https://example.com/", merged); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected no waiting with the limit switched off, but took %v", elapsed)
+	}
+}
+
+func TestSharedRateLimitAcrossScrapers(t *testing.T) {
+	var mu sync.Mutex
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, time.Now())
+		mu.Unlock()
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	// Two scrapers of the same host share its minimum delay
+	shared := NewShared(config.ProgramConfig{RateLimit: config.RateLimitConfig{MinDelay: 50 * time.Millisecond}})
+	scrapers := []*WebShopScraper{
+		NewWebShopScraper(config.ScraperConfig{ShopName: "Shop 1"}, shared),
+		NewWebShopScraper(config.ScraperConfig{ShopName: "Shop 2"}, shared),
+	}
+
+	var wg sync.WaitGroup
+	for _, ws := range scrapers {
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func(ws *WebShopScraper) {
+				defer wg.Done()
+				if _, err := ws.GetHTML(context.Background(), server.URL); err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			}(ws)
+		}
+	}
+	wg.Wait()
+
+	if len(requests) != 4 {
+		t.Fatalf("Expected 4 requests, but got %d", len(requests))
+	}
+	if elapsed := requests[3].Sub(requests[0]); elapsed < 140*time.Millisecond {
+		t.Errorf("Expected the requests to be spread over at least 150ms, but they took %s", elapsed)
+	}
+}
@@ -0,0 +1,49 @@
+package scraper
+
+import (
+	"context"
+	"sync"
+)
+
+// SkippedURL is a URL a scraper didn't fetch and why
+type SkippedURL struct {
+	Shop   string
+	URL    string
+	Reason string
+}
+
+// Report collects what happened during a run that isn't a scraped product,
+// so it can be logged once the run is done
+type Report struct {
+	mu      sync.Mutex
+	skipped []SkippedURL
+}
+
+type reportKey struct{}
+
+// WithReport returns a context that scrapers record their run report into
+func WithReport(ctx context.Context, report *Report) context.Context {
+	return context.WithValue(ctx, reportKey{}, report)
+}
+
+// reportFrom returns the report of ctx, or nil when there is none
+func reportFrom(ctx context.Context) *Report {
+	report, _ := ctx.Value(reportKey{}).(*Report)
+	return report
+}
+
+func (r *Report) skip(shop, url, reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped = append(r.skipped, SkippedURL{Shop: shop, URL: url, Reason: reason})
+}
+
+// SkippedURLs returns the URLs skipped so far
+func (r *Report) SkippedURLs() []SkippedURL {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SkippedURL(nil), r.skipped...)
+}
@@ -0,0 +1,139 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"shopscraper/pkg/config"
+	"shopscraper/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts    = 5
+	DefaultInitialBackoff = 1 * time.Second
+	DefaultMaxBackoff     = 1 * time.Minute
+)
+
+// RetryableError is returned by an HTMLGetter when fetching the page may
+// succeed when retried, such as after a network error, a 429 or a 5xx
+type RetryableError struct {
+	Err error
+	// RetryAfter is how long the server asked to wait before retrying
+	RetryAfter time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// RetryPolicy retries failed page fetches with exponential backoff
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// NewRetryPolicy creates the retry policy of the configuration, with defaults
+// for unset fields
+func NewRetryPolicy(retryConfig config.RetryConfig) RetryPolicy {
+	retryConfig = retryConfig.Merge(config.RetryConfig{
+		MaxAttempts:    DefaultMaxAttempts,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+	})
+	return RetryPolicy{
+		MaxAttempts:    max(retryConfig.MaxAttempts, 1),
+		InitialBackoff: retryConfig.InitialBackoff,
+		MaxBackoff:     max(retryConfig.MaxBackoff, retryConfig.InitialBackoff),
+	}
+}
+
+// Backoff returns the delay before the given retry, the first being 1. The
+// delay doubles with every retry up to MaxBackoff and is randomized between
+// half and all of it, so scrapers failing together don't retry together.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := p.MaxBackoff
+	if shift := retry - 1; shift >= 0 && shift < 32 {
+		if d := p.InitialBackoff << shift; d > 0 && d < p.MaxBackoff {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Do calls fetch until it succeeds, fails with an error that isn't a
+// RetryableError or MaxAttempts is reached. A Retry-After longer than the
+// backoff is waited for, but not one longer than MaxBackoff.
+func (p RetryPolicy) Do(ctx context.Context, currentURL string, fetch func(ctx context.Context) (string, error)) (string, error) {
+	for attempt := 1; ; attempt++ {
+		content, err := fetch(ctx)
+		var retryable *RetryableError
+		if err == nil || !errors.As(err, &retryable) || ctx.Err() != nil {
+			return content, err
+		}
+		if attempt >= p.MaxAttempts {
+			return "", fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		if retryable.RetryAfter > p.MaxBackoff {
+			return "", fmt.Errorf("not retrying as the server asked to wait %s: %w", retryable.RetryAfter, err)
+		}
+
+		delay := max(p.Backoff(attempt), retryable.RetryAfter)
+		log.Printf("Retrying %s in %s after attempt %d failed: %v", currentURL, delay.Round(time.Millisecond), attempt, err)
+		if !utils.SleepContext(ctx, delay) {
+			return "", ctx.Err()
+		}
+	}
+}
+
+// retryStatus reports whether a response with statusCode is worth retrying
+func retryStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date, returning 0 when it is missing or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
+
+// retryPolicy returns the retry policy of the scraper merged with the global
+// one
+func (bs *BaseScraper) retryPolicy() RetryPolicy {
+	if bs.Shared == nil {
+		return NewRetryPolicy(bs.Config.Retry)
+	}
+	return NewRetryPolicy(bs.Config.Retry.Merge(bs.Shared.Retry))
+}
+
+// getHTML fetches currentURL with the HTMLGetter of the scraper, retrying
+// according to its retry policy
+func (bs *BaseScraper) getHTML(ctx context.Context, currentURL string) (string, error) {
+	return bs.retryPolicy().Do(ctx, currentURL, func(ctx context.Context) (string, error) {
+		return bs.GetHTML(ctx, currentURL)
+	})
+}
@@ -0,0 +1,124 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shopscraper/pkg/config"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := NewRetryPolicy(config.RetryConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second})
+	assert.Equal(t, DefaultMaxAttempts, p.MaxAttempts)
+
+	// The backoff doubles up to the maximum and is between half and all of it
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		for j := 0; j < 20; j++ {
+			got := p.Backoff(i + 1)
+			if got < want/2 || got > want {
+				t.Errorf("Retry %d: expected a backoff between %s and %s, but got %s", i+1, want/2, want, got)
+			}
+		}
+	}
+	if got := p.Backoff(100); got > 5*time.Second {
+		t.Errorf("Expected the backoff to be capped, but got %s", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Fri, 01 Mar 2024 12:00:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Fri, 01 Mar 2024 11:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	var requests atomic.Int32
+	var failures int32
+	var status int
+	var retryAfter string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	retry := config.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	ws := NewWebShopScraper(config.ScraperConfig{Retry: retry}, nil)
+	ctx := context.Background()
+
+	// Test case 1: Server errors are retried until the fetch succeeds
+	requests.Store(0)
+	failures, status = 2, http.StatusServiceUnavailable
+	if _, err := ws.getHTML(ctx, server.URL); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, int32(3), requests.Load())
+
+	// Test case 2: The fetch fails after the maximum number of attempts
+	requests.Store(0)
+	failures, status = 5, http.StatusTooManyRequests
+	_, err := ws.getHTML(ctx, server.URL)
+	var retryable *RetryableError
+	if !errors.As(err, &retryable) {
+		t.Errorf("Expected a RetryableError, but got %v", err)
+	}
+	assert.Equal(t, int32(3), requests.Load())
+
+	// Test case 3: Client errors are not retried
+	requests.Store(0)
+	failures, status = 1, http.StatusNotFound
+	if _, err := ws.getHTML(ctx, server.URL); err == nil {
+		t.Errorf("Expected an error for status code 404")
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	// Test case 4: A Retry-After longer than the maximum backoff is not waited for
+	requests.Store(0)
+	failures, status, retryAfter = 1, http.StatusTooManyRequests, "3600"
+	if _, err := ws.getHTML(ctx, server.URL); err == nil {
+		t.Errorf("Expected an error for a Retry-After of an hour")
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	// Test case 5: A shorter Retry-After is waited for
+	requests.Store(0)
+	ws = NewWebShopScraper(config.ScraperConfig{Retry: config.RetryConfig{InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Second}}, nil)
+	failures, status, retryAfter = 1, http.StatusTooManyRequests, "1"
+	start := time.Now()
+	if _, err := ws.getHTML(ctx, server.URL); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected to wait for Retry-After, but retried after %s", elapsed)
+	}
+}
+
+func TestRetryPolicyDoCancelled(t *testing.T) {
+	p := NewRetryPolicy(config.RetryConfig{InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	_, err := p.Do(ctx, "http://example.com", func(ctx context.Context) (string, error) {
+		attempts++
+		return "", &RetryableError{Err: errors.New("connection reset")}
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, but got %v", err)
+	}
+	assert.Equal(t, 1, attempts)
+}
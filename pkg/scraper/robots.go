@@ -0,0 +1,358 @@
+package scraper
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRobotsUserAgent is the user agent matched against robots.txt
+	// groups when none is configured
+	DefaultRobotsUserAgent = "shopscraper"
+	// robotsCacheDuration is how long a fetched robots.txt is used
+	robotsCacheDuration = 24 * time.Hour
+	// robotsErrorCacheDuration is how long a host whose robots.txt could not
+	// be fetched is treated as disallowing everything before trying again
+	robotsErrorCacheDuration = 10 * time.Minute
+	// robotsMaxSize is the maximum size of robots.txt that is parsed
+	robotsMaxSize = 500 * 1024
+)
+
+// RobotsCache fetches and caches the robots.txt of each host and decides
+// whether a URL may be scraped by a user agent. The rules are cached per host
+// and product token, as scrapers may send different user agents.
+type RobotsCache struct {
+	// UserAgent is used when no user agent is given. It requests robots.txt
+	// and its product token selects the groups.
+	UserAgent string
+
+	mu     sync.Mutex
+	hosts  map[string]*robotsEntry
+	client *http.Client
+	now    func() time.Time
+}
+
+type robotsEntry struct {
+	// ready is closed once the robots.txt has been fetched, rules is nil when
+	// the fetch was cancelled
+	ready   chan struct{}
+	rules   *robotsRules
+	expires time.Time
+}
+
+func NewRobotsCache(userAgent string) *RobotsCache {
+	if userAgent == "" {
+		userAgent = DefaultRobotsUserAgent
+	}
+	return &RobotsCache{
+		UserAgent: userAgent,
+		hosts:     make(map[string]*robotsEntry),
+		client:    &http.Client{Timeout: 30 * time.Second},
+		now:       time.Now,
+	}
+}
+
+// Allowed reports whether robots.txt allows userAgent, or UserAgent when
+// empty, to fetch rawURL, and if not the reason. The robots.txt of the host
+// is fetched on first use.
+func (c *RobotsCache) Allowed(ctx context.Context, rawURL, userAgent string) (bool, string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return false, "", err
+	}
+	rules, err := c.rules(ctx, target, c.userAgent(userAgent))
+	if err != nil {
+		return false, "", err
+	}
+	if rules.disallowAll != "" {
+		return false, rules.disallowAll, nil
+	}
+
+	path := target.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if target.RawQuery != "" {
+		path += "?" + target.RawQuery
+	}
+	if rule, allowed := rules.match(path); !allowed {
+		return false, fmt.Sprintf("disallowed by robots.txt rule 'Disallow: %s'", rule), nil
+	}
+	return true, "", nil
+}
+
+// CrawlDelay returns the Crawl-delay robots.txt sets for userAgent, or
+// UserAgent when empty, on the host of rawURL, if its robots.txt has been
+// fetched
+func (c *RobotsCache) CrawlDelay(rawURL, userAgent string) time.Duration {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	entry, ok := c.hosts[robotsKey(target, c.userAgent(userAgent))]
+	c.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	select {
+	case <-entry.ready:
+		if entry.rules == nil {
+			return 0
+		}
+		return entry.rules.crawlDelay
+	default:
+		return 0
+	}
+}
+
+func (c *RobotsCache) userAgent(userAgent string) string {
+	if userAgent == "" {
+		return c.UserAgent
+	}
+	return userAgent
+}
+
+// robotsKey identifies the cached rules of the host of target for the product
+// token of userAgent
+func robotsKey(target *url.URL, userAgent string) string {
+	return target.Scheme + "://" + target.Host + " " + robotsToken(userAgent)
+}
+
+// rules returns the cached rules for the host of target, fetching them when
+// missing or expired. Concurrent callers wait for a single fetch, and fetch
+// again themselves when it was cancelled.
+func (c *RobotsCache) rules(ctx context.Context, target *url.URL, userAgent string) (*robotsRules, error) {
+	key := robotsKey(target, userAgent)
+
+	for {
+		c.mu.Lock()
+		entry, ok := c.hosts[key]
+		if ok {
+			select {
+			case <-entry.ready:
+				if c.now().After(entry.expires) {
+					ok = false
+				}
+			default:
+			}
+		}
+		if !ok {
+			entry = &robotsEntry{ready: make(chan struct{})}
+			c.hosts[key] = entry
+			c.mu.Unlock()
+
+			rules, expires := c.fetch(ctx, target.Scheme+"://"+target.Host, userAgent)
+			// A cancelled fetch says nothing about the host, so its rules are
+			// not published and the next caller fetches again
+			if ctx.Err() != nil {
+				c.mu.Lock()
+				if c.hosts[key] == entry {
+					delete(c.hosts, key)
+				}
+				c.mu.Unlock()
+				close(entry.ready)
+				return nil, ctx.Err()
+			}
+			entry.rules, entry.expires = rules, expires
+			close(entry.ready)
+			return entry.rules, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-entry.ready:
+			if entry.rules != nil {
+				return entry.rules, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// fetch downloads and parses robots.txt. Following RFC 9309 a missing
+// robots.txt allows everything, while a server or network error disallows
+// everything until it can be fetched.
+func (c *RobotsCache) fetch(ctx context.Context, base, userAgent string) (*robotsRules, time.Time) {
+	now := c.now()
+	failed := func(reason string) (*robotsRules, time.Time) {
+		return &robotsRules{disallowAll: reason}, now.Add(robotsErrorCacheDuration)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/robots.txt", nil)
+	if err != nil {
+		return failed(fmt.Sprintf("robots.txt could not be fetched: %v", err))
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return failed(fmt.Sprintf("robots.txt could not be fetched: %v", err))
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return failed(fmt.Sprintf("robots.txt could not be fetched: status code %d", resp.StatusCode))
+	case resp.StatusCode >= 400:
+		return &robotsRules{}, now.Add(robotsCacheDuration)
+	case resp.StatusCode != http.StatusOK:
+		return failed(fmt.Sprintf("robots.txt could not be fetched: status code %d", resp.StatusCode))
+	}
+
+	rules, err := parseRobots(io.LimitReader(resp.Body, robotsMaxSize), userAgent)
+	if err != nil {
+		return failed(fmt.Sprintf("robots.txt could not be read: %v", err))
+	}
+	return rules, now.Add(robotsCacheDuration)
+}
+
+// robotsRules are the rules of a robots.txt that apply to a user agent
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	// disallowAll is the reason everything is disallowed, if it is
+	disallowAll string
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// parseRobots reads the groups of a robots.txt that apply to userAgent. Groups
+// naming the product token of the user agent are used, and the "*" group when
+// none does. As in RFC 9309 the whole token is matched, ignoring case.
+func parseRobots(r io.Reader, userAgent string) (*robotsRules, error) {
+	token := robotsToken(userAgent)
+
+	var specific, wildcard robotsRules
+	var matchesSpecific, matchesWildcard, foundSpecific bool
+	// A group starts with one or more user-agent lines
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		field, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.TrimSpace(value)
+
+		if field == "user-agent" {
+			if !inAgents {
+				matchesSpecific, matchesWildcard = false, false
+				inAgents = true
+			}
+			agent := strings.ToLower(value)
+			if agent == "*" {
+				matchesWildcard = true
+			} else if agent == token {
+				matchesSpecific = true
+				foundSpecific = true
+			}
+			continue
+		}
+		inAgents = false
+
+		var targets []*robotsRules
+		if matchesSpecific {
+			targets = append(targets, &specific)
+		}
+		if matchesWildcard {
+			targets = append(targets, &wildcard)
+		}
+		for _, target := range targets {
+			switch field {
+			case "allow", "disallow":
+				if value != "" {
+					target.rules = append(target.rules, robotsRule{allow: field == "allow", pattern: value})
+				}
+			case "crawl-delay":
+				if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+					target.crawlDelay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if foundSpecific {
+		return &specific, nil
+	}
+	return &wildcard, nil
+}
+
+// robotsToken returns the product token of a user agent, e.g. acmebot for
+// "AcmeBot/1.0" and for "Mozilla/5.0 (compatible; AcmeBot/1.0; +https://...)"
+func robotsToken(userAgent string) string {
+	token := strings.ToLower(userAgent)
+	if _, comment, found := strings.Cut(token, "(compatible;"); found {
+		token = comment
+	}
+	fields := strings.FieldsFunc(token, func(r rune) bool {
+		return r == '/' || r == ';' || r == ')' || r == ' '
+	})
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// match returns whether path is allowed and the rule deciding it. The rule
+// with the longest pattern wins, and allow wins a tie.
+func (r *robotsRules) match(path string) (string, bool) {
+	best := -1
+	allowed := true
+	pattern := ""
+	for _, rule := range r.rules {
+		if !robotsPatternMatches(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > best || (len(rule.pattern) == best && rule.allow) {
+			best = len(rule.pattern)
+			allowed = rule.allow
+			pattern = rule.pattern
+		}
+	}
+	return pattern, allowed
+}
+
+// robotsPatternMatches matches a path prefix pattern where "*" matches any
+// characters and a trailing "$" anchors the end of the path
+func robotsPatternMatches(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		last := i == len(parts)-2
+		if last && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+		index := strings.Index(rest, part)
+		if index < 0 {
+			return false
+		}
+		rest = rest[index+len(part):]
+	}
+	return !anchored || rest == ""
+}
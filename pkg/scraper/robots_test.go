@@ -0,0 +1,286 @@
+package scraper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"shopscraper/pkg/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRobots = `
+# Rules for everyone
+User-agent: *
+Disallow: /checkout
+Crawl-delay: 5
+
+User-agent: OtherBot
+User-agent: ShopScraper
+Disallow: /search
+Disallow: /*.pdf$
+Allow: /search/sale
+Crawl-delay: 1.5
+`
+
+func TestParseRobots(t *testing.T) {
+	// Test case 1: The group naming the user agent is used
+	rules, err := parseRobots(strings.NewReader(testRobots), "shopscraper/1.0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, 1500*time.Millisecond, rules.crawlDelay)
+	assert.Len(t, rules.rules, 3)
+
+	// Test case 2: Other user agents use the "*" group
+	rules, err = parseRobots(strings.NewReader(testRobots), "somebot")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, 5*time.Second, rules.crawlDelay)
+	assert.Equal(t, []robotsRule{{allow: false, pattern: "/checkout"}}, rules.rules)
+
+	// Test case 3: Groups only apply to the whole product token
+	rules, err = parseRobots(strings.NewReader("User-agent: s\nUser-agent: shopscraperbot\nDisallow: /\n"), "shopscraper")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Empty(t, rules.rules, "Expected no rules for other user agents")
+}
+
+func TestRobotsToken(t *testing.T) {
+	tests := map[string]string{
+		"shopscraper":                    "shopscraper",
+		"AcmeBot/1.0":                    "acmebot",
+		"AcmeBot (+https://example.com)": "acmebot",
+		DefaultUserAgent:                 "shopscraper",
+		"Mozilla/5.0 (compatible; AcmeBot/1.0; +https://example.com)": "acmebot",
+		"": "",
+	}
+	for userAgent, expected := range tests {
+		assert.Equal(t, expected, robotsToken(userAgent), "Unexpected token of %s", userAgent)
+	}
+
+	// The robots.txt user agent defaults to the user agent of the requests
+	shared := NewShared(config.ProgramConfig{
+		Robots: config.RobotsConfig{Enabled: true},
+		HTTP:   config.HTTPConfig{UserAgent: "AcmeBot/1.0"},
+	})
+	defer shared.Close()
+	assert.Equal(t, "AcmeBot/1.0", shared.Robots.UserAgent)
+	shared = NewShared(config.ProgramConfig{
+		Robots: config.RobotsConfig{Enabled: true, UserAgent: "OtherBot"},
+		HTTP:   config.HTTPConfig{UserAgent: "AcmeBot/1.0"},
+	})
+	defer shared.Close()
+	assert.Equal(t, "OtherBot", shared.Robots.UserAgent)
+}
+
+func TestRobotsRulesMatch(t *testing.T) {
+	rules, err := parseRobots(strings.NewReader(testRobots), "shopscraper")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/", true},
+		{"/checkout", true},
+		{"/search?q=shoes", false},
+		{"/search/sale", true},
+		{"/search/sale?page=2", true},
+		{"/files/catalog.pdf", false},
+		{"/files/catalog.pdf?download=1", true},
+	}
+	for _, test := range tests {
+		if _, allowed := rules.match(test.path); allowed != test.allowed {
+			t.Errorf("Expected %s to be allowed: %v, but got %v", test.path, test.allowed, allowed)
+		}
+	}
+}
+
+func TestRobotsCacheAllowed(t *testing.T) {
+	status := http.StatusOK
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		assert.Equal(t, "/robots.txt", r.URL.Path)
+		assert.Equal(t, "shopscraper", r.Header.Get("User-Agent"))
+		w.WriteHeader(status)
+		w.Write([]byte(testRobots))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	c := NewRobotsCache("")
+	c.now = func() time.Time { return now }
+
+	// Test case 1: Disallowed URLs are reported with the rule, robots.txt is
+	// fetched once
+	allowed, reason, err := c.Allowed(ctx, server.URL+"/search?q=shoes", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.False(t, allowed)
+	assert.Equal(t, "disallowed by robots.txt rule 'Disallow: /search'", reason)
+
+	allowed, _, err = c.Allowed(ctx, server.URL+"/products", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.True(t, allowed)
+	assert.Equal(t, 1, fetches)
+	assert.Equal(t, 1500*time.Millisecond, c.CrawlDelay(server.URL+"/products", ""))
+
+	// Test case 2: A missing robots.txt allows everything
+	status = http.StatusNotFound
+	now = now.Add(robotsCacheDuration + time.Second)
+	allowed, _, err = c.Allowed(ctx, server.URL+"/search?q=shoes", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.True(t, allowed)
+	assert.Equal(t, 2, fetches)
+
+	// Test case 3: A server error disallows everything
+	status = http.StatusInternalServerError
+	now = now.Add(robotsCacheDuration + time.Second)
+	allowed, reason, err = c.Allowed(ctx, server.URL+"/products", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.False(t, allowed)
+	assert.Equal(t, "robots.txt could not be fetched: status code 500", reason)
+}
+
+func TestRobotsCacheCancelledFetch(t *testing.T) {
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if fetches == 1 {
+			close(requested)
+			<-release
+		}
+		w.Write([]byte(testRobots))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := NewRobotsCache("")
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error)
+	go func() {
+		_, _, err := c.Allowed(ctx, server.URL+"/products", "")
+		firstErr <- err
+	}()
+	<-requested
+
+	// A caller waiting on the fetch of a cancelled caller fetches again
+	// instead of being disallowed
+	waiterAllowed := make(chan bool)
+	go func() {
+		allowed, _, err := c.Allowed(context.Background(), server.URL+"/products", "")
+		assert.NoError(t, err)
+		waiterAllowed <- allowed
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	assert.ErrorIs(t, <-firstErr, context.Canceled)
+	assert.True(t, <-waiterAllowed, "Expected the waiting caller to be allowed")
+	assert.Equal(t, 2, fetches)
+}
+
+func TestScrapeSkipsDisallowedURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+		case "/page1":
+			w.Write([]byte(`<div class="item"><div class="name">Product</div><div class="price">1,00 €</div><a class="link" href="/product">Link</a></div>
+				<a class="next" href="/private/page2">Next</a>`))
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.ScraperConfig{
+		URLs:             []string{server.URL + "/page1", server.URL + "/private/sale"},
+		ItemSelector:     ".item",
+		NameSelector:     ".name",
+		LinkSelector:     ".link",
+		NextPageSelector: ".next",
+		PriceSelector:    []string{".price"},
+		ShopName:         "Test Shop",
+	}
+	shared := NewShared(config.ProgramConfig{Robots: config.RobotsConfig{Enabled: true}})
+	report := &Report{}
+
+	products, err := NewWebShopScraper(cfg, shared).Scrape(WithReport(context.Background(), report), 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Len(t, products, 1)
+
+	// Both the configured and the discovered URL under /private are skipped
+	skipped := report.SkippedURLs()
+	assert.ElementsMatch(t, []SkippedURL{
+		{Shop: "Test Shop", URL: server.URL + "/private/sale", Reason: "disallowed by robots.txt rule 'Disallow: /private'"},
+		{Shop: "Test Shop", URL: server.URL + "/private/page2", Reason: "disallowed by robots.txt rule 'Disallow: /private'"},
+	}, skipped)
+}
+
+func TestScrapeHonoursRobotsForScraperUserAgent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: AcmeBot\nDisallow: /\n\nUser-agent: *\nAllow: /\n"))
+		case "/page1":
+			w.Write([]byte(`<div class="item"><div class="name">Product</div><div class="price">1,00 €</div><a class="link" href="/product">Link</a></div>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.ScraperConfig{
+		URLs:          []string{server.URL + "/page1"},
+		ItemSelector:  ".item",
+		NameSelector:  ".name",
+		LinkSelector:  ".link",
+		PriceSelector: []string{".price"},
+		ShopName:      "Test Shop",
+	}
+	shared := NewShared(config.ProgramConfig{Robots: config.RobotsConfig{Enabled: true}})
+
+	// Test case 1: The global user agent is allowed
+	report := &Report{}
+	products, err := NewWebShopScraper(cfg, shared).Scrape(WithReport(context.Background(), report), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Len(t, products, 1)
+	assert.Empty(t, report.SkippedURLs())
+
+	// Test case 2: The user agent of the scraper is disallowed
+	cfg.HTTP.UserAgent = "AcmeBot/1.0"
+	report = &Report{}
+	products, err = NewWebShopScraper(cfg, shared).Scrape(WithReport(context.Background(), report), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Empty(t, products)
+	assert.Equal(t, []SkippedURL{
+		{Shop: "Test Shop", URL: server.URL + "/page1", Reason: "disallowed by robots.txt rule 'Disallow: /'"},
+	}, report.SkippedURLs())
+}
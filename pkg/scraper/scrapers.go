@@ -2,47 +2,84 @@ package scraper
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"shopscraper/pkg/config"
 	"shopscraper/pkg/models"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"github.com/chromedp/chromedp"
 )
 
-const MaxRetryAttempts = 5
-
 type HTMLGetter interface {
-	GetHTML(currentURL string, attempts ...int) (string, error)
+	// GetHTML fetches the page once, returning a RetryableError when
+	// fetching it again may succeed
+	GetHTML(ctx context.Context, currentURL string) (string, error)
+}
+
+// DetailHTMLGetter is implemented by HTMLGetters that load the detail pages
+// of products differently from the list pages
+type DetailHTMLGetter interface {
+	// GetDetailHTML fetches the detail page once, returning a RetryableError
+	// when fetching it again may succeed
+	GetDetailHTML(ctx context.Context, currentURL string) (string, error)
+}
+
+// PageParser extracts products and the next page URL from fetched page content
+type PageParser interface {
+	ParseHTML(htmlContent, fetchedUrl string) ([]models.Product, string, error)
 }
 
 type Scraper interface {
-	Scrape(int) ([]models.Product, error)
+	Scrape(ctx context.Context, maxWorkers int) ([]models.Product, error)
 	ParseHTML(htmlContent, fetchedUrl string) ([]models.Product, string, error)
-	GetPrice(s *goquery.Selection) (int, error)
-	ParsePrice(itemPrice string) string
+	GetPrice(s *goquery.Selection) (Price, error)
+	ParsePrice(itemPrice string) (Price, error)
 }
 
 type BaseScraper struct {
 	HTMLGetter
+	// Parser overrides how fetched pages are parsed, defaults to ParseHTML
+	Parser PageParser
 	Config config.ScraperConfig
+	// Shared is the state shared with other scrapers, such as the per-host
+	// rate limiter
+	Shared *Shared
+
+	pricesOnce sync.Once
+	prices     *PriceParser
+	pricesErr  error
+
+	clientOnce sync.Once
+	client     *http.Client
+	clientErr  error
+
+	detailsOnce sync.Once
+	details     *DetailCache
 }
 
-func (bs *BaseScraper) Scrape(maxWorkers int) ([]models.Product, error) {
+func (bs *BaseScraper) pageParser() PageParser {
+	if bs.Parser != nil {
+		return bs.Parser
+	}
+	return bs
+}
+
+// Scrape fetches all pages of the configured URLs. When ctx is done no new
+// pages are fetched, and the products scraped so far are returned with the
+// context's error.
+func (bs *BaseScraper) Scrape(ctx context.Context, maxWorkers int) ([]models.Product, error) {
 	var products []models.Product
 	log.Println("Starting scraping of", bs.Config.ShopName)
 
 	// Create a channel to receive scraped products
 	productChan := make(chan []models.Product)
 
-	// Create a channel to limit the number of concurrent workers
-	semaphore := make(chan struct{}, maxWorkers)
+	// Limit the number of pages fetched concurrently
+	semaphore := make(workers, maxWorkers)
 
 	var wg sync.WaitGroup
 
@@ -51,27 +88,24 @@ func (bs *BaseScraper) Scrape(maxWorkers int) ([]models.Product, error) {
 		go func(url string) {
 			defer wg.Done()
 
-			// Acquire a worker from the semaphore
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-
 			currentURL := url
 
-			for {
-				log.Println("Scraping", currentURL)
-
-				htmlContent, err := bs.GetHTML(currentURL)
-				if err != nil {
-					log.Println("Error scraping", currentURL, ":", err)
+			for ctx.Err() == nil {
+				// The worker is released before the detail pages of the
+				// products are fetched, which need workers of their own
+				if !semaphore.acquire(ctx) {
 					return
 				}
-
-				p, nextURL, err := bs.ParseHTML(htmlContent, url)
-				if err != nil {
-					log.Println("Error parsing HTML from", currentURL, ":", err)
+				p, nextURL, ok := bs.scrapePage(ctx, url, currentURL)
+				semaphore.release()
+				if !ok {
 					return
 				}
 
+				if bs.Config.Detail != nil {
+					p = bs.addDetails(ctx, p, semaphore)
+				}
+
 				productChan <- p
 
 				if nextURL == "" {
@@ -98,66 +132,132 @@ func (bs *BaseScraper) Scrape(maxWorkers int) ([]models.Product, error) {
 		products = append(products, p...)
 	}
 
-	return products, nil
+	return products, ctx.Err()
+}
+
+// workers is a semaphore limiting the number of pages fetched concurrently
+type workers chan struct{}
+
+// acquire takes a worker, failing when ctx is done first
+func (w workers) acquire(ctx context.Context) bool {
+	select {
+	case w <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w workers) release() {
+	<-w
+}
+
+// scrapePage fetches and parses the page at currentURL of the configured URL
+// url, returning its products and the URL of the next page
+func (bs *BaseScraper) scrapePage(ctx context.Context, url, currentURL string) ([]models.Product, string, bool) {
+	if !bs.robotsAllowed(ctx, currentURL) {
+		return nil, "", false
+	}
+
+	log.Println("Scraping", currentURL)
+
+	htmlContent, err := bs.getHTML(ctx, currentURL)
+	if err != nil {
+		log.Println("Error scraping", currentURL, ":", err)
+		return nil, "", false
+	}
+
+	p, nextURL, err := bs.pageParser().ParseHTML(htmlContent, url)
+	if err != nil {
+		log.Println("Error parsing HTML from", currentURL, ":", err)
+		return nil, "", false
+	}
+	return p, nextURL, true
+}
+
+// robotsAllowed reports whether currentURL may be scraped, logging and
+// reporting it as skipped when robots.txt disallows it. Everything is allowed
+// unless robots.txt is honoured.
+func (bs *BaseScraper) robotsAllowed(ctx context.Context, currentURL string) bool {
+	if bs.Shared == nil || bs.Shared.Robots == nil {
+		return true
+	}
+	allowed, reason, err := bs.Shared.Robots.Allowed(ctx, currentURL, bs.robotsUserAgent())
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Println("Error checking robots.txt for", currentURL, ":", err)
+		}
+		return false
+	}
+	if !allowed {
+		log.Printf("Skipping %s for %s: %s", currentURL, bs.Config.ShopName, reason)
+		reportFrom(ctx).skip(bs.Config.ShopName, currentURL, reason)
+	}
+	return allowed
+}
+
+// robotsUserAgent returns the user agent robots.txt is honoured for, the
+// configured robots user agent or otherwise the one of the scraper's
+// requests. JavaScript scrapers don't use the HTTP options, so they use the
+// default of the robots cache.
+func (bs *BaseScraper) robotsUserAgent() string {
+	if bs.Shared.RobotsUserAgent != "" || bs.Config.Type == "JavaScriptWebShopScraper" {
+		return bs.Shared.RobotsUserAgent
+	}
+	return bs.httpConfig().UserAgent
 }
 
 type JavaScriptWebShopScraper struct {
 	BaseScraper
 }
 
-func NewJavaScriptWebShopScraper(config config.ScraperConfig) *JavaScriptWebShopScraper {
+func NewJavaScriptWebShopScraper(config config.ScraperConfig, shared *Shared) *JavaScriptWebShopScraper {
 	js := &JavaScriptWebShopScraper{
 		BaseScraper: BaseScraper{
 			Config: config,
+			Shared: shared,
 		},
 	}
 	js.HTMLGetter = js
 	return js
 }
 
-func (js *JavaScriptWebShopScraper) GetHTML(currentURL string, attempts ...int) (string, error) {
-	attempt := 1
-	if len(attempts) > 0 {
-		attempt = attempts[0]
-	}
-	// create a new context
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+func (js *JavaScriptWebShopScraper) GetHTML(ctx context.Context, currentURL string) (string, error) {
+	return js.load(ctx, currentURL, newPageWait(js.Config), newPageLoadMore(js.Config))
+}
 
-	// Create a new browser window
-	ctx, cancel = chromedp.NewContext(ctx)
-	defer cancel()
+// GetDetailHTML loads a detail page with the wait conditions of the detail
+// configuration, without loading more items, as the elements of the list
+// pages are missing on it
+func (js *JavaScriptWebShopScraper) GetDetailHTML(ctx context.Context, currentURL string) (string, error) {
+	return js.load(ctx, currentURL, newDetailPageWait(js.Config), pageLoadMore{})
+}
 
-	var htmlContent string
+func (js *JavaScriptWebShopScraper) load(ctx context.Context, currentURL string, wait pageWait, more pageLoadMore) (string, error) {
+	if err := js.waitForHost(ctx, currentURL); err != nil {
+		return "", err
+	}
 
-	err := chromedp.Run(ctx,
-		chromedp.Navigate(currentURL),
-		chromedp.Sleep(5*time.Second), // Wait for JavaScript to render
-		chromedp.WaitVisible(`body`, chromedp.ByQuery),
-		chromedp.OuterHTML("html", &htmlContent),
-	)
+	pool := js.browserPool()
+	tab, err := pool.acquire(ctx)
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			if attempt <= MaxRetryAttempts {
-				log.Println("Timed out, retrying", currentURL)
-				return js.GetHTML(currentURL, attempt+1)
-			} else {
-				return "", err
-			}
-		} else {
-			return "", err
+		return "", err
+	}
+	htmlContent, err := tab.load(ctx, currentURL, wait, more)
+	// A tab that failed to load a page may be left in any state
+	pool.release(tab, err == nil)
+	if err != nil {
+		// Only retry when the page timed out, not when the run was cancelled
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return "", &RetryableError{Err: fmt.Errorf("timed out loading %s: %w", currentURL, err)}
 		}
+		return "", err
 	}
 
 	// retry string being present means loading failed
 	if js.Config.RetryString != "" {
 		if strings.Contains(htmlContent, js.Config.RetryString) {
-			if attempt <= MaxRetryAttempts {
-				log.Println("Data did not load correctly, retrying", currentURL)
-				return js.GetHTML(currentURL, attempt+1)
-			} else {
-				return "", fmt.Errorf("data did not load correctly on %s", currentURL)
-			}
+			return "", &RetryableError{Err: fmt.Errorf("data did not load correctly on %s", currentURL)}
 		}
 	}
 
@@ -169,48 +269,68 @@ type WebShopScraper struct {
 }
 
 // NewWebShopScraper creates a new instance of WebShopScraper
-func NewWebShopScraper(config config.ScraperConfig) *WebShopScraper {
+func NewWebShopScraper(config config.ScraperConfig, shared *Shared) *WebShopScraper {
 	ws := &WebShopScraper{
 		BaseScraper: BaseScraper{
 			Config: config,
+			Shared: shared,
 		},
 	}
 	ws.HTMLGetter = ws
 	return ws
 }
 
-func (ws *WebShopScraper) GetHTML(currentURL string, attempts ...int) (string, error) {
-	resp, err := http.Get(currentURL)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch URL %s: status code %d", currentURL, resp.StatusCode)
-	}
-
-	htmlContent, err := io.ReadAll(io.Reader(resp.Body))
-	if err != nil {
-		return "", err
-	}
-
-	return string(htmlContent), nil
+func (ws *WebShopScraper) GetHTML(ctx context.Context, currentURL string) (string, error) {
+	return ws.fetch(ctx, currentURL, "")
 }
 
-func CreateScrapers(config []config.ScraperConfig) ([]Scraper, error) {
+// CreateScrapers creates the scrapers of the configuration, sharing the state
+// in shared. Without shared state the scrapers are not rate limited.
+func CreateScrapers(config []config.ScraperConfig, shared *Shared) ([]Scraper, error) {
 	var scrapers []Scraper
 	for _, scraperConfig := range config {
 		switch scraperConfig.Type {
 		case "WebShopScraper":
-			scraper := NewWebShopScraper(scraperConfig)
+			scraper := NewWebShopScraper(scraperConfig, shared)
 			scrapers = append(scrapers, scraper)
 		case "JavaScriptWebShopScraper":
-			scraper := NewJavaScriptWebShopScraper(scraperConfig)
+			scraper := NewJavaScriptWebShopScraper(scraperConfig, shared)
+			scrapers = append(scrapers, scraper)
+		case "JSONAPIScraper":
+			scraper := NewJSONAPIScraper(scraperConfig, shared)
 			scrapers = append(scrapers, scraper)
 		default:
 			return nil, fmt.Errorf("unknown scraper type '%s'", scraperConfig.Type)
 		}
+
+		if _, err := NewPriceParser(scraperConfig); err != nil {
+			return nil, fmt.Errorf("invalid configuration for '%s': %w", scraperConfig.ShopName, err)
+		}
+		httpConfig := scraperConfig.HTTP
+		if shared != nil {
+			httpConfig = httpConfig.Merge(shared.HTTP)
+		}
+		if _, err := newTransport(httpConfig); err != nil {
+			return nil, fmt.Errorf("invalid configuration for '%s': %w", scraperConfig.ShopName, err)
+		}
+		if !validExtractionMode(scraperConfig.ExtractionMode) {
+			return nil, fmt.Errorf("invalid configuration for '%s': unknown extraction mode '%s', expected selectors or structured", scraperConfig.ShopName, scraperConfig.ExtractionMode)
+		}
+		if scraperConfig.Detail != nil && !validExtractionMode(scraperConfig.Detail.ExtractionMode) {
+			return nil, fmt.Errorf("invalid configuration for '%s': unknown detail extraction mode '%s', expected selectors or structured", scraperConfig.ShopName, scraperConfig.Detail.ExtractionMode)
+		}
+		if !validPriceFormat(scraperConfig.PriceFormat) {
+			return nil, fmt.Errorf("invalid configuration for '%s': unknown price format '%s', expected reverse or a priceLocale", scraperConfig.ShopName, scraperConfig.PriceFormat)
+		}
+		if !validMaxItems(scraperConfig) {
+			return nil, fmt.Errorf("invalid configuration for '%s': maxItems requires an itemSelector to count the items", scraperConfig.ShopName)
+		}
+		if !validDetail(scraperConfig) {
+			return nil, fmt.Errorf("invalid configuration for '%s': detail is not supported by %s", scraperConfig.ShopName, scraperConfig.Type)
+		}
+		if !models.ValidIdentity(scraperConfig.Identity) {
+			return nil, fmt.Errorf("invalid configuration for '%s': unknown identity '%s'", scraperConfig.ShopName, scraperConfig.Identity)
+		}
 	}
 	return scrapers, nil
 
@@ -1,7 +1,11 @@
 package scraper
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"shopscraper/pkg/config"
+	"sync/atomic"
 	"testing"
 )
 
@@ -15,14 +19,17 @@ func TestCreateScrapers(t *testing.T) {
 			{
 				Type: "JavaScriptWebShopScraper",
 			},
+			{
+				Type: "JSONAPIScraper",
+			},
 		},
 	}
 
 	// Call the CreateScrapers function with the test configuration
-	scrapers, _ := CreateScrapers(testConfig.Scrapers)
+	scrapers, _ := CreateScrapers(testConfig.Scrapers, nil)
 
 	// Assert the expected number of scrapers
-	expectedScrapers := 2
+	expectedScrapers := 3
 	if len(scrapers) != expectedScrapers {
 		t.Errorf("Expected %d scrapers, but got %d", expectedScrapers, len(scrapers))
 	}
@@ -34,12 +41,15 @@ func TestCreateScrapers(t *testing.T) {
 	if _, ok := scrapers[1].(*JavaScriptWebShopScraper); !ok {
 		t.Errorf("Expected scraper at index 1 to be of type JavaScriptWebShopScraper")
 	}
+	if _, ok := scrapers[2].(*JSONAPIScraper); !ok {
+		t.Errorf("Expected scraper at index 2 to be of type JSONAPIScraper")
+	}
 
 	unknownScraper := config.ScraperConfig{
 		Type: "UnknownScraper",
 	}
 	testConfig.Scrapers = append(testConfig.Scrapers, unknownScraper)
-	_, err := CreateScrapers(testConfig.Scrapers)
+	_, err := CreateScrapers(testConfig.Scrapers, nil)
 
 	// Assert the error for unknown scraper type
 	if err == nil {
@@ -49,4 +59,85 @@ func TestCreateScrapers(t *testing.T) {
 	if err.Error() != expectedErrorMessage {
 		t.Errorf("Expected error message '%s', but got '%s'", expectedErrorMessage, err.Error())
 	}
+
+	// Assert the error for unknown extraction modes and price formats, which
+	// should not fall back to the defaults, and for options that would be ignored
+	invalidModes := map[string]config.ScraperConfig{
+		"invalid configuration for 'Shop1': unknown extraction mode 'jsonld', expected selectors or structured":        {Type: "WebShopScraper", ShopName: "Shop1", ExtractionMode: "jsonld"},
+		"invalid configuration for 'Shop1': unknown detail extraction mode 'jsonld', expected selectors or structured": {Type: "WebShopScraper", ShopName: "Shop1", Detail: &config.DetailConfig{ExtractionMode: "jsonld"}},
+		"invalid configuration for 'Shop1': unknown price format 'german', expected reverse or a priceLocale":          {Type: "WebShopScraper", ShopName: "Shop1", PriceFormat: "german"},
+		"invalid configuration for 'Shop1': unknown price format 'double_eur', expected reverse or a priceLocale":      {Type: "WebShopScraper", ShopName: "Shop1", PriceFormat: "double_eur"},
+		"invalid configuration for 'Shop1': maxItems requires an itemSelector to count the items":                      {Type: "JavaScriptWebShopScraper", ShopName: "Shop1", ExtractionMode: "structured", MaxItems: 10},
+	}
+	for expectedErrorMessage, scraperConfig := range invalidModes {
+		_, err := CreateScrapers([]config.ScraperConfig{scraperConfig}, nil)
+		if err == nil || err.Error() != expectedErrorMessage {
+			t.Errorf("Expected error message '%s', but got '%v'", expectedErrorMessage, err)
+		}
+	}
+}
+
+// pagingHTMLGetter serves an endless list of pages, each linking to the next,
+// and calls onFetch after every fetched page
+type pagingHTMLGetter struct {
+	fetched atomic.Int32
+	onFetch func()
+}
+
+func (g *pagingHTMLGetter) GetHTML(ctx context.Context, currentURL string) (string, error) {
+	page := g.fetched.Add(1)
+	if g.onFetch != nil {
+		g.onFetch()
+	}
+	return fmt.Sprintf(`<div class="item"><div class="name">Product</div><div class="price">1,00 €</div><a class="link" href="/product">Link</a></div>
+		<a class="next" href="/page%d">Next</a>`, page), nil
+}
+
+func TestScrapeContext(t *testing.T) {
+	cfg := config.ScraperConfig{
+		URLs:             []string{"http://example.com/page0"},
+		ItemSelector:     ".item",
+		NameSelector:     ".name",
+		LinkSelector:     ".link",
+		NextPageSelector: ".next",
+		PriceSelector:    []string{".price"},
+		ShopName:         "Test Shop",
+	}
+
+	// Test case 1: A cancelled context fetches nothing
+	getter := &pagingHTMLGetter{}
+	bs := &BaseScraper{Config: cfg, HTMLGetter: getter}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	products, err := bs.Scrape(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got %v", err)
+	}
+	if len(products) != 0 || getter.fetched.Load() != 0 {
+		t.Errorf("Expected no pages to be fetched, but fetched %d", getter.fetched.Load())
+	}
+
+	// Test case 2: Cancelling during pagination stops after the current page
+	// and returns the products scraped so far
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	getter = &pagingHTMLGetter{}
+	getter.onFetch = func() {
+		if getter.fetched.Load() == 3 {
+			cancel()
+		}
+	}
+	bs = &BaseScraper{Config: cfg, HTMLGetter: getter}
+
+	products, err = bs.Scrape(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got %v", err)
+	}
+	if getter.fetched.Load() != 3 {
+		t.Errorf("Expected 3 pages to be fetched, but fetched %d", getter.fetched.Load())
+	}
+	if len(products) != 3 {
+		t.Errorf("Expected 3 products, but got %d", len(products))
+	}
 }
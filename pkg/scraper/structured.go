@@ -0,0 +1,387 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"shopscraper/pkg/models"
+	"shopscraper/pkg/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	// ExtractionModeSelectors extracts products using the configured CSS selectors
+	ExtractionModeSelectors = "selectors"
+	// ExtractionModeStructured extracts products from schema.org JSON-LD data,
+	// falling back to the CSS selectors when a page has none
+	ExtractionModeStructured = "structured"
+)
+
+// validExtractionMode reports whether mode is empty, which selects the CSS
+// selectors, or a known extraction mode
+func validExtractionMode(mode string) bool {
+	return mode == "" || mode == ExtractionModeSelectors || mode == ExtractionModeStructured
+}
+
+// structuredOffer holds the fields read from a schema.org Offer or AggregateOffer
+type structuredOffer struct {
+	price        string
+	currency     string
+	availability string
+	url          string
+}
+
+// parseStructuredData extracts products from all application/ld+json blocks
+// in the document. Product nodes are collected from anywhere in the data,
+// which covers plain Products, @graph arrays and ItemList/ListItem wrappers.
+func (bs *BaseScraper) parseStructuredData(doc *goquery.Document, fetchedUrl string) []models.Product {
+	var blocks []interface{}
+	doc.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		decoder := json.NewDecoder(strings.NewReader(s.Text()))
+		decoder.UseNumber()
+
+		var data interface{}
+		if err := decoder.Decode(&data); err != nil {
+			log.Printf("Failed to decode structured data on %s: %v", fetchedUrl, err)
+			return
+		}
+		blocks = append(blocks, data)
+	})
+
+	// ListItems may refer to a product described elsewhere on the page
+	ids := make(map[string]map[string]interface{})
+	for _, data := range blocks {
+		indexStructuredIDs(data, ids)
+	}
+	var nodes []map[string]interface{}
+	for _, data := range blocks {
+		nodes = append(nodes, findProductNodes(data, ids)...)
+	}
+
+	var products []models.Product
+	for _, node := range nodes {
+		product, ok := bs.structuredProduct(node, fetchedUrl)
+		if ok {
+			products = appendUnique(products, product)
+		}
+	}
+	return products
+}
+
+func (bs *BaseScraper) structuredProduct(node map[string]interface{}, fetchedUrl string) (models.Product, bool) {
+	itemName := strings.Trim(structuredString(node["name"]), "-. \t\n")
+
+	offer, price, hasOffer := lowestOffer(node["offers"], bs.currency())
+
+	// A product without its own URL is usually the product page that was fetched
+	itemLink := structuredString(node["url"])
+	if itemLink == "" {
+		itemLink = offer.url
+	}
+	if itemLink == "" {
+		itemLink = fetchedUrl
+	}
+	itemLink, err := utils.EnsureFullUrl(itemLink, fetchedUrl, bs.Config.UniqueParameters, bs.Config.RemoveFragment)
+	if err != nil {
+		log.Printf("Failed to get full URL %v", err)
+	}
+
+	if itemName == "" || itemLink == "" {
+		return models.Product{}, false
+	}
+
+	var itemPrice int
+	if hasOffer {
+		itemPrice = price
+	}
+
+	itemCurrency := offer.currency
+	if itemCurrency == "" {
+		itemCurrency = bs.currency()
+	}
+
+	return bs.identify(models.Product{
+		Name:         itemName,
+		Shop:         bs.Config.ShopName,
+		Price:        itemPrice,
+		Currency:     itemCurrency,
+		Availability: offer.availability,
+		Link:         itemLink,
+		SKU:          structuredSKU(node),
+		Brand:        structuredName(node["brand"]),
+		Image:        fullImageURL(structuredImage(node["image"]), fetchedUrl),
+		Description:  collapseSpace(structuredString(node["description"])),
+		LastSeen:     time.Now().UTC(),
+		Notified:     false,
+	}), true
+}
+
+// structuredSKU returns the sku of a schema.org Product, or one of its GTINs
+// or its MPN when the sku is missing
+func structuredSKU(node map[string]interface{}) string {
+	for _, key := range []string{"sku", "gtin", "gtin13", "gtin14", "gtin12", "gtin8", "mpn"} {
+		if sku := structuredString(node[key]); sku != "" {
+			return sku
+		}
+	}
+	return ""
+}
+
+// structuredImage returns the URL of the first image of a schema.org Product,
+// given as a URL, an ImageObject or a list of either
+func structuredImage(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) > 0 {
+			return structuredImage(v[0])
+		}
+	case map[string]interface{}:
+		if image := structuredString(v["url"]); image != "" {
+			return image
+		}
+		return structuredString(v["contentUrl"])
+	}
+	return structuredString(value)
+}
+
+// structuredName returns the name of a schema.org Brand or Organization,
+// given as text, an object or a list of either. Unlike structuredString it
+// ignores the @id, which is a URL rather than a name.
+func structuredName(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) > 0 {
+			return structuredName(v[0])
+		}
+	case map[string]interface{}:
+		return structuredString(v["name"])
+	}
+	return structuredString(value)
+}
+
+// findProductNodes walks decoded JSON-LD and returns every schema.org Product,
+// without descending into the products themselves so that related products
+// and variants are not reported as separate items. ListItems referring to
+// their item by @id are resolved with ids.
+func findProductNodes(data interface{}, ids map[string]map[string]interface{}) []map[string]interface{} {
+	var nodes []map[string]interface{}
+	switch v := data.(type) {
+	case []interface{}:
+		for _, child := range v {
+			nodes = append(nodes, findProductNodes(child, ids)...)
+		}
+	case map[string]interface{}:
+		if hasSchemaType(v, "Product") {
+			return []map[string]interface{}{v}
+		}
+		if hasSchemaType(v, "ListItem") {
+			if node, ok := referencedProduct(v, ids); ok {
+				return []map[string]interface{}{node}
+			}
+		}
+		for _, key := range sortedKeys(v) {
+			nodes = append(nodes, findProductNodes(v[key], ids)...)
+		}
+	}
+	return nodes
+}
+
+// indexStructuredIDs adds the nodes of decoded JSON-LD that have an @id and
+// describe more than their @id to ids
+func indexStructuredIDs(data interface{}, ids map[string]map[string]interface{}) {
+	switch v := data.(type) {
+	case []interface{}:
+		for _, child := range v {
+			indexStructuredIDs(child, ids)
+		}
+	case map[string]interface{}:
+		if id, ok := v["@id"].(string); ok && id != "" && len(v) > 1 {
+			ids[id] = v
+		}
+		for _, key := range sortedKeys(v) {
+			indexStructuredIDs(v[key], ids)
+		}
+	}
+}
+
+// sortedKeys returns the keys of a JSON object in order, as maps are
+// unordered and the products and the node of a repeated @id must be stable
+func sortedKeys(v map[string]interface{}) []string {
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// referencedProduct returns the product a ListItem refers to by the @id or
+// URL of its item. A product that isn't described on the page is made of the
+// name of the ListItem and the reference as its URL.
+func referencedProduct(listItem map[string]interface{}, ids map[string]map[string]interface{}) (map[string]interface{}, bool) {
+	var ref string
+	switch item := listItem["item"].(type) {
+	case string:
+		ref = item
+	case map[string]interface{}:
+		id, ok := item["@id"].(string)
+		if !ok || len(item) != 1 {
+			return nil, false
+		}
+		ref = id
+	}
+	if ref == "" {
+		return nil, false
+	}
+
+	if node, ok := ids[ref]; ok {
+		if hasSchemaType(node, "Product") {
+			return node, true
+		}
+		return nil, false
+	}
+	if structuredString(listItem["name"]) == "" {
+		return nil, false
+	}
+	return map[string]interface{}{"@type": "Product", "name": listItem["name"], "url": ref}, true
+}
+
+// lowestOffer returns the cheapest offer and its price out of a single Offer,
+// an AggregateOffer or a list of offers, reading prices without a
+// priceCurrency in defaultCurrency
+func lowestOffer(data interface{}, defaultCurrency string) (structuredOffer, int, bool) {
+	var best structuredOffer
+	bestPrice := -1
+
+	var consider func(data interface{})
+	consider = func(data interface{}) {
+		switch v := data.(type) {
+		case []interface{}:
+			for _, child := range v {
+				consider(child)
+			}
+		case map[string]interface{}:
+			if hasSchemaType(v, "AggregateOffer") {
+				if nested, ok := v["offers"]; ok {
+					consider(nested)
+				}
+			}
+
+			offer := structuredOffer{
+				price:        structuredString(v["price"]),
+				currency:     structuredString(v["priceCurrency"]),
+				availability: normalizeAvailability(structuredString(v["availability"])),
+				url:          structuredString(v["url"]),
+			}
+			if offer.price == "" {
+				offer.price = structuredString(v["lowPrice"])
+			}
+			if spec, ok := v["priceSpecification"].(map[string]interface{}); ok {
+				if offer.price == "" {
+					offer.price = structuredString(spec["price"])
+				}
+				if offer.currency == "" {
+					offer.currency = structuredString(spec["priceCurrency"])
+				}
+			}
+			if offer.price == "" {
+				return
+			}
+
+			currency := offer.currency
+			if currency == "" {
+				currency = defaultCurrency
+			}
+			price, err := parseStructuredPrice(offer.price, currency)
+			if err != nil {
+				log.Println("Error converting structured price to integer", offer.price)
+				return
+			}
+			if bestPrice == -1 || price < bestPrice {
+				bestPrice = price
+				best = offer
+			}
+		}
+	}
+	consider(data)
+
+	return best, bestPrice, bestPrice != -1
+}
+
+// parseStructuredPrice parses a schema.org price to minor units of currency.
+// These always use "." as the decimal separator regardless of the shop's
+// display format.
+func parseStructuredPrice(price string, currency string) (int, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(price), 64)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("invalid price '%s'", price)
+	}
+	return models.MinorUnits(value, currency), nil
+}
+
+// hasSchemaType reports whether the @type of node is schemaType, which may be
+// written with a schema.org prefix such as "schema:" or "https://schema.org/"
+func hasSchemaType(node map[string]interface{}, schemaType string) bool {
+	switch v := node["@type"].(type) {
+	case string:
+		return normalizeSchemaType(v) == schemaType
+	case []interface{}:
+		for _, t := range v {
+			if s, ok := t.(string); ok && normalizeSchemaType(s) == schemaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeSchemaType strips the schema.org prefix of a type, e.g. "Product"
+// for "schema:Product" or "http://schema.org/Product"
+func normalizeSchemaType(schemaType string) string {
+	lower := strings.ToLower(schemaType)
+	for _, prefix := range []string{"https://schema.org/", "http://schema.org/", "schema:"} {
+		if strings.HasPrefix(lower, prefix) {
+			return schemaType[len(prefix):]
+		}
+	}
+	return schemaType
+}
+
+// structuredString converts a JSON-LD value to a string, following the
+// schema.org convention of wrapping values in objects with an @id or name
+func structuredString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	case []interface{}:
+		if len(v) > 0 {
+			return structuredString(v[0])
+		}
+	case map[string]interface{}:
+		if id := structuredString(v["@id"]); id != "" {
+			return id
+		}
+		return structuredString(v["name"])
+	}
+	return ""
+}
+
+// normalizeAvailability turns schema.org availability URLs such as
+// "https://schema.org/InStock" into their short form "InStock", other values
+// such as the text of an availability selector are kept
+func normalizeAvailability(availability string) string {
+	if i := strings.Index(strings.ToLower(availability), "schema.org/"); i != -1 {
+		availability = availability[i+len("schema.org/"):]
+	}
+	return availability
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"strings"
@@ -87,3 +88,17 @@ func GetPastTimeThreshold(duration time.Duration) time.Time {
 
 	return threshold
 }
+
+// SleepContext waits for the duration to pass and reports whether it did,
+// returning false as soon as ctx is done
+func SleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -148,3 +149,24 @@ func TestGetPastTimeThreshold(t *testing.T) {
 		})
 	}
 }
+
+func TestSleepContext(t *testing.T) {
+	// Test case 1: The full duration passes
+	if !SleepContext(context.Background(), time.Millisecond) {
+		t.Errorf("Expected the sleep to complete")
+	}
+
+	// Test case 2: Cancelling the context ends the sleep early
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if SleepContext(ctx, time.Hour) {
+		t.Errorf("Expected the sleep to be interrupted")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the sleep to end when the context was cancelled, but it took %s", elapsed)
+	}
+}